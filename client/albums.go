@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Album is a record in the catalogue.
+type Album struct {
+	ID     string  `json:"id,omitempty"`
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+	// Version goes up with every change. UpdateAlbum sends it to make
+	// sure the album has not changed since it was read.
+	Version int `json:"version,omitempty"`
+}
+
+// AlbumPage is one page of a listing.
+type AlbumPage struct {
+	Albums []Album `json:"data"`
+	// Total is how many albums match the filters across all pages.
+	Total int `json:"total"`
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
+
+// ListOptions selects the albums ListAlbums returns. Zero fields are left
+// to the server's defaults.
+type ListOptions struct {
+	Page, Limit int
+	// Sort is id, title, artist or price, optionally followed by ":asc"
+	// or ":desc".
+	Sort               string
+	Artist, Title      string
+	MinPrice, MaxPrice *float64
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Page > 0 {
+		v.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+	for key, s := range map[string]string{"sort": o.Sort, "artist": o.Artist, "title": o.Title} {
+		if s != "" {
+			v.Set(key, s)
+		}
+	}
+	if o.MinPrice != nil {
+		v.Set("min_price", strconv.FormatFloat(*o.MinPrice, 'g', -1, 64))
+	}
+	if o.MaxPrice != nil {
+		v.Set("max_price", strconv.FormatFloat(*o.MaxPrice, 'g', -1, 64))
+	}
+	return v
+}
+
+// Job is a background job started by CreateAlbumsAsync.
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Result is the job's output once it has succeeded.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Done reports whether the job has finished, successfully or not.
+func (j *Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// ListAlbums returns the page of albums opts selects.
+func (c *Client) ListAlbums(ctx context.Context, opts ListOptions) (*AlbumPage, error) {
+	var page AlbumPage
+	if err := c.do(ctx, http.MethodGet, "/v1/albums", opts.values(), nil, &page, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetAlbum returns the album with the given ID.
+func (c *Client) GetAlbum(ctx context.Context, id string) (*Album, error) {
+	var a Album
+	if err := c.do(ctx, http.MethodGet, "/v1/albums/"+url.PathEscape(id), nil, nil, &a, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// CreateAlbum adds a and returns it with its assigned ID.
+func (c *Client) CreateAlbum(ctx context.Context, a Album) (*Album, error) {
+	var created Album
+	if err := c.do(ctx, http.MethodPost, "/v1/albums", nil, a, &created, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CreateAlbums adds every album in list, or none if any is invalid.
+func (c *Client) CreateAlbums(ctx context.Context, list []Album) ([]Album, error) {
+	var created []Album
+	if err := c.do(ctx, http.MethodPost, "/v1/albums", nil, list, &created, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// CreateAlbumsAsync queues the albums in list to be added in the
+// background. Poll GetJob until the job is Done.
+func (c *Client) CreateAlbumsAsync(ctx context.Context, list []Album) (*Job, error) {
+	var j Job
+	q := url.Values{"async": {"true"}}
+	if err := c.do(ctx, http.MethodPost, "/v1/albums", q, list, &j, http.StatusAccepted); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetJob returns the background job with the given ID.
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	if err := c.do(ctx, http.MethodGet, "/v1/jobs/"+url.PathEscape(id), nil, nil, &j, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// UpdateAlbum replaces the album with the given ID by a, which must carry
+// the Version it was read at. If the album has changed since, the error
+// matches ErrConflict; read it again and retry.
+func (c *Client) UpdateAlbum(ctx context.Context, id string, a Album) (*Album, error) {
+	var updated Album
+	if err := c.do(ctx, http.MethodPut, "/v1/albums/"+url.PathEscape(id), nil, a, &updated, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteAlbum deletes the album with the given ID.
+func (c *Client) DeleteAlbum(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/albums/"+url.PathEscape(id), nil, nil, nil, http.StatusNoContent)
+}
+
+// Token is a bearer token issued by Login.
+type Token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Login exchanges a username and password for a bearer token; pass it to
+// a new client with WithToken.
+func (c *Client) Login(ctx context.Context, username, password string) (*Token, error) {
+	var t Token
+	body := map[string]string{"username": username, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/v1/login", nil, body, &t, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
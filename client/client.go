@@ -0,0 +1,143 @@
+// Package client calls the albums API from Go programs.
+//
+//	c, err := client.New("http://localhost:8080", client.WithAPIKey(key))
+//	if err != nil { ... }
+//	page, err := c.ListAlbums(ctx, client.ListOptions{Artist: "John Coltrane"})
+//
+// Failed calls return an *Error describing the problem the server sent;
+// use errors.Is with ErrNotFound and the other sentinels to tell them
+// apart.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls the API at one base URL. It is safe for concurrent use.
+type Client struct {
+	base      *url.URL
+	http      *http.Client
+	userAgent string
+	auth      func(*http.Request)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of
+// http.DefaultClient, e.g. to set a timeout or a custom transport.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTransport sends requests through rt.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		hc := *c.http
+		hc.Transport = rt
+		c.http = &hc
+	}
+}
+
+// WithAPIKey authenticates requests with an API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) { r.Header.Set("X-API-Key", key) }
+	}
+}
+
+// WithToken authenticates requests with a bearer token, such as one from
+// Login.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client for the API served at baseURL, such as
+// "https://albums.example.com". Versioned paths are resolved under /v1.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: parse base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL %q must be http or https", baseURL)
+	}
+	c := &Client{base: u, http: http.DefaultClient, userAgent: "albums-go-client"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// do sends a request to path with in, if not nil, as the JSON body and
+// decodes a successful response into out, if not nil. Responses other
+// than want are returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any, want int) error {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("client: encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("client: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return newError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("client: decode %s %s response: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// Health reports whether the server process is up.
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/healthz", nil, nil, nil, http.StatusOK)
+}
+
+// Ready reports whether the server can take traffic: it is not shutting
+// down and its album store can be reached.
+func (c *Client) Ready(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/readyz", nil, nil, nil, http.StatusOK)
+}
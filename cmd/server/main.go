@@ -0,0 +1,315 @@
+// Command server runs the albums API, and the tasks operating it needs:
+// migrating the database, listing routes and checking the configuration.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/handlers"
+	"pspFileAPI/internal/health"
+	"pspFileAPI/internal/notify"
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+// version is the release built, set with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// command is a subcommand. It is passed the configuration, the operands
+// before the first flag and a function loading the configuration again.
+type command struct {
+	usage   string
+	summary string
+	// noConfig commands run without loading the configuration.
+	noConfig bool
+	run      func(cfg config.Config, operands []string, load func() (config.Config, error)) error
+}
+
+// commands are the subcommands by name; serve runs when none is given.
+var commands map[string]command
+
+// commandOrder is the order help lists commands in.
+var commandOrder = []string{"serve", "migrate", "routes", "config", "version", "help"}
+
+func init() {
+	commands = map[string]command{
+		"serve":   {usage: "serve", summary: "serve the API (the default)", run: serve},
+		"migrate": {usage: "migrate [up | down [n]]", summary: "apply pending database migrations, or undo the last n (default 1)", run: migrate},
+		"routes":  {usage: "routes", summary: "list the routes the configuration serves", run: routes},
+		"config":  {usage: "config validate", summary: "check the configuration and exit", run: validateConfig},
+		"version": {usage: "version", summary: "print the version and build", noConfig: true, run: printVersion},
+		"help":    {usage: "help", summary: "list the commands", noConfig: true, run: help},
+	}
+}
+
+// errUsage reports operands a command does not take.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+	// Operands precede the flags, as in "migrate down 2 -album-store sqlite".
+	n := 0
+	for n < len(args) && !strings.HasPrefix(args[n], "-") {
+		n++
+	}
+	operands, args := args[:n], args[n:]
+
+	if cmd.noConfig {
+		if err := cmd.run(config.Config{}, operands, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\nusage: albums %s\n", err, cmd.usage)
+			os.Exit(2)
+		}
+		return
+	}
+
+	load := func() (config.Config, error) { return config.Load(args, os.LookupEnv) }
+	cfg, err := load()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger, err := handlers.NewLogger(os.Stderr, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if err := cmd.run(cfg, operands, load); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "%v\nusage: albums %s [flags]\n", err, cmd.usage)
+			os.Exit(2)
+		}
+		slog.Error("exiting", "err", err)
+		os.Exit(1)
+	}
+}
+
+// app is the services the configuration describes and the server over
+// them.
+type app struct {
+	srv    *handlers.Server
+	albums *service.Albums
+	jobs   *service.Jobs
+	// closers release what open opened, in reverse order.
+	closers []func()
+}
+
+// open opens the repository and services and configures the server over
+// them. While serving, load is called again to reload the configuration.
+func open(cfg config.Config, load func() (config.Config, error)) (_ *app, err error) {
+	a := &app{}
+	defer func() {
+		if err != nil {
+			a.close()
+		}
+	}()
+
+	repo, err := repository.Open(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.closers = append(a.closers, func() { repo.Close() })
+
+	events := service.NewEvents()
+	a.jobs = service.NewJobs(cfg.JobWorkers, cfg.JobQueueSize, cfg.JobRetention)
+	a.albums = service.NewAlbums(repo, events)
+	checks := health.NewRegistry(cfg.ReadyTimeout)
+	checks.Register("album_store", true, repo.Ping)
+
+	// Publish album events to a message broker when one is configured.
+	if cfg.Broker != "" {
+		outbox, err := service.OpenOutbox(cfg, events)
+		if err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, func() { outbox.Close(cfg.ShutdownTimeout) })
+		checks.Register("broker", false, outbox.Ping)
+	}
+
+	// Email operators about the events NOTIFY_EVENTS names.
+	var notifier *notify.Notifier
+	if cfg.NotifyProvider != "" {
+		if notifier, err = notify.Open(cfg, events); err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, func() { notifier.Close(cfg.ShutdownTimeout) })
+	}
+
+	a.srv, err = handlers.NewServer(cfg, load, handlers.Services{Albums: a.albums, Events: events, Jobs: a.jobs, Health: checks, Notifier: notifier})
+	if err != nil {
+		return nil, err
+	}
+	a.closers = append(a.closers, a.srv.Close)
+	return a, nil
+}
+
+// close releases what open opened.
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+}
+
+// serve serves the API until the server shuts down.
+func serve(cfg config.Config, operands []string, load func() (config.Config, error)) error {
+	if len(operands) > 0 {
+		return errUsage
+	}
+	a, err := open(cfg, load)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	err = a.srv.Serve()
+	// Requests have finished; let the jobs they queued finish too before
+	// the server closes.
+	a.jobs.Drain(cfg.ShutdownTimeout)
+	return err
+}
+
+// migrate applies pending migrations, or with "down [n]" undoes the n
+// most recently applied.
+func migrate(cfg config.Config, operands []string, _ func() (config.Config, error)) error {
+	switch {
+	case len(operands) == 0 || len(operands) == 1 && operands[0] == "up":
+		return repository.Migrate(context.Background(), cfg)
+	case operands[0] == "down" && len(operands) <= 2:
+		steps := 1
+		if len(operands) == 2 {
+			n, err := strconv.Atoi(operands[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("%w: migrate down takes a positive number of migrations", errUsage)
+			}
+			steps = n
+		}
+		return repository.MigrateDown(context.Background(), cfg, steps)
+	}
+	return errUsage
+}
+
+// routes prints the method, path and handler of every route the server
+// serves with cfg. The server is built offline over an empty in-memory
+// store, so the database is neither opened nor migrated and nothing, such
+// as the broker or the OIDC provider, is connected to.
+func routes(cfg config.Config, operands []string, load func() (config.Config, error)) error {
+	if len(operands) > 0 {
+		return errUsage
+	}
+	// In debug mode gin would print each route to stdout as well.
+	gin.SetMode(gin.ReleaseMode)
+	events := service.NewEvents()
+	svc := handlers.Services{
+		Albums:  service.NewAlbums(repository.NewMemory(nil), events),
+		Events:  events,
+		Jobs:    service.NewJobs(0, 0, cfg.JobRetention),
+		Offline: true,
+	}
+	srv, err := handlers.NewServer(cfg, load, svc)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER")
+	for _, r := range srv.Routes() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Method, r.Path, handlerName(r.Handler))
+	}
+	return w.Flush()
+}
+
+// handlerName shortens the name of a handler function to its package and
+// function, dropping the suffix of method values.
+func handlerName(name string) string {
+	name = strings.TrimSuffix(name, "-fm")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// printVersion prints the version, the commit built from and the Go
+// toolchain.
+func printVersion(_ config.Config, operands []string, _ func() (config.Config, error)) error {
+	if len(operands) > 0 {
+		return errUsage
+	}
+	fmt.Printf("albums %s", version)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings := make(map[string]string)
+		for _, s := range info.Settings {
+			settings[s.Key] = s.Value
+		}
+		if rev := settings["vcs.revision"]; rev != "" {
+			fmt.Printf(" (%s %s", rev, settings["vcs.time"])
+			if settings["vcs.modified"] == "true" {
+				fmt.Print(", modified")
+			}
+			fmt.Print(")")
+		}
+	}
+	fmt.Printf(" %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// validateConfig reports that the configuration is valid. It only runs if
+// the configuration loaded; otherwise main exits listing every problem.
+func validateConfig(cfg config.Config, operands []string, _ func() (config.Config, error)) error {
+	if len(operands) != 1 || operands[0] != "validate" {
+		return errUsage
+	}
+	source := "defaults, environment and flags"
+	if _, err := os.Stat(cfg.File()); err == nil {
+		source = cfg.File() + ", " + source
+	}
+	fmt.Printf("configuration is valid (%s)\n", source)
+	return nil
+}
+
+// help lists the commands.
+func help(_ config.Config, operands []string, _ func() (config.Config, error)) error {
+	if len(operands) > 0 {
+		return errUsage
+	}
+	printCommands(os.Stdout)
+	return nil
+}
+
+// printCommands writes the usage of every command to w.
+func printCommands(w io.Writer) {
+	fmt.Fprint(w, "Usage: albums [command] [flags]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range commandOrder {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun \"albums serve -h\" for the flags, which every command but version and help takes.")
+}
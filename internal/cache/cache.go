@@ -0,0 +1,137 @@
+// Package cache stores encoded values for a while, in memory or in Redis
+// so every instance shares them.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2/expirable"
+
+	"pspFileAPI/internal/config"
+)
+
+// Cache stores encoded values for a while. Implementations must be safe
+// for concurrent use.
+type Cache interface {
+	// Get returns the value stored under key, and false if there is none.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys.
+	Delete(ctx context.Context, keys ...string) error
+	// Incr adds one to the counter at key, which never expires.
+	Incr(ctx context.Context, key string) error
+	// Counter returns the counter at key, zero if it was never incremented.
+	Counter(ctx context.Context, key string) (int64, error)
+	// Close releases the cache's resources.
+	Close() error
+}
+
+// Sweeper is implemented by caches that keep expired entries until they
+// are swept. Redis drops them itself.
+type Sweeper interface {
+	// Sweep removes the expired entries and returns how many there were.
+	Sweep(ctx context.Context) (int, error)
+}
+
+// Open returns a Redis cache when REDIS_URL is set and otherwise a
+// local LRU cache of CACHE_SIZE entries that expire after ttl.
+func Open(ctx context.Context, cfg config.Config, ttl time.Duration) (Cache, error) {
+	if cfg.RedisURL == "" {
+		return newLRUCache(cfg.CacheSize, ttl), nil
+	}
+	return openRedisCache(ctx, cfg.RedisURL)
+}
+
+// Cached returns the value stored under key, or calls load and stores its
+// result for ttl. Cache failures are logged and fall through to load, so a
+// cache outage costs latency rather than errors. Errors from load are not
+// cached. The lookup is recorded in ctx's Status.
+func Cached[T any](ctx context.Context, c Cache, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	var v T
+	if b, ok, err := c.Get(ctx, key); err != nil {
+		slog.WarnContext(ctx, "cache get failed", "key", key, "err", err)
+	} else if ok && json.Unmarshal(b, &v) == nil {
+		Record(ctx, true)
+		return v, nil
+	}
+	Record(ctx, false)
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+	if b, err := json.Marshal(v); err == nil {
+		if err := c.Set(ctx, key, b, ttl); err != nil {
+			slog.WarnContext(ctx, "cache set failed", "key", key, "err", err)
+		}
+	}
+	return v, nil
+}
+
+// lruCache keeps the most recently used entries in memory.
+type lruCache struct {
+	entries *lru.LRU[string, []byte]
+
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// newLRUCache returns a cache holding up to size entries. Entries expire
+// after ttl; the LRU keeps one expiry for all of them.
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		entries:  lru.NewLRU[string, []byte](size, nil, ttl),
+		counters: make(map[string]int64),
+	}
+}
+
+func (c *lruCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	b, ok := c.entries.Get(key)
+	return b, ok, nil
+}
+
+func (c *lruCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.entries.Add(key, value)
+	return nil
+}
+
+func (c *lruCache) Delete(_ context.Context, keys ...string) error {
+	for _, k := range keys {
+		c.entries.Remove(k)
+	}
+	return nil
+}
+
+// Counters are kept apart from the entries so eviction cannot reset them.
+func (c *lruCache) Incr(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[key]++
+	return nil
+}
+
+func (c *lruCache) Counter(_ context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[key], nil
+}
+
+// Sweep drops expired entries at once. The LRU also drops them in the
+// background, but only a while after they expire.
+func (c *lruCache) Sweep(_ context.Context) (int, error) {
+	n := 0
+	for _, k := range c.entries.Keys() {
+		// Peek misses expired entries without touching live ones.
+		if _, ok := c.entries.Peek(k); !ok && c.entries.Remove(k) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c *lruCache) Close() error { return nil }
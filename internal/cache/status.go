@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// statusKey is the context key of a request's *Status.
+type statusKey struct{}
+
+// Status counts the hits and misses of the cache lookups made for one
+// request, for its X-Cache header. It is safe for concurrent use.
+type Status struct {
+	hits, misses atomic.Int64
+}
+
+// WithStatus returns a copy of ctx carrying a new Status, and the Status.
+func WithStatus(ctx context.Context) (context.Context, *Status) {
+	s := &Status{}
+	return context.WithValue(ctx, statusKey{}, s), s
+}
+
+// Record counts a lookup made for the request carrying ctx as a hit or a
+// miss. It does nothing when ctx carries no Status.
+func Record(ctx context.Context, hit bool) {
+	s, ok := ctx.Value(statusKey{}).(*Status)
+	if !ok {
+		return
+	}
+	if hit {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+	}
+}
+
+// String returns "HIT" if every lookup hit, "MISS" if any missed, and ""
+// if none was made.
+func (s *Status) String() string {
+	switch {
+	case s.misses.Load() > 0:
+		return "MISS"
+	case s.hits.Load() > 0:
+		return "HIT"
+	}
+	return ""
+}
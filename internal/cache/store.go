@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"pspFileAPI/internal/config"
+)
+
+// storeSweepInterval is how often a local store drops expired entries as
+// it is written to, besides any scheduled sweep.
+const storeSweepInterval = time.Minute
+
+// OpenStore returns a Redis cache when REDIS_URL is set and otherwise a
+// local store for state that must last its ttl, like sessions: unlike
+// Open's LRU, it never evicts entries to make room, and each expires
+// after the ttl it was set with.
+func OpenStore(ctx context.Context, cfg config.Config) (Cache, error) {
+	if cfg.RedisURL == "" {
+		return newTTLStore(), nil
+	}
+	return openRedisCache(ctx, cfg.RedisURL)
+}
+
+// ttlStore keeps entries in memory until they expire.
+type ttlStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]ttlEntry
+	counters  map[string]int64
+	lastSweep time.Time
+}
+
+// ttlEntry is a stored value and when it expires.
+type ttlEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newTTLStore() *ttlStore {
+	return &ttlStore{
+		now:       time.Now,
+		entries:   make(map[string]ttlEntry),
+		counters:  make(map[string]int64),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *ttlStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (s *ttlStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > storeSweepInterval {
+		s.sweep(now)
+		s.lastSweep = now
+	}
+	s.entries[key] = ttlEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *ttlStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.entries, k)
+	}
+	return nil
+}
+
+func (s *ttlStore) Incr(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[key]++
+	return nil
+}
+
+func (s *ttlStore) Counter(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[key], nil
+}
+
+// Sweep drops expired entries at once, rather than at the next sweep on
+// Set.
+func (s *ttlStore) Sweep(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sweep(s.now()), nil
+}
+
+// sweep drops the entries expired at now. s.mu must be held.
+func (s *ttlStore) sweep(now time.Time) int {
+	n := 0
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *ttlStore) Close() error { return nil }
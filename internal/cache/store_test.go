@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"pspFileAPI/internal/config"
+)
+
+func TestStoreIsNotBoundedByCacheSize(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenStore(ctx, config.Config{CacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		s.Set(ctx, fmt.Sprint("session:", i), []byte("v"), time.Hour)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok, _ := s.Get(ctx, fmt.Sprint("session:", i)); !ok {
+			t.Errorf("entry %d of 10 was evicted", i)
+		}
+	}
+}
+
+func TestStoreExpiresEntriesByTheirTTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTTLStore()
+	s.now, s.lastSweep = func() time.Time { return now }, now
+
+	s.Set(ctx, "short", []byte("v"), time.Minute)
+	s.Set(ctx, "long", []byte("v"), time.Hour)
+	now = now.Add(30 * time.Minute)
+	if _, ok, _ := s.Get(ctx, "short"); ok {
+		t.Error("entry outlived its ttl")
+	}
+	if _, ok, _ := s.Get(ctx, "long"); !ok {
+		t.Error("entry expired before its ttl")
+	}
+
+	if n, _ := s.Sweep(ctx); n != 1 {
+		t.Errorf("Sweep dropped %d entries, want 1", n)
+	}
+	now = now.Add(time.Hour)
+	// Writing sweeps too, once storeSweepInterval has passed.
+	s.Set(ctx, "new", []byte("v"), time.Hour)
+	if _, ok := s.entries["long"]; ok {
+		t.Error("expired entry kept after a write")
+	}
+}
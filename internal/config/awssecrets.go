@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// awsSecrets reads secrets from one AWS Secrets Manager secret holding a
+// JSON object whose keys are the setting names. Credentials come from the
+// standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// variables; AWS_ENDPOINT_URL overrides the regional endpoint.
+type awsSecrets struct {
+	endpoint, region, secretID string
+	accessKey, secretKey       string
+	sessionToken               string
+}
+
+// newAWSSecrets returns a provider reading secretID in region.
+func newAWSSecrets(region, secretID string) (*awsSecrets, error) {
+	a := &awsSecrets{
+		endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+		region:       region,
+		secretID:     secretID,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	switch {
+	case region == "" || secretID == "":
+		return nil, errors.New("SECRETS_PROVIDER aws needs AWS_REGION and AWS_SECRET_ID")
+	case a.accessKey == "" || a.secretKey == "":
+		return nil, errors.New("SECRETS_PROVIDER aws needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if a.endpoint == "" {
+		a.endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	return a, nil
+}
+
+func (a *awsSecrets) Secrets(ctx context.Context, names []string) (map[string]string, error) {
+	body, _ := json.Marshal(map[string]string{"SecretId": a.secretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	a.sign(req, body, time.Now().UTC())
+
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		SecretString string
+		Type         string `json:"__type"`
+		Message      string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s %s", resp.Status, out.Type, out.Message)
+	}
+
+	var all map[string]any
+	if err := json.Unmarshal([]byte(out.SecretString), &all); err != nil {
+		return nil, fmt.Errorf("%s must hold a JSON object: %w", a.secretID, err)
+	}
+	values := make(map[string]string)
+	for _, name := range names {
+		switch v := all[name].(type) {
+		case string:
+			values[name] = v
+		case nil:
+		default:
+			return nil, fmt.Errorf("%s must be a string", name)
+		}
+	}
+	return values, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (a *awsSecrets) sign(req *http.Request, body []byte, now time.Time) {
+	const service = "secretsmanager"
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+
+	// Canonical headers are sorted by lower-case name.
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if a.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonical strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonical.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payload := sha256.Sum256(body)
+	request := strings.Join([]string{
+		req.Method, path, req.URL.Query().Encode(), canonical.String(), signed, hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := date + "/" + a.region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + a.secretKey)
+	for _, part := range []string{date, a.region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
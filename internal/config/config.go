@@ -0,0 +1,632 @@
+// Package config loads the server's settings from defaults, a YAML file,
+// the environment, command-line flags and a secret provider.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"pspFileAPI/internal/flags"
+	"pspFileAPI/internal/scheduler"
+)
+
+// defaultConfigFile is read when it exists and no other file is named.
+const defaultConfigFile = "config.yaml"
+
+// Config holds every setting the server reads at startup. Each field is
+// named by its env tag, which is also its environment variable; the same
+// name in lower case is its key in the config file, and in lower case with
+// dashes its command-line flag. Later sources override earlier ones:
+// defaults, config file, environment, flags. An empty value counts as
+// unset, except for lists and settings tagged empty:"allowed". Settings
+// tagged reload:"live" take effect on reload; the rest need a restart.
+// Settings tagged secret:"true" that no source gives are fetched from
+// SECRETS_PROVIDER, and their values are never logged.
+type Config struct {
+	Host              string        `env:"APP_HOST" default:"localhost" empty:"allowed" help:"interface to listen on; empty for all"`
+	Port              string        `env:"APP_PORT" default:"8080" help:"TCP port to listen on"`
+	H2C               bool          `env:"H2C_ENABLED" help:"accept HTTP/2 over cleartext"`
+	GRPCAddr          string        `env:"GRPC_ADDR" help:"address to serve the gRPC AlbumService on, e.g. :9090"`
+	GraphiQL          bool          `env:"GRAPHIQL_ENABLED" help:"serve the GraphiQL playground at /graphiql"`
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT" default:"5s" help:"time allowed to read request headers"`
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT" default:"15s" help:"time allowed to read a whole request"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT" default:"30s" help:"time allowed to write a response"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT" default:"60s" help:"how long idle keep-alive connections stay open"`
+	MaxHeaderBytes    int           `env:"MAX_HEADER_BYTES" default:"1048576" help:"largest request header block accepted"`
+	MaxBodyBytes      int64         `env:"MAX_BODY_BYTES" default:"1048576" help:"largest request body decoded, apart from uploads"`
+	ShutdownTimeout   time.Duration `env:"SHUTDOWN_TIMEOUT" default:"10s" help:"time in-flight requests get to finish on shutdown"`
+	ShutdownDelay     time.Duration `env:"SHUTDOWN_DELAY" help:"time /readyz fails before shutdown starts"`
+	ReadyTimeout      time.Duration `env:"READY_TIMEOUT" default:"2s" help:"time each dependency check of /readyz may take"`
+	UpgradeTimeout    time.Duration `env:"UPGRADE_TIMEOUT" default:"30s" help:"time the process started by SIGUSR2 gets to start listening"`
+	JobWorkers        int           `env:"JOB_WORKERS" default:"4" help:"background jobs run at once"`
+	JobQueueSize      int           `env:"JOB_QUEUE_SIZE" default:"100" help:"background jobs waiting before new ones are refused"`
+	JobRetention      time.Duration `env:"JOB_RETENTION" default:"1h" help:"how long finished jobs can be looked up"`
+
+	TLSCertFile      string   `env:"TLS_CERT_FILE" help:"certificate to serve HTTPS with"`
+	TLSKeyFile       string   `env:"TLS_KEY_FILE" help:"key for TLS_CERT_FILE"`
+	AutocertDomains  []string `env:"AUTOCERT_DOMAIN" help:"domains to get Let's Encrypt certificates for"`
+	AutocertCacheDir string   `env:"AUTOCERT_CACHE_DIR" default:"autocert-cache" help:"where Let's Encrypt certificates are cached"`
+	AutocertEmail    string   `env:"AUTOCERT_EMAIL" help:"contact address given to Let's Encrypt"`
+	AutocertHTTPAddr string   `env:"AUTOCERT_HTTP_ADDR" default:":80" help:"address answering ACME challenges and redirecting to HTTPS"`
+
+	LogFormat        string `env:"LOG_FORMAT" help:"log format: text or json"`
+	LogLevel         string `env:"LOG_LEVEL" reload:"live" help:"lowest level logged: debug, info, warn or error"`
+	AccessLog        string `env:"ACCESS_LOG" help:"write access log lines as JSON to stdout, stderr or this file instead of the application log"`
+	BodyCapture      bool   `env:"BODY_CAPTURE" help:"log request and response bodies of requests that ask for it"`
+	BodyCaptureLimit int    `env:"BODY_CAPTURE_LIMIT" default:"4096" help:"bytes of each body captured"`
+	DebugEndpoints   bool   `env:"DEBUG_ENDPOINTS" help:"serve pprof profiles"`
+	DebugAddr        string `env:"DEBUG_ADDR" help:"address to serve profiles on instead of the API port"`
+	FeatureFlags     string `env:"FEATURE_FLAGS" reload:"live" help:"feature flags turned on: name, name=25%, name=tenants:a|b"`
+	MetricsNamespace string `env:"METRICS_NAMESPACE" help:"prefix of every metric name, as myapp in myapp_http_requests_total"`
+
+	MetricsGatherTimeout time.Duration `env:"METRICS_GATHER_TIMEOUT" default:"5s" help:"how long /metrics waits for metrics to be gathered; 0 for no limit"`
+	MetricsMaxStale      time.Duration `env:"METRICS_MAX_STALE" default:"1m" help:"how old the metrics /metrics falls back on after METRICS_GATHER_TIMEOUT may be; 0 to answer 503 instead"`
+
+	Maintenance           bool          `env:"MAINTENANCE" reload:"live" help:"answer 503 to everything but probes, metrics and /admin"`
+	MaintenanceRetryAfter time.Duration `env:"MAINTENANCE_RETRY_AFTER" default:"5m" help:"Retry-After sent in maintenance mode"`
+
+	AlbumStore        string        `env:"ALBUM_STORE" default:"memory" help:"album backend: memory, postgres or sqlite"`
+	DatabaseURL       string        `env:"DATABASE_URL" secret:"true" help:"database to connect to"`
+	DBAutoMigrate     bool          `env:"DB_AUTO_MIGRATE" default:"true" help:"apply migrations at startup"`
+	DBMaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" default:"10" help:"most open database connections"`
+	DBMaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" default:"5" help:"most idle database connections"`
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" default:"30m" help:"how long a database connection is reused"`
+	CacheTTL          time.Duration `env:"CACHE_TTL" default:"30s" help:"how long SQL store reads are cached; 0 to not cache"`
+	CacheSize         int           `env:"CACHE_SIZE" default:"1000" help:"entries kept by the local album read cache"`
+	RedisURL          string        `env:"REDIS_URL" secret:"true" help:"Redis server to cache in instead of locally"`
+	IdempotencyTTL    time.Duration `env:"IDEMPOTENCY_TTL" default:"24h" help:"how long responses are kept for Idempotency-Key retries; 0 to ignore the header"`
+	DeletedRetention  time.Duration `env:"DELETED_RETENTION" default:"720h" help:"how long deleted albums can be restored before they are purged; 0 to keep them"`
+	PurgeInterval     time.Duration `env:"PURGE_INTERVAL" default:"1h" help:"how often albums past DELETED_RETENTION are purged, unless PURGE_SCHEDULE is set"`
+
+	PurgeSchedule            string `env:"PURGE_SCHEDULE" help:"cron schedule purging albums past DELETED_RETENTION, e.g. 0 3 * * *"`
+	IdempotencySweepSchedule string `env:"IDEMPOTENCY_SWEEP_SCHEDULE" default:"*/15 * * * *" empty:"allowed" help:"cron schedule dropping expired Idempotency-Key responses from the local store; empty to leave it to the store"`
+	WebhookRetrySchedule     string `env:"WEBHOOK_RETRY_SCHEDULE" default:"*/10 * * * *" empty:"allowed" help:"cron schedule replaying webhook deliveries that failed with retryable errors; empty to not replay them"`
+
+	FileStore          string   `env:"FILE_STORE" default:"disk" help:"upload backend: disk"`
+	UploadDir          string   `env:"UPLOAD_DIR" default:"uploads" help:"directory uploads are stored in"`
+	UploadMaxBytes     int64    `env:"UPLOAD_MAX_BYTES" default:"10485760" help:"largest upload request accepted"`
+	DiskMinFree        int64    `env:"DISK_MIN_FREE" default:"104857600" help:"free bytes below which the upload directory reports unhealthy"`
+	UploadAllowedTypes []string `env:"UPLOAD_ALLOWED_TYPES" default:"image/png,image/jpeg,image/gif,application/pdf,text/plain" help:"media types uploads may have"`
+
+	UnversionedSunset     string        `env:"UNVERSIONED_SUNSET" help:"date (YYYY-MM-DD) unversioned paths are removed"`
+	DeprecatedRoutes      string        `env:"DEPRECATED_ROUTES" help:"METHOD /path=YYYY-MM-DD routes to mark deprecated"`
+	RequestTimeout        time.Duration `env:"REQUEST_TIMEOUT" help:"deadline for handling each request; 0 for none"`
+	RouteTimeouts         string        `env:"ROUTE_TIMEOUTS" help:"METHOD /route=duration or /route=duration deadlines overriding REQUEST_TIMEOUT"`
+	TextSanitize          string        `env:"TEXT_SANITIZE" help:"handling of control characters in album titles and artists: reject or strip; unset to allow them"`
+	TextNormalize         bool          `env:"TEXT_NORMALIZE" help:"NFC-normalize album text"`
+	CompressMinSize       int           `env:"COMPRESS_MIN_SIZE" default:"1024" help:"smallest response compressed"`
+	ResponseSigningSecret string        `env:"RESPONSE_SIGNING_SECRET" secret:"true" reload:"live" help:"key responses are signed with"`
+	CORSAllowedOrigins    []string      `env:"CORS_ALLOWED_ORIGINS" help:"origins browsers may call the API from"`
+	CORSAllowedMethods    []string      `env:"CORS_ALLOWED_METHODS" default:"GET,POST,PUT,DELETE" help:"methods allowed cross-origin"`
+	CORSAllowedHeaders    []string      `env:"CORS_ALLOWED_HEADERS" default:"Content-Type,Authorization,X-API-Key,X-Request-ID,If-Match,If-None-Match,X-CSRF-Token" help:"request headers allowed cross-origin"`
+	CORSMaxAge            time.Duration `env:"CORS_MAX_AGE" default:"10m" help:"how long browsers cache preflight results"`
+	TrustedProxies        []string      `env:"TRUSTED_PROXIES" help:"addresses or CIDRs whose X-Forwarded-For and X-Real-IP headers name the client; unset to trust none"`
+	RateLimit             float64       `env:"RATE_LIMIT" reload:"live" help:"requests per second allowed per client; 0 for no limit"`
+	RateBurst             int           `env:"RATE_BURST" reload:"live" help:"requests a client may burst to; defaults to RATE_LIMIT"`
+
+	JWTSecret    string        `env:"JWT_SECRET" secret:"true" reload:"live" help:"key login tokens are signed with"`
+	JWTTTL       time.Duration `env:"JWT_TTL" default:"1h" help:"how long login tokens are valid"`
+	AuthUsers    string        `env:"AUTH_USERS" secret:"true" help:"name:bcrypt-hash pairs allowed to log in"`
+	AdminToken   string        `env:"ADMIN_TOKEN" secret:"true" reload:"live" help:"token for the /admin endpoints"`
+	APIKeyHashes string        `env:"API_KEY_HASHES" secret:"true" help:"name:sha256-hex API keys accepted"`
+	AuthRoles    string        `env:"AUTH_ROLES" help:"name:role pairs giving users, and API keys as key:name, the readonly, user or admin role"`
+	AuthTenants  string        `env:"AUTH_TENANTS" help:"name:tenant pairs giving users, and API keys as key:name, their tenant"`
+
+	TenantSource string   `env:"TENANT_SOURCE" help:"where each request's tenant comes from: header, subdomain or token; unset for a single tenant"`
+	TenantDomain string   `env:"TENANT_DOMAIN" help:"domain tenants are subdomains of, e.g. albums.example.com"`
+	Tenants      []string `env:"TENANTS" help:"tenants served besides default and those AUTH_TENANTS names; requests for others get 404"`
+
+	OIDCIssuer        string   `env:"OIDC_ISSUER" help:"OpenID Connect provider users may log in through, e.g. https://accounts.google.com"`
+	OIDCClientID      string   `env:"OIDC_CLIENT_ID" help:"client ID registered with the OIDC provider"`
+	OIDCClientSecret  string   `env:"OIDC_CLIENT_SECRET" secret:"true" help:"client secret registered with the OIDC provider"`
+	OIDCRedirectURL   string   `env:"OIDC_REDIRECT_URL" help:"public URL of /auth/callback registered with the OIDC provider"`
+	OIDCScopes        []string `env:"OIDC_SCOPES" default:"openid,email,profile" help:"scopes requested from the OIDC provider"`
+	OIDCUsernameClaim string   `env:"OIDC_USERNAME_CLAIM" default:"email" help:"ID token claim naming the local user"`
+	OIDCRolesClaim    string   `env:"OIDC_ROLES_CLAIM" help:"ID token claim listing the user's roles, if the provider sends one"`
+
+	SessionsEnabled     bool          `env:"SESSIONS_ENABLED" help:"start a cookie session at each login for browser clients"`
+	SessionIdleTimeout  time.Duration `env:"SESSION_IDLE_TIMEOUT" default:"30m" help:"how long an unused session lasts"`
+	SessionMaxAge       time.Duration `env:"SESSION_MAX_AGE" default:"24h" help:"how long a session lasts after login however much it is used"`
+	SessionCookieSecure bool          `env:"SESSION_COOKIE_SECURE" default:"true" help:"send the session cookie over HTTPS only"`
+
+	SecretsProvider string        `env:"SECRETS_PROVIDER" default:"env" help:"where unset secrets are fetched from: env, file, vault or aws"`
+	SecretsDir      string        `env:"SECRETS_DIR" default:"/run/secrets" help:"directory the file provider reads secrets from"`
+	SecretsRefresh  time.Duration `env:"SECRETS_REFRESH" help:"how often secrets are fetched again; 0 for never"`
+	VaultAddr       string        `env:"VAULT_ADDR" default:"http://127.0.0.1:8200" help:"Vault server address"`
+	VaultToken      string        `env:"VAULT_TOKEN" help:"Vault token"`
+	VaultSecretPath string        `env:"VAULT_SECRET_PATH" default:"secret/data/albums" help:"KV v2 secret holding the settings"`
+	AWSRegion       string        `env:"AWS_REGION" help:"AWS region of AWS_SECRET_ID"`
+	AWSSecretID     string        `env:"AWS_SECRET_ID" help:"Secrets Manager secret holding the settings"`
+
+	Broker       string `env:"BROKER" help:"message broker album events are published to: nats or kafka"`
+	BrokerURL    string `env:"BROKER_URL" secret:"true" help:"NATS server URL or comma-separated Kafka brokers"`
+	BrokerTopic  string `env:"BROKER_TOPIC" default:"albums" help:"Kafka topic, or NATS subject prefix, events are published to"`
+	BrokerBuffer int    `env:"BROKER_BUFFER" default:"10000" help:"events held while the broker is unavailable"`
+
+	WebhooksEnabled     bool          `env:"WEBHOOKS_ENABLED" help:"let clients register webhooks"`
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" default:"5" help:"times each webhook delivery is tried"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" default:"10s" help:"time each webhook delivery attempt may take"`
+	WebhookAllowPrivate bool          `env:"WEBHOOK_ALLOW_PRIVATE" help:"allow webhooks to loopback and private addresses"`
+
+	NotifyProvider       string        `env:"NOTIFY_PROVIDER" help:"where notification emails are sent: smtp, or log to only log them"`
+	NotifyTo             []string      `env:"NOTIFY_TO" help:"addresses notification emails are sent to"`
+	NotifyFrom           string        `env:"NOTIFY_FROM" default:"albums@localhost" help:"sender address of notification emails"`
+	NotifyEvents         []string      `env:"NOTIFY_EVENTS" default:"album.price_above,webhook.failed" help:"events notification emails are sent for"`
+	NotifyPriceThreshold float64       `env:"NOTIFY_PRICE_THRESHOLD" help:"album price above which album.price_above is sent; 0 for never"`
+	NotifyTemplateDir    string        `env:"NOTIFY_TEMPLATE_DIR" help:"directory of <event>.txt templates replacing the built-in ones"`
+	NotifyMaxAttempts    int           `env:"NOTIFY_MAX_ATTEMPTS" default:"5" help:"times each notification email is tried"`
+	SMTPAddr             string        `env:"SMTP_ADDR" default:"localhost:25" help:"SMTP relay notification emails are sent through"`
+	SMTPUsername         string        `env:"SMTP_USERNAME" help:"user to authenticate to the SMTP relay as"`
+	SMTPPassword         string        `env:"SMTP_PASSWORD" secret:"true" help:"password of SMTP_USERNAME"`
+	SMTPTimeout          time.Duration `env:"SMTP_TIMEOUT" default:"30s" help:"time each attempt to send a notification email may take"`
+
+	OutboundTimeout       time.Duration `env:"OUTBOUND_TIMEOUT" default:"10s" help:"time each attempt of an outbound call may take, unless a more specific setting says"`
+	OutboundAttempts      int           `env:"OUTBOUND_ATTEMPTS" default:"3" help:"times an outbound call that is safe to repeat is tried"`
+	OutboundRetryDelay    time.Duration `env:"OUTBOUND_RETRY_DELAY" default:"100ms" help:"longest wait before the first retry of an outbound call, doubled for each further one"`
+	OutboundRetryMaxDelay time.Duration `env:"OUTBOUND_RETRY_MAX_DELAY" default:"2s" help:"longest wait between retries of an outbound call"`
+	OutboundRetryBudget   float64       `env:"OUTBOUND_RETRY_BUDGET" default:"0.1" help:"share of outbound calls that may be retried"`
+
+	Upstreams        string        `env:"UPSTREAMS" help:"name=URL pairs of upstream APIs /upstreams/{name} proxies to"`
+	UpstreamTimeout  time.Duration `env:"UPSTREAM_TIMEOUT" default:"10s" help:"time each attempt of a proxied upstream call may take"`
+	BreakerFailures  int           `env:"BREAKER_FAILURES" default:"5" help:"consecutive upstream failures that open its circuit breaker"`
+	BreakerOpenDelay time.Duration `env:"BREAKER_OPEN_DELAY" default:"30s" help:"time an open circuit breaker rejects calls before trying one"`
+
+	AuditLog  string `env:"AUDIT_LOG" help:"where to record mutating requests: file or database"`
+	AuditFile string `env:"AUDIT_FILE" default:"audit.log" help:"JSON Lines file the file audit log appends to"`
+
+	RequiredEnv []string `env:"REQUIRED_ENV" help:"settings that must be given"`
+
+	// set records the settings given by the config file, environment or
+	// flags rather than defaulted.
+	set map[string]bool
+	// file is the config file read, whether or not it exists.
+	file string
+}
+
+// Source looks up raw setting values by name.
+type Source func(name string) (string, bool)
+
+// Load builds the configuration from defaults, the config file, the
+// environment and args, the command-line flags, and validates it. The file
+// is the -config flag or CONFIG_FILE, else config.yaml if it exists.
+func Load(args []string, lookupEnv Source) (Config, error) {
+	return LoadWith(args, lookupEnv, nil)
+}
+
+// LoadWith is Load with unset secrets fetched from secrets instead of the
+// provider SECRETS_PROVIDER selects, unless secrets is nil.
+func LoadWith(args []string, lookupEnv Source, secrets SecretProvider) (Config, error) {
+	var cfg Config
+	fields := configFields(&cfg)
+
+	fs := flag.NewFlagSet("albums", flag.ContinueOnError)
+	fs.Usage = func() { usage(fs.Output()) }
+	file := fs.String("config", "", "YAML config file")
+	flags := make(map[string]*flagValue, len(fields))
+	for _, f := range fields {
+		flags[f.name] = &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		fs.Var(flags[f.name], f.flag(), f.help)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	path, explicit := *file, given["config"]
+	if !explicit {
+		path, explicit = lookupEnv("CONFIG_FILE")
+	}
+	if !explicit {
+		path = defaultConfigFile
+	}
+	fileValues, err := readConfigFile(path, explicit)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.set, cfg.file = make(map[string]bool), path
+	var errs []error
+	for _, f := range fields {
+		value, from := f.def, "default"
+		use := func(v, source string, ok bool) {
+			if ok && (v != "" || f.allowEmpty) {
+				value, from = v, source
+				cfg.set[f.name] = true
+			}
+		}
+		v, ok := fileValues[strings.ToLower(f.name)]
+		use(v, path, ok)
+		v, ok = lookupEnv(f.name)
+		use(v, "environment", ok)
+		use(flags[f.name].value, "flag -"+f.flag(), given[f.flag()])
+
+		if err := f.parse(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q (from %s) %w", f.name, value, from, err))
+		}
+		delete(fileValues, strings.ToLower(f.name))
+	}
+	for key := range fileValues {
+		errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+	if err := fetchSecrets(&cfg, secrets); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate(lookupEnv)
+}
+
+// readConfigFile returns the settings in the YAML file at path, keyed by
+// lower-case name. A missing file is only an error when it was asked for.
+func readConfigFile(path string, required bool) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := make(map[string]string, len(doc))
+	for key, node := range doc {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			values[key] = node.Value
+		case yaml.SequenceNode:
+			items := make([]string, len(node.Content))
+			for i, item := range node.Content {
+				items[i] = item.Value
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("%s: %s must be a value or a list", path, key)
+		}
+	}
+	return values, nil
+}
+
+// validate checks settings against each other and their allowed ranges,
+// reporting every problem at once.
+func (c Config) validate(lookupEnv Source) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	if _, err := ParsePort(c.Port); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	check(validMetricsNamespace(c.MetricsNamespace), "METRICS_NAMESPACE %q must be letters, digits and underscores, not starting with a digit", c.MetricsNamespace)
+	check(c.MetricsGatherTimeout >= 0, "METRICS_GATHER_TIMEOUT must not be negative")
+	check(c.MetricsMaxStale >= 0, "METRICS_MAX_STALE must not be negative")
+	check(c.MaxHeaderBytes > 0, "MAX_HEADER_BYTES must be positive")
+	check(c.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive")
+	check(c.BodyCaptureLimit >= 0, "BODY_CAPTURE_LIMIT must not be negative")
+	check(c.CompressMinSize >= 0, "COMPRESS_MIN_SIZE must not be negative")
+	check(c.CacheSize > 0, "CACHE_SIZE must be positive")
+	check(c.IdempotencyTTL >= 0, "IDEMPOTENCY_TTL must not be negative")
+	check(c.DeletedRetention >= 0, "DELETED_RETENTION must not be negative")
+	check(c.PurgeInterval > 0, "PURGE_INTERVAL must be positive")
+	check(c.JobWorkers > 0, "JOB_WORKERS must be positive")
+	check(c.JobQueueSize >= 0, "JOB_QUEUE_SIZE must not be negative")
+	check(c.WebhookMaxAttempts > 0, "WEBHOOK_MAX_ATTEMPTS must be positive")
+	check(c.NotifyProvider == "" || c.NotifyProvider == "smtp" || c.NotifyProvider == "log", "NOTIFY_PROVIDER %q must be \"smtp\" or \"log\"", c.NotifyProvider)
+	check(c.NotifyProvider == "" || len(c.NotifyTo) > 0, "NOTIFY_PROVIDER needs NOTIFY_TO")
+	check(c.NotifyPriceThreshold >= 0, "NOTIFY_PRICE_THRESHOLD must not be negative")
+	check(c.NotifyMaxAttempts > 0, "NOTIFY_MAX_ATTEMPTS must be positive")
+	check(c.SMTPTimeout > 0, "SMTP_TIMEOUT must be positive")
+	check(c.BreakerFailures > 0, "BREAKER_FAILURES must be positive")
+	check(c.OutboundAttempts > 0, "OUTBOUND_ATTEMPTS must be positive")
+	check(c.OutboundRetryBudget >= 0, "OUTBOUND_RETRY_BUDGET must not be negative")
+	check(c.Broker == "" || c.Broker == "nats" || c.Broker == "kafka", "BROKER %q must be \"nats\" or \"kafka\"", c.Broker)
+	check(c.Broker == "" || c.BrokerURL != "", "BROKER needs BROKER_URL")
+	check(c.BrokerBuffer > 0, "BROKER_BUFFER must be positive")
+	check(c.UploadMaxBytes > 0, "UPLOAD_MAX_BYTES must be positive")
+	check(c.DiskMinFree >= 0, "DISK_MIN_FREE must not be negative")
+	check(c.ReadyTimeout > 0, "READY_TIMEOUT must be positive")
+	check(c.MaintenanceRetryAfter > 0, "MAINTENANCE_RETRY_AFTER must be positive")
+	check(c.DBMaxOpenConns >= 0 && c.DBMaxIdleConns >= 0, "DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS must not be negative")
+	check(c.RateLimit >= 0, "RATE_LIMIT must not be negative")
+	check(c.RateBurst >= 0, "RATE_BURST must not be negative")
+	check(c.TenantSource == "" || c.TenantSource == "header" || c.TenantSource == "subdomain" || c.TenantSource == "token", "TENANT_SOURCE %q must be \"header\", \"subdomain\" or \"token\"", c.TenantSource)
+	check(c.TenantSource != "subdomain" || c.TenantDomain != "", "TENANT_SOURCE=subdomain needs TENANT_DOMAIN")
+	check(c.TenantSource == "" || c.JWTSecret != "" || c.APIKeyHashes != "" || c.AdminToken != "", "TENANT_SOURCE=%s needs JWT_SECRET or API keys", c.TenantSource)
+	check(c.AuditLog == "" || c.AuditLog == "file" || c.AuditLog == "database", "AUDIT_LOG %q must be \"file\" or \"database\"", c.AuditLog)
+	check(c.AuditLog != "database" || c.AlbumStore != "memory", "AUDIT_LOG=database needs ALBUM_STORE set to a SQL backend")
+	if c.SessionsEnabled {
+		check(c.JWTSecret != "", "SESSIONS_ENABLED needs JWT_SECRET so users can log in")
+		check(c.SessionIdleTimeout > 0 && c.SessionMaxAge > 0, "SESSION_IDLE_TIMEOUT and SESSION_MAX_AGE must be positive")
+	}
+	if c.OIDCIssuer != "" {
+		check(c.JWTSecret != "", "OIDC_ISSUER needs JWT_SECRET to sign login tokens")
+		check(c.OIDCClientID != "" && c.OIDCRedirectURL != "", "OIDC_ISSUER needs OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+		check(slices.Contains(c.OIDCScopes, "openid"), "OIDC_SCOPES must include openid")
+	}
+
+	switch c.AlbumStore {
+	case "memory", "postgres", "sqlite":
+	default:
+		check(false, "ALBUM_STORE %q must be \"memory\", \"postgres\" or \"sqlite\"", c.AlbumStore)
+	}
+	check(c.FileStore == "disk", "FILE_STORE %q must be \"disk\"", c.FileStore)
+	switch c.SecretsProvider {
+	case "env", "file", "vault", "aws":
+	default:
+		check(false, "SECRETS_PROVIDER %q must be \"env\", \"file\", \"vault\" or \"aws\"", c.SecretsProvider)
+	}
+
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(len(c.AutocertDomains) == 0 || c.TLSCertFile == "", "set either AUTOCERT_DOMAIN or TLS_CERT_FILE and TLS_KEY_FILE, not both")
+
+	if _, err := flags.Parse(c.FeatureFlags); err != nil {
+		errs = append(errs, err)
+	}
+	for _, s := range []struct{ name, spec string }{
+		{"PURGE_SCHEDULE", c.PurgeSchedule},
+		{"IDEMPOTENCY_SWEEP_SCHEDULE", c.IdempotencySweepSchedule},
+		{"WEBHOOK_RETRY_SCHEDULE", c.WebhookRetrySchedule},
+	} {
+		if s.spec == "" {
+			continue
+		}
+		if _, err := scheduler.Parse(s.spec); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	if c.UnversionedSunset != "" {
+		_, err := time.Parse(time.DateOnly, c.UnversionedSunset)
+		check(err == nil, "UNVERSIONED_SUNSET %q must be a date such as 2025-12-31", c.UnversionedSunset)
+	}
+
+	// REQUIRED_ENV may also name variables read outside config, such as
+	// OTEL_SERVICE_NAME.
+	if err := checkRequiredEnv(strings.Join(c.RequiredEnv, ","), func(name string) (string, bool) {
+		if c.set[name] {
+			return "", true
+		}
+		return lookupEnv(name)
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Burst returns RATE_BURST, defaulting to RATE_LIMIT rounded up.
+func (c Config) Burst() int {
+	if c.RateBurst == 0 {
+		return int(math.Ceil(c.RateLimit))
+	}
+	return c.RateBurst
+}
+
+// File returns the config file read, whether or not it exists.
+func (c Config) File() string {
+	return c.file
+}
+
+// Update copies the live settings that differ in next into c, logging
+// each one, and returns their names. changed lists the settings applied;
+// restart lists those that changed but wait for a restart, including
+// secrets that turn a feature on or off. Secret values are never logged.
+func (c *Config) Update(next Config) (changed, restart []string) {
+	cur, upd := configFields(c), configFields(&next)
+	for i, f := range upd {
+		old, now := cur[i].value.Interface(), f.value.Interface()
+		if reflect.DeepEqual(old, now) {
+			continue
+		}
+		if !f.live || f.secret && (old == "") != (now == "") {
+			restart = append(restart, f.name)
+			continue
+		}
+		if f.secret {
+			slog.Info("config setting changed", "setting", f.name)
+		} else {
+			slog.Info("config setting changed", "setting", f.name, "old", fmt.Sprint(old), "new", fmt.Sprint(now))
+		}
+		cur[i].value.Set(f.value)
+		changed = append(changed, f.name)
+	}
+	return changed, restart
+}
+
+// Redacted returns every setting by name, with CONFIG_FILE, formatted as
+// it would be given in the environment. Secrets that are set read
+// "[redacted]".
+func (c Config) Redacted() map[string]string {
+	settings := map[string]string{"CONFIG_FILE": c.file}
+	for _, f := range configFields(&c) {
+		var s string
+		switch v := f.value.Interface().(type) {
+		case string:
+			s = v
+		case []string:
+			s = strings.Join(v, ",")
+		default:
+			s = fmt.Sprint(v)
+		}
+		if f.secret && s != "" {
+			s = "[redacted]"
+		}
+		settings[f.name] = s
+	}
+	return settings
+}
+
+// ParsePort validates an APP_PORT value.
+func ParsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("APP_PORT %q is not a valid TCP port", s)
+	}
+	return port, nil
+}
+
+// validMetricsNamespace reports whether s, if set, can start Prometheus
+// metric names.
+func validMetricsNamespace(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLogLevel parses LOG_LEVEL: "debug", "info" (also the empty string),
+// "warn" or "error".
+func ParseLogLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if s != "" {
+		if err := lvl.UnmarshalText([]byte(s)); err != nil {
+			return 0, fmt.Errorf("LOG_LEVEL %q must be debug, info, warn or error", s)
+		}
+	}
+	return lvl, nil
+}
+
+// configField is one setting of a Config.
+type configField struct {
+	name, def, help string
+	// allowEmpty lets an empty value override an earlier one.
+	allowEmpty bool
+	// live settings are applied on reload.
+	live bool
+	// secret settings may come from the secret provider.
+	secret bool
+	value  reflect.Value
+}
+
+// configFields lists the settings of cfg in declaration order.
+func configFields(cfg *Config) []configField {
+	v := reflect.ValueOf(cfg).Elem()
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		name, ok := sf.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		fields = append(fields, configField{
+			name:       name,
+			def:        sf.Tag.Get("default"),
+			help:       sf.Tag.Get("help"),
+			allowEmpty: sf.Type.Kind() == reflect.Slice || sf.Tag.Get("empty") == "allowed",
+			live:       sf.Tag.Get("reload") == "live",
+			secret:     sf.Tag.Get("secret") == "true",
+			value:      v.Field(i),
+		})
+	}
+	return fields
+}
+
+// flag returns the command-line flag of the setting.
+func (f configField) flag() string {
+	return strings.ReplaceAll(strings.ToLower(f.name), "_", "-")
+}
+
+// parse sets the field from its raw value. An empty value is the zero
+// value.
+func (f configField) parse(s string) error {
+	if f.value.Kind() == reflect.String {
+		f.value.SetString(s)
+		return nil
+	}
+	if f.value.Type() == reflect.TypeOf([]string(nil)) {
+		f.value.Set(reflect.ValueOf(SplitList(s)))
+		return nil
+	}
+	if s == "" {
+		f.value.SetZero()
+		return nil
+	}
+	switch f.value.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return errors.New("must be a non-negative duration such as 10s")
+		}
+		f.value.SetInt(int64(d))
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		f.value.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || f.value.OverflowInt(n) {
+			return errors.New("must be an integer")
+		}
+		f.value.SetInt(n)
+	case float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		f.value.SetFloat(n)
+	default:
+		panic("config: unsupported type " + f.value.Type().String())
+	}
+	return nil
+}
+
+// flagValue holds the raw value of a setting's flag. Boolean settings can
+// be given as a bare flag.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string     { return v.value }
+func (v *flagValue) Set(s string) error { v.value = s; return nil }
+func (v *flagValue) IsBoolFlag() bool   { return v.isBool }
+
+// usage prints the flags, which are also the settings, to w.
+func usage(w io.Writer) {
+	var cfg Config
+	fmt.Fprintln(w, "Usage: albums [serve | migrate [up | down [n]] | routes | config validate] [flags]")
+	fmt.Fprintln(w, "\nEvery flag can also be set in the environment or config file; flags win.")
+	fmt.Fprintln(w, "\n  -config path\n\tYAML config file (CONFIG_FILE; default config.yaml)")
+	for _, f := range configFields(&cfg) {
+		def := ""
+		if f.def != "" {
+			def = "; default " + f.def
+		}
+		fmt.Fprintf(w, "  -%s value\n\t%s (%s%s)\n", f.flag(), f.help, f.name, def)
+	}
+}
+
+// SplitList splits a comma-separated list, dropping empty entries.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
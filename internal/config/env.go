@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkRequiredEnv returns an error naming every variable in the
+// comma-separated list names that lookup does not find, so a
+// misconfigured deploy reports all of its gaps at once.
+func checkRequiredEnv(names string, lookup func(string) (string, bool)) error {
+	var missing []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := lookup(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
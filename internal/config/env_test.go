@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// lookupIn returns a Source reading vars.
+func lookupIn(vars map[string]string) Source {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestRequiredEnvNamesEveryMissingVariable(t *testing.T) {
+	_, err := Load(nil, lookupIn(map[string]string{
+		"REQUIRED_ENV": "DATABASE_URL, APP_PORT,OTEL_SERVICE_NAME",
+		"APP_PORT":     "9090",
+	}))
+	if err == nil {
+		t.Fatal("Load succeeded with required variables missing")
+	}
+	msg := err.Error()
+	for _, name := range []string{"DATABASE_URL", "OTEL_SERVICE_NAME"} {
+		if !strings.Contains(msg, name) {
+			t.Errorf("error %q does not name %s", msg, name)
+		}
+	}
+	if strings.Contains(msg, "APP_PORT") {
+		t.Errorf("error %q names APP_PORT, which is set", msg)
+	}
+}
+
+func TestRequiredEnvGivenAsFlag(t *testing.T) {
+	vars := map[string]string{"REQUIRED_ENV": "APP_PORT"}
+	if _, err := Load(nil, lookupIn(vars)); err == nil {
+		t.Error("Load succeeded without the required APP_PORT")
+	}
+	if _, err := Load([]string{"-app-port", "9090"}, lookupIn(vars)); err != nil {
+		t.Errorf("Load with -app-port: %v", err)
+	}
+}
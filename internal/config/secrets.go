@@ -0,0 +1,121 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pspFileAPI/internal/httpclient"
+)
+
+// secretsTimeout bounds fetching secrets from a remote provider.
+const secretsTimeout = 10 * time.Second
+
+// secretsClient is the HTTP client remote providers fetch secrets with.
+// The OUTBOUND_* settings are not known yet when secrets are fetched, so
+// it retries on fixed terms.
+var secretsClient = httpclient.New(httpclient.Options{
+	Timeout:     secretsTimeout,
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+})
+
+// SecretProvider fetches the values of secret settings.
+type SecretProvider interface {
+	// Secrets returns the values it holds for names, keyed by name. Names
+	// it has no value for are left out.
+	Secrets(ctx context.Context, names []string) (map[string]string, error)
+}
+
+// newSecretProvider returns the provider selected by SECRETS_PROVIDER.
+func newSecretProvider(cfg Config) (SecretProvider, error) {
+	switch cfg.SecretsProvider {
+	case "env":
+		return envSecrets(os.LookupEnv), nil
+	case "file":
+		return fileSecrets(cfg.SecretsDir), nil
+	case "vault":
+		return newVaultSecrets(cfg.VaultAddr, cfg.VaultToken, cfg.VaultSecretPath)
+	case "aws":
+		return newAWSSecrets(cfg.AWSRegion, cfg.AWSSecretID)
+	}
+	return nil, fmt.Errorf("SECRETS_PROVIDER %q must be \"env\", \"file\", \"vault\" or \"aws\"", cfg.SecretsProvider)
+}
+
+// fetchSecrets fills the secret settings of cfg that no other source gave
+// from p, or from the provider SECRETS_PROVIDER selects when p is nil.
+func fetchSecrets(cfg *Config, p SecretProvider) error {
+	fields := configFields(cfg)
+	var names []string
+	for _, f := range fields {
+		if f.secret && !cfg.set[f.name] {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if p == nil {
+		var err error
+		if p, err = newSecretProvider(*cfg); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	values, err := p.Secrets(ctx, names)
+	if err != nil {
+		return fmt.Errorf("fetching secrets from %s: %w", cfg.SecretsProvider, err)
+	}
+
+	var errs []error
+	for _, f := range fields {
+		v, ok := values[f.name]
+		if !ok || v == "" {
+			continue
+		}
+		if err := f.parse(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s) %w", f.name, cfg.SecretsProvider, err))
+		}
+		cfg.set[f.name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// envSecrets reads secrets from environment variables of the same name.
+type envSecrets func(name string) (string, bool)
+
+func (lookup envSecrets) Secrets(_ context.Context, names []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, name := range names {
+		if v, ok := lookup(name); ok {
+			values[name] = v
+		}
+	}
+	return values, nil
+}
+
+// fileSecrets reads each secret from the file of the same name in a
+// directory, as Docker and Kubernetes mount them. A trailing newline is
+// dropped.
+type fileSecrets string
+
+func (dir fileSecrets) Secrets(_ context.Context, names []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(string(dir), name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[name] = strings.TrimRight(string(b), "\r\n")
+	}
+	return values, nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// vaultSecrets reads secrets from one HashiCorp Vault KV version 2 secret,
+// whose keys are the setting names.
+type vaultSecrets struct {
+	url, token string
+}
+
+// newVaultSecrets returns a provider reading the secret at path, such as
+// "secret/data/albums", from the Vault server at addr.
+func newVaultSecrets(addr, token, path string) (*vaultSecrets, error) {
+	if token == "" {
+		return nil, errors.New("SECRETS_PROVIDER vault needs VAULT_TOKEN")
+	}
+	return &vaultSecrets{
+		url:   strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(path, "/"),
+		token: token,
+	}, nil
+}
+
+func (v *vaultSecrets) Secrets(ctx context.Context, names []string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s", resp.Status, strings.Join(body.Errors, "; "))
+	}
+
+	values := make(map[string]string)
+	for _, name := range names {
+		switch v := body.Data.Data[name].(type) {
+		case string:
+			values[name] = v
+		case nil:
+		default:
+			return nil, fmt.Errorf("%s must be a string", name)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,93 @@
+package fake
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"pspFileAPI/internal/cache"
+)
+
+// Cache is an unbounded in-memory cache whose entries expire by its
+// Clock rather than the wall clock. Operations named after its methods can
+// be failed through Faults.
+type Cache struct {
+	Faults
+	clock *Clock
+
+	mu       sync.Mutex
+	entries  map[string]entry
+	counters map[string]int64
+}
+
+// entry is a cached value and when it expires.
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+var _ cache.Cache = (*Cache)(nil)
+
+// NewCache returns an empty cache reading the time from clock.
+func NewCache(clock *Clock) *Cache {
+	return &Cache{clock: clock, entries: make(map[string]entry), counters: make(map[string]int64)}
+}
+
+func (c *Cache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	if err := c.err("Get"); err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.clock.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+func (c *Cache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.err("Set"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry{value: append([]byte(nil), value...), expires: c.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (c *Cache) Delete(_ context.Context, keys ...string) error {
+	if err := c.err("Delete"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *Cache) Incr(_ context.Context, key string) error {
+	if err := c.err("Incr"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[key]++
+	return nil
+}
+
+func (c *Cache) Counter(_ context.Context, key string) (int64, error) {
+	if err := c.err("Counter"); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[key], nil
+}
+
+func (c *Cache) Close() error {
+	return c.err("Close")
+}
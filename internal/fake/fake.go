@@ -0,0 +1,107 @@
+// Package fake provides deterministic in-memory stand-ins for the album
+// repository, the cache and secret providers, so handlers and services can
+// be exercised without a database, Redis or a secrets manager. Each fake
+// can be told to fail chosen operations to reach error paths.
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Faults holds the errors a fake returns instead of doing an operation.
+// The zero value injects none. It is safe for concurrent use.
+type Faults struct {
+	mu   sync.Mutex
+	errs map[string]fault
+}
+
+// fault is an injected error and how many more calls it fails; a negative
+// count fails every call.
+type fault struct {
+	err   error
+	times int
+}
+
+// Fail makes every call to op return err until Heal is called.
+func (f *Faults) Fail(op string, err error) {
+	f.set(op, fault{err: err, times: -1})
+}
+
+// FailNext makes the next n calls to op return err. It panics if n is
+// less than 1; use Fail to fail every call.
+func (f *Faults) FailNext(op string, n int, err error) {
+	if n < 1 {
+		panic(fmt.Sprintf("fake: FailNext(%q) needs n >= 1, got %d", op, n))
+	}
+	f.set(op, fault{err: err, times: n})
+}
+
+// Heal stops injecting errors into op, or into every operation when no op
+// is given.
+func (f *Faults) Heal(ops ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(ops) == 0 {
+		f.errs = nil
+	}
+	for _, op := range ops {
+		delete(f.errs, op)
+	}
+}
+
+func (f *Faults) set(op string, ft fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.errs == nil {
+		f.errs = make(map[string]fault)
+	}
+	f.errs[op] = ft
+}
+
+// err returns the error injected into op, if any, using up one of a
+// limited number of failures.
+func (f *Faults) err(op string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ft, ok := f.errs[op]
+	if !ok {
+		return nil
+	}
+	if ft.times > 0 {
+		ft.times--
+		if ft.times == 0 {
+			delete(f.errs, op)
+		} else {
+			f.errs[op] = ft
+		}
+	}
+	return ft.err
+}
+
+// Clock is a time source that only moves when told to. It is safe for
+// concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock reading start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,86 @@
+package fake
+
+import (
+	"context"
+	"time"
+
+	"pspFileAPI/internal/repository"
+)
+
+// Repository is an in-memory album repository with the semantics of the
+// memory store. Operations named after its methods ("List", "Get", and so
+// on) can be failed through Faults.
+type Repository struct {
+	Faults
+	repo repository.Repository
+}
+
+var _ repository.Repository = (*Repository)(nil)
+
+// NewRepository returns a repository holding seed in the default tenant.
+func NewRepository(seed ...repository.Album) *Repository {
+	return &Repository{repo: repository.NewMemory(seed)}
+}
+
+func (r *Repository) List(ctx context.Context, q repository.ListQuery) ([]repository.Album, int, error) {
+	if err := r.err("List"); err != nil {
+		return nil, 0, err
+	}
+	return r.repo.List(ctx, q)
+}
+
+func (r *Repository) Get(ctx context.Context, id string) (repository.Album, error) {
+	if err := r.err("Get"); err != nil {
+		return repository.Album{}, err
+	}
+	return r.repo.Get(ctx, id)
+}
+
+func (r *Repository) Create(ctx context.Context, albums ...repository.Album) ([]repository.Album, error) {
+	if err := r.err("Create"); err != nil {
+		return nil, err
+	}
+	return r.repo.Create(ctx, albums...)
+}
+
+func (r *Repository) Update(ctx context.Context, id string, a repository.Album) (repository.Album, error) {
+	if err := r.err("Update"); err != nil {
+		return repository.Album{}, err
+	}
+	return r.repo.Update(ctx, id, a)
+}
+
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	if err := r.err("Delete"); err != nil {
+		return err
+	}
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *Repository) Restore(ctx context.Context, id string) (repository.Album, error) {
+	if err := r.err("Restore"); err != nil {
+		return repository.Album{}, err
+	}
+	return r.repo.Restore(ctx, id)
+}
+
+func (r *Repository) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	if err := r.err("Purge"); err != nil {
+		return 0, err
+	}
+	return r.repo.Purge(ctx, cutoff)
+}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	if err := r.err("Ping"); err != nil {
+		return err
+	}
+	return r.repo.Ping(ctx)
+}
+
+func (r *Repository) Close() error {
+	if err := r.err("Close"); err != nil {
+		return err
+	}
+	return r.repo.Close()
+}
@@ -0,0 +1,50 @@
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"pspFileAPI/internal/config"
+)
+
+// Secrets is a secret provider holding fixed values, for config.LoadWith.
+// The "Secrets" operation can be failed through Faults.
+type Secrets struct {
+	Faults
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+var _ config.SecretProvider = (*Secrets)(nil)
+
+// NewSecrets returns a provider holding values, keyed by setting name.
+func NewSecrets(values map[string]string) *Secrets {
+	s := &Secrets{values: make(map[string]string, len(values))}
+	for name, v := range values {
+		s.values[name] = v
+	}
+	return s
+}
+
+// Put sets the value of name, as a rotated secret would.
+func (s *Secrets) Put(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] = value
+}
+
+func (s *Secrets) Secrets(_ context.Context, names []string) (map[string]string, error) {
+	if err := s.err("Secrets"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]string)
+	for _, name := range names {
+		if v, ok := s.values[name]; ok {
+			values[name] = v
+		}
+	}
+	return values, nil
+}
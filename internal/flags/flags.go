@@ -0,0 +1,207 @@
+// Package flags turns new behavior on per request, for everyone, for some
+// tenants or for a share of callers, so it can be rolled out gradually and
+// switched off without a deploy.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// Flag says who a named behavior is on for. It is on for a request if any
+// of its rules match.
+type Flag struct {
+	Name string `json:"name"`
+	// On turns the flag on for everyone.
+	On bool `json:"on"`
+	// Tenants turns it on for requests acting for these tenants.
+	Tenants []string `json:"tenants,omitempty"`
+	// Percent turns it on for this share of callers, 0 to 100. A caller
+	// stays in or out of the share as long as Percent does not drop.
+	Percent int `json:"percent,omitempty"`
+}
+
+// Validate reports what is wrong with f, if anything.
+func (f Flag) Validate() error {
+	if f.Name == "" || strings.ContainsAny(f.Name, ",=") {
+		return fmt.Errorf("flag name %q must be non-empty and free of ',' and '='", f.Name)
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("flag %s: percent %d must be between 0 and 100", f.Name, f.Percent)
+	}
+	for _, t := range f.Tenants {
+		if !tenant.Valid(t) {
+			return fmt.Errorf("flag %s: %q is not a valid tenant", f.Name, t)
+		}
+	}
+	return nil
+}
+
+// enabled reports whether f is on for a request acting for tenantID by
+// the caller subject.
+func (f Flag) enabled(tenantID, subject string) bool {
+	if f.On || slices.Contains(f.Tenants, tenantID) {
+		return true
+	}
+	if f.Percent == 0 {
+		return false
+	}
+	if subject == "" {
+		subject = tenantID
+	}
+	// Hashing the name with the caller spreads the share of each flag
+	// over different callers.
+	h := fnv.New32a()
+	h.Write([]byte(f.Name + "\x00" + subject))
+	return int(h.Sum32()%100) < f.Percent
+}
+
+// Parse parses a FEATURE_FLAGS value: comma-separated entries, each a
+// flag name alone (on for everyone) or name=rule, rule being "on", "off",
+// a percentage such as "25%" or "tenants:" and a '|'-separated list of
+// tenants. Rules may be joined with '+', as in "beta=tenants:acme+10%".
+func Parse(spec string) ([]Flag, error) {
+	var list []Flag
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rules, ok := strings.Cut(entry, "=")
+		f := Flag{Name: strings.TrimSpace(name), On: !ok}
+		if ok {
+			if err := f.parseRules(rules); err != nil {
+				return nil, fmt.Errorf("FEATURE_FLAGS entry %q: %w", entry, err)
+			}
+		}
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("FEATURE_FLAGS entry %q: %w", entry, err)
+		}
+		if seen[f.Name] {
+			return nil, fmt.Errorf("FEATURE_FLAGS names %q twice", f.Name)
+		}
+		seen[f.Name] = true
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+// parseRules adds the '+'-joined rules to f.
+func (f *Flag) parseRules(rules string) error {
+	for _, rule := range strings.Split(rules, "+") {
+		switch rule = strings.TrimSpace(rule); {
+		case rule == "on":
+			f.On = true
+		case rule == "off":
+		case strings.HasSuffix(rule, "%"):
+			n, err := strconv.Atoi(strings.TrimSuffix(rule, "%"))
+			if err != nil {
+				return fmt.Errorf("%q is not a percentage", rule)
+			}
+			f.Percent = n
+		case strings.HasPrefix(rule, "tenants:"):
+			f.Tenants = append(f.Tenants, strings.Split(strings.TrimPrefix(rule, "tenants:"), "|")...)
+		default:
+			return fmt.Errorf("rule %q must be on, off, a percentage or tenants:a|b", rule)
+		}
+	}
+	return nil
+}
+
+// Set holds the current flags. It is safe for concurrent use.
+type Set struct {
+	// subject names the caller of the request carrying a context, for
+	// percentage rollouts; "" falls back to its tenant.
+	subject func(context.Context) string
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// New returns a set holding flags, finding callers with subject, which
+// may be nil.
+func New(flags []Flag, subject func(context.Context) string) *Set {
+	s := &Set{subject: subject}
+	s.Replace(flags)
+	return s
+}
+
+// Replace swaps every flag for flags.
+func (s *Set) Replace(flags []Flag) {
+	m := make(map[string]Flag, len(flags))
+	for _, f := range flags {
+		m[f.Name] = f
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = m
+}
+
+// Put adds f, replacing any flag of the same name.
+func (s *Set) Put(f Flag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags[f.Name] = f
+}
+
+// Delete removes the flag called name, reporting whether there was one.
+func (s *Set) Delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.flags[name]
+	delete(s.flags, name)
+	return ok
+}
+
+// List returns every flag, by name.
+func (s *Set) List() []Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Flag, 0, len(s.flags))
+	for _, f := range s.flags {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Enabled reports whether the flag called name is on for the request
+// carrying ctx. Unknown flags are off.
+func (s *Set) Enabled(ctx context.Context, name string) bool {
+	s.mu.RLock()
+	f, ok := s.flags[name]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	var subject string
+	if s.subject != nil {
+		subject = s.subject(ctx)
+	}
+	return f.enabled(tenant.From(ctx), subject)
+}
+
+// key is the context key for the set a request is evaluated against.
+type key struct{}
+
+// NewContext returns ctx evaluating flags against s.
+func NewContext(ctx context.Context, s *Set) context.Context {
+	return context.WithValue(ctx, key{}, s)
+}
+
+// Enabled reports whether the flag called name is on for the request
+// carrying ctx, by the set NewContext put in it. Without one every flag is
+// off.
+func Enabled(ctx context.Context, name string) bool {
+	s, ok := ctx.Value(key{}).(*Set)
+	return ok && s.Enabled(ctx, name)
+}
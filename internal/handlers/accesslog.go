@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// openAccessLog returns a logger writing access log lines as JSON to
+// dest: "stdout", "stderr" or a file appended to, which is also returned
+// for closing. Every request is written whatever LOG_LEVEL is.
+func openAccessLog(dest string) (*slog.Logger, *os.File, error) {
+	var f *os.File
+	switch dest {
+	case "stdout":
+		f = os.Stdout
+	case "stderr":
+		f = os.Stderr
+	default:
+		var err error
+		if f, err = os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644); err != nil {
+			return nil, nil, fmt.Errorf("open access log: %w", err)
+		}
+		return slog.New(requestIDHandler{slog.NewJSONHandler(f, nil)}), f, nil
+	}
+	return slog.New(requestIDHandler{slog.NewJSONHandler(f, nil)}), nil, nil
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+// album is the resource the API serves.
+type album = repository.Album
+
+// albumHandler serves the album, event and job endpoints through the
+// services that hold the albums.
+type albumHandler struct {
+	albums    *service.Albums
+	events    *service.Events
+	jobs      *service.Jobs
+	sanitizer textSanitizer
+}
+
+// getFavicon answers browsers' automatic favicon requests with an empty
+// response instead of a 404.
+func getFavicon(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
+
+// getAlbums responds with a page of albums matching the query's filters.
+func (h *albumHandler) getAlbums(c *gin.Context) {
+	q, page, errs := parseListQuery(c.Request.URL.Query())
+	if len(errs) > 0 {
+		writeProblem(c, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	list, total, err := h.albums.List(c.Request.Context(), q)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	resp := albumPage{Data: list, Total: total, Page: page, Limit: q.Limit}
+	if q.Offset+len(list) < total {
+		resp.Links.Next = pageLink(c, page+1)
+	}
+	if page > 1 {
+		// Past the end, prev is the last page, or the first when none match.
+		resp.Links.Prev = pageLink(c, max(1, min(page-1, (total+q.Limit-1)/q.Limit)))
+	}
+	respond(c, http.StatusOK, resp)
+}
+
+// streamBatchSize is how many albums getAlbumStream reads and flushes at
+// a time.
+const streamBatchSize = 100
+
+// getAlbumStream streams every album matching the query's filters, in the
+// query's order, as newline-delimited JSON. Each batch is flushed as soon
+// as it is read so clients can process albums while the rest arrive.
+func (h *albumHandler) getAlbumStream(c *gin.Context) {
+	q, _, errs := parseListQuery(c.Request.URL.Query())
+	if len(errs) > 0 {
+		writeProblem(c, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	ctx := c.Request.Context()
+	q.Offset, q.Limit = 0, streamBatchSize
+
+	c.Header("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(c.Writer)
+	for {
+		page, _, err := h.albums.List(ctx, q)
+		if err != nil {
+			if q.Offset == 0 {
+				respondStoreError(c, err)
+				return
+			}
+			// The status is already sent, so the stream just ends early.
+			slog.ErrorContext(ctx, "album stream failed", "err", err, "sent", q.Offset)
+			return
+		}
+		for _, a := range page {
+			if err := enc.Encode(a); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		if len(page) < q.Limit || ctx.Err() != nil {
+			return
+		}
+		q.Offset += len(page)
+		if stopRequested(ctx) {
+			// End after a whole album, so the client gets a well-formed
+			// stream it can resume with offsets, rather than a cut off line.
+			slog.WarnContext(ctx, "album stream ended by shutdown", "sent", q.Offset)
+			return
+		}
+	}
+}
+
+// postAlbums adds an album, or a batch of albums when the request body is
+// a list, from the request body.
+func (h *albumHandler) postAlbums(c *gin.Context) {
+	if isBatch(c) {
+		addLogField(c.Request.Context(), "batch", true)
+		h.postAlbumBatch(c)
+		return
+	}
+	h.postAlbum(c)
+}
+
+// postAlbum adds a single album from JSON received in the request body.
+func (h *albumHandler) postAlbum(c *gin.Context) {
+	var newAlbum album
+
+	// Call bindAlbums to bind the received body to
+	// newAlbum and validate it.
+	if !h.bindAlbums(c, "", &newAlbum) {
+		return
+	}
+	if err := h.sanitizer.sanitizeAlbum(&newAlbum); err != nil {
+		writeProblem(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	// Add the new album to the store, which assigns an ID if none was
+	// given.
+	h.createAlbums(c, false, newAlbum)
+}
+
+// postAlbumBatch adds every album in the JSON array received in the
+// request body.
+func (h *albumHandler) postAlbumBatch(c *gin.Context) {
+	var newAlbums albumList
+
+	if !h.bindAlbums(c, "", &newAlbums) {
+		return
+	}
+	for i := range newAlbums {
+		if err := h.sanitizer.sanitizeAlbum(&newAlbums[i]); err != nil {
+			writeProblem(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+
+	h.createAlbums(c, true, newAlbums...)
+}
+
+// createAlbums adds validated albums and responds with what was created:
+// the album itself, or the list for a batch. With ?async=true it responds
+// 202 at once and the job's result holds the created albums.
+func (h *albumHandler) createAlbums(c *gin.Context, batch bool, list ...album) {
+	async, err := strconv.ParseBool(c.DefaultQuery("async", "false"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid query parameters", fieldError{Field: "async", Message: "must be true or false"})
+		return
+	}
+	create := func(ctx context.Context) (any, error) {
+		created, err := h.albums.Add(ctx, list...)
+		if err != nil {
+			return nil, err
+		}
+		if batch {
+			return albumList(created), nil
+		}
+		return created[0], nil
+	}
+	if async {
+		h.acceptJob(c, create)
+		return
+	}
+
+	created, err := create(c.Request.Context())
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	respond(c, http.StatusCreated, created)
+}
+
+// getAlbumByID locates the album whose ID value matches the id
+// parameter sent by the client, then returns that album as a response.
+func (h *albumHandler) getAlbumByID(c *gin.Context) {
+	a, err := h.albums.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.Header("ETag", albumETag(a))
+	respond(c, http.StatusOK, a)
+}
+
+// putAlbum replaces the album whose ID matches the id parameter with the
+// JSON received in the request body, if the version the request names is
+// still current.
+func (h *albumHandler) putAlbum(c *gin.Context) {
+	id := c.Param("id")
+	var a album
+
+	if !h.bindAlbums(c, id, &a) {
+		return
+	}
+	if a.ID != "" && a.ID != id {
+		writeProblem(c, http.StatusBadRequest, "album id does not match the URL")
+		return
+	}
+	if err := h.sanitizer.sanitizeAlbum(&a); err != nil {
+		writeProblem(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	version, fromHeader, ok := requestVersion(c, a.Version)
+	if !ok {
+		return
+	}
+	a.Version = version
+
+	updated, err := h.albums.Replace(c.Request.Context(), id, a)
+	if errors.Is(err, repository.ErrVersionConflict) && fromHeader {
+		writeProblem(c, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.Header("ETag", albumETag(updated))
+	respond(c, http.StatusOK, updated)
+}
+
+// deleteAlbum marks the album whose ID matches the id parameter deleted.
+func (h *albumHandler) deleteAlbum(c *gin.Context) {
+	if !h.checkIfMatch(c, c.Param("id")) {
+		return
+	}
+	if err := h.albums.Remove(c.Request.Context(), c.Param("id")); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// respondStoreError responds with the problem matching a
+// repository.Repository error.
+func respondStoreError(c *gin.Context, err error) {
+	status, detail := storeErrorStatus(c.Request.Context(), err)
+	writeProblem(c, status, detail)
+}
+
+// storeErrorStatus returns the status and detail a repository.Repository
+// error is reported with. Unexpected errors are logged rather than shown.
+func storeErrorStatus(ctx context.Context, err error) (int, string) {
+	switch {
+	case errors.Is(err, repository.ErrAlbumNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, repository.ErrAlbumExists), errors.Is(err, repository.ErrVersionConflict):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "request did not complete in time"
+	}
+	slog.ErrorContext(ctx, "album store failed", "err", err)
+	return http.StatusInternalServerError, "internal error"
+}
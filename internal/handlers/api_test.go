@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// apiSuite drives a test server over HTTP through httptest.NewServer, as
+// clients would. Values saved from responses are substituted for {name}
+// in later paths, headers and fixtures.
+type apiSuite struct {
+	*testServer
+	url string
+	// client sends no cookies; sessions keeps the session cookie.
+	client, sessions *http.Client
+	vars             map[string]string
+}
+
+// apiCase is one request of the suite and what it should get.
+type apiCase struct {
+	name   string
+	method string
+	path   string
+	// fixture names the body in testdata/fixtures. A .txt fixture is
+	// uploaded as the file part of a multipart/form-data body.
+	fixture string
+	headers []string
+	status  int
+	// golden compares the body with testdata/golden/<name>.golden.
+	golden bool
+	// session sends the request with the session cookie.
+	session bool
+	// save keeps the response's top-level JSON fields as vars.
+	save map[string]string
+	// check, if set, makes further assertions on the response.
+	check func(t *testing.T, resp *http.Response, body []byte)
+}
+
+// newAPISuite starts a server with every feature that needs no outside
+// service turned on: logins, API keys, sessions, roles, the audit log,
+// webhooks, an upstream and GraphiQL.
+func newAPISuite(t *testing.T) *apiSuite {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/quote" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"quote": 1.25}`)
+	}))
+	t.Cleanup(upstream.Close)
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(hooks.Close)
+
+	ann, _ := bcrypt.GenerateFromPassword([]byte("ann-password"), bcrypt.MinCost)
+	bob, _ := bcrypt.GenerateFromPassword([]byte("bob-password"), bcrypt.MinCost)
+	ciKey := sha256.Sum256([]byte("ci-key"))
+	ts := newTestServer(t, map[string]string{
+		"JWT_SECRET":            "integration-test-secret",
+		"AUTH_USERS":            "ann:" + string(ann) + ",bob:" + string(bob),
+		"AUTH_ROLES":            "ann:admin,bob:readonly",
+		"ADMIN_TOKEN":           "admin-token",
+		"API_KEY_HASHES":        "ci:" + hex.EncodeToString(ciKey[:]),
+		"SESSIONS_ENABLED":      "true",
+		"SESSION_COOKIE_SECURE": "false",
+		"AUDIT_LOG":             "file",
+		"AUDIT_FILE":            filepath.Join(t.TempDir(), "audit.log"),
+		"WEBHOOKS_ENABLED":      "true",
+		"WEBHOOK_ALLOW_PRIVATE": "true",
+		"UPSTREAMS":             "prices=" + upstream.URL,
+		"GRAPHIQL_ENABLED":      "true",
+	})
+	// PUT /admin/log-level changes the process-wide level.
+	t.Cleanup(func() { logLevel.Set(slog.LevelError) })
+
+	srv := httptest.NewServer(ts.router)
+	t.Cleanup(srv.Close)
+	jar, _ := cookiejar.New(nil)
+	return &apiSuite{
+		testServer: ts,
+		url:        srv.URL,
+		client:     srv.Client(),
+		sessions:   &http.Client{Transport: srv.Client().Transport, Jar: jar},
+		vars:       map[string]string{"hook_url": hooks.URL + "/hook"},
+	}
+}
+
+// fixture returns the contents of testdata/fixtures/name.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "fixtures", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// expand substitutes the suite's vars for {name} in s.
+func (s *apiSuite) expand(str string) string {
+	for k, v := range s.vars {
+		str = strings.ReplaceAll(str, "{"+k+"}", v)
+	}
+	return str
+}
+
+// run sends c's request and checks the response against it.
+func (s *apiSuite) run(t *testing.T, c apiCase) {
+	t.Helper()
+	var body io.Reader
+	contentType := ""
+	if c.fixture != "" {
+		data := fixture(t, c.fixture)
+		if strings.HasSuffix(c.fixture, ".txt") {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			part, _ := mw.CreateFormFile("file", c.fixture)
+			part.Write(data)
+			mw.Close()
+			body, contentType = &buf, mw.FormDataContentType()
+		} else {
+			body, contentType = strings.NewReader(s.expand(string(data))), "application/json"
+		}
+	}
+	req, err := http.NewRequest(c.method, s.url+s.expand(c.path), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(c.headers); i += 2 {
+		req.Header.Set(c.headers[i], s.expand(c.headers[i+1]))
+	}
+	client := s.client
+	if c.session {
+		client = s.sessions
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != c.status {
+		t.Fatalf("%s %s: status %d, want %d; body: %s", c.method, req.URL.Path, resp.StatusCode, c.status, got)
+	}
+	if len(c.save) > 0 {
+		var fields map[string]any
+		if err := json.Unmarshal(got, &fields); err != nil {
+			t.Fatalf("response to save from is not a JSON object: %s", got)
+		}
+		for name, field := range c.save {
+			v, ok := fields[field].(string)
+			if !ok {
+				t.Fatalf("response has no string %s to save: %s", field, got)
+			}
+			s.vars[name] = v
+		}
+	}
+	if c.golden {
+		s.compareGolden(t, c.name, resp.Header.Get("Content-Type"), got)
+	}
+	if c.check != nil {
+		c.check(t, resp, got)
+	}
+}
+
+// scrubbed are the JSON fields whose values change from run to run.
+var scrubbed = map[string]bool{
+	"request_id": true, "token": true, "csrf_token": true, "secret": true, "key": true,
+	"expires_at": true, "created_at": true, "updated_at": true, "deleted_at": true, "time": true,
+	"latency_ms": true,
+}
+
+// timestamp matches the export's deleted_at and file names' times.
+var timestamp = regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z`)
+
+// compareGolden compares body, with what changes between runs scrubbed
+// and JSON indented, with testdata/golden/name.golden, rewriting the file
+// instead with -update.
+func (s *apiSuite) compareGolden(t *testing.T, name, contentType string, body []byte) {
+	t.Helper()
+	if strings.Contains(contentType, "json") && !strings.Contains(contentType, "ndjson") {
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			t.Fatalf("invalid JSON body: %v\n%s", err, body)
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(scrub(v))
+		body = buf.Bytes()
+	}
+	out := timestamp.ReplaceAllString(string(body), "<time>")
+	for k, v := range s.vars {
+		if k != "hook_url" && v != "" {
+			out = strings.ReplaceAll(out, v, "{"+k+"}")
+		}
+	}
+	out = strings.ReplaceAll(out, s.url, "{url}")
+
+	path := filepath.Join("testdata", "golden", strings.ReplaceAll(name, " ", "_")+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test with -update to write it", err)
+	}
+	if out != string(want) {
+		t.Errorf("body differs from %s:\n got: %s\nwant: %s", path, out, want)
+	}
+}
+
+// scrub replaces the values of scrubbed fields throughout v.
+func scrub(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			if scrubbed[k] && x != nil {
+				v[k] = "<" + k + ">"
+				continue
+			}
+			v[k] = scrub(x)
+		}
+	case []any:
+		for i, x := range v {
+			v[i] = scrub(x)
+		}
+	}
+	return v
+}
+
+// Credentials sent by the cases.
+var (
+	asAnn   = []string{"Authorization", "Bearer {ann}"}
+	asBob   = []string{"Authorization", "Bearer {bob}"}
+	asKey   = []string{"X-API-Key", "ci-key"}
+	asAdmin = []string{"X-Admin-Token", "admin-token"}
+)
+
+// with returns headers followed by more.
+func with(headers []string, more ...string) []string {
+	return append(append([]string(nil), headers...), more...)
+}
+
+func TestAPI(t *testing.T) {
+	s := newAPISuite(t)
+	cases := []apiCase{
+		// Probes, docs and the page.
+		{name: "healthz", method: "GET", path: "/healthz", status: 200, golden: true},
+		{name: "readyz", method: "GET", path: "/readyz", status: 200, golden: true},
+		{name: "metrics", method: "GET", path: "/metrics", status: 200, check: bodyContains("go_goroutines")},
+		{name: "openapi", method: "GET", path: "/openapi.json", status: 200, check: bodyContains(`"openapi": "3.0.3"`)},
+		{name: "docs", method: "GET", path: "/docs", status: 200},
+		{name: "index", method: "GET", path: "/", status: 200},
+		{name: "favicon", method: "GET", path: "/favicon.ico", status: 204},
+		{name: "missing asset", method: "GET", path: "/assets/missing.js", status: 404, golden: true},
+		{name: "graphiql", method: "GET", path: "/graphiql", status: 200},
+		{name: "graphql", method: "POST", path: "/graphql", fixture: "graphql-albums.json", status: 200, golden: true},
+		{name: "no route", method: "GET", path: "/v1/nowhere", status: 404, golden: true},
+		{name: "wrong method", method: "PATCH", path: "/v1/albums", status: 405, golden: true},
+		{name: "websocket without upgrade", method: "GET", path: "/v1/ws", status: 426, golden: true},
+
+		// Reading albums.
+		{name: "list albums", method: "GET", path: "/v1/albums?sort=price:desc&limit=2", status: 200, golden: true},
+		{name: "list albums unversioned", method: "GET", path: "/albums?limit=1", status: 200, check: hasHeader("Deprecation", "true")},
+		{name: "list albums bad page", method: "GET", path: "/v1/albums?page=0&sort=color", status: 400, golden: true},
+		{name: "get album", method: "GET", path: "/v1/albums/1", status: 200, golden: true},
+		{name: "get missing album", method: "GET", path: "/v1/albums/99", status: 404, golden: true},
+		{name: "stream albums", method: "GET", path: "/v1/albums/stream?sort=id", status: 200, golden: true},
+		{name: "export albums", method: "GET", path: "/v1/albums/export?sort=id", status: 200, golden: true},
+		{name: "export bad format", method: "GET", path: "/v1/albums/export?format=xml", status: 400, golden: true},
+		{name: "events bad last id", method: "GET", path: "/v1/events", headers: []string{"Last-Event-ID", "x"}, status: 400, golden: true},
+
+		// Logging in.
+		{name: "create album anonymously", method: "POST", path: "/v1/albums", fixture: "album.json", status: 401, golden: true},
+		{name: "login wrong password", method: "POST", path: "/v1/login", fixture: "login-wrong.json", status: 401, golden: true},
+		{name: "login", method: "POST", path: "/v1/login", fixture: "login-ann.json", status: 200, golden: true, session: true, save: map[string]string{"ann": "token", "csrf": "csrf_token"}},
+		{name: "login readonly", method: "POST", path: "/v1/login", fixture: "login-bob.json", status: 200, save: map[string]string{"bob": "token"}},
+		{name: "session", method: "GET", path: "/v1/session", status: 200, golden: true, session: true},
+		{name: "session without cookie", method: "GET", path: "/v1/session", status: 401},
+		{name: "oidc off", method: "GET", path: "/v1/auth/login", status: 404},
+
+		// Changing albums.
+		{name: "create album readonly", method: "POST", path: "/v1/albums", fixture: "album.json", headers: asBob, status: 403, golden: true},
+		{name: "create invalid album", method: "POST", path: "/v1/albums", fixture: "album-invalid.json", headers: asAnn, status: 400, golden: true},
+		{name: "create album", method: "POST", path: "/v1/albums", fixture: "album.json", headers: with(asAnn, "Idempotency-Key", "create-4"), status: 201, golden: true},
+		{name: "create album again", method: "POST", path: "/v1/albums", fixture: "album.json", headers: with(asAnn, "Idempotency-Key", "create-4"), status: 201, check: hasHeader("Idempotent-Replayed", "true")},
+		{name: "create taken id", method: "POST", path: "/v1/albums", fixture: "album.json", headers: asKey, status: 409, golden: true},
+		{name: "update without version", method: "PUT", path: "/v1/albums/4", fixture: "album-update.json", headers: asAnn, status: 428, golden: true},
+		{name: "update album", method: "PUT", path: "/v1/albums/4", fixture: "album-update.json", headers: with(asAnn, "If-Match", `"v1"`), status: 200, golden: true},
+		{name: "update stale version", method: "PUT", path: "/v1/albums/4", fixture: "album-update.json", headers: with(asAnn, "If-Match", `"v1"`), status: 412, golden: true},
+		{name: "batch", method: "POST", path: "/v1/albums/batch", fixture: "batch.json", headers: asAnn, status: 200, golden: true},
+		{name: "create albums async", method: "POST", path: "/v1/albums?async=true", fixture: "albums-async.json", headers: asKey, status: 202, save: map[string]string{"job": "id"}},
+		{name: "get job", method: "GET", path: "/v1/jobs/{job}", status: 200},
+		{name: "get missing job", method: "GET", path: "/v1/jobs/nope", status: 404, golden: true},
+		{name: "delete album", method: "DELETE", path: "/v1/albums/4", headers: asAnn, status: 204},
+		{name: "get deleted album", method: "GET", path: "/v1/albums/4", status: 404},
+		{name: "list deleted readonly", method: "GET", path: "/v1/albums?include_deleted=true", headers: asBob, status: 403, golden: true},
+		{name: "list deleted", method: "GET", path: "/v1/albums?include_deleted=true&artist=Miles+Davis", headers: asAnn, status: 200, golden: true},
+		{name: "restore album", method: "POST", path: "/v1/albums/4/restore", headers: asAnn, status: 200, golden: true},
+		{name: "restore live album", method: "POST", path: "/v1/albums/4/restore", headers: asAnn, status: 404},
+		{name: "delete missing album", method: "DELETE", path: "/v1/albums/99", headers: asAnn, status: 404, golden: true},
+
+		// Files.
+		{name: "upload", method: "POST", path: "/v1/upload", fixture: "upload.txt", headers: asAnn, status: 201, save: map[string]string{"file": "id"}},
+		{name: "upload without file", method: "POST", path: "/v1/upload", fixture: "album.json", headers: asAnn, status: 415, golden: true},
+		{name: "get file", method: "GET", path: "/v1/files/{file}", status: 200, check: bodyContains("Liner notes.")},
+		{name: "get missing file", method: "GET", path: "/v1/files/nope", status: 404, golden: true},
+
+		// Upstreams.
+		{name: "upstream", method: "GET", path: "/v1/upstreams/prices/quote", status: 200, golden: true},
+		{name: "missing upstream", method: "GET", path: "/v1/upstreams/stock/quote", status: 404, golden: true},
+
+		// Webhooks.
+		{name: "register webhook", method: "POST", path: "/v1/webhooks", fixture: "webhook.json", headers: asAnn, status: 201, save: map[string]string{"hook": "id"}},
+		{name: "list webhooks", method: "GET", path: "/v1/webhooks", headers: asBob, status: 200},
+		{name: "list deliveries", method: "GET", path: "/v1/webhooks/{hook}/deliveries", headers: asAnn, status: 200},
+		{name: "replay missing delivery", method: "POST", path: "/v1/webhooks/{hook}/deliveries/nope/replay", headers: asAnn, status: 404, golden: true},
+		{name: "delete webhook", method: "DELETE", path: "/v1/webhooks/{hook}", headers: asAnn, status: 204},
+		{name: "delete missing webhook", method: "DELETE", path: "/v1/webhooks/{hook}", headers: asAnn, status: 404, golden: true},
+
+		// Administration.
+		{name: "admin anonymously", method: "GET", path: "/v1/admin/config", status: 401, golden: true},
+		{name: "admin readonly", method: "GET", path: "/v1/admin/config", headers: asBob, status: 403},
+		{name: "config", method: "GET", path: "/v1/admin/config", headers: asAdmin, status: 200, check: bodyContains(`"JWT_SECRET": "[redacted]"`)},
+		{name: "create key", method: "POST", path: "/v1/admin/keys", fixture: "key.json", headers: asAdmin, status: 201, golden: true, save: map[string]string{"key_id": "id"}},
+		{name: "list keys", method: "GET", path: "/v1/admin/keys", headers: asAnn, status: 200},
+		{name: "delete key", method: "DELETE", path: "/v1/admin/keys/{key_id}", headers: asAdmin, status: 204},
+		{name: "delete missing key", method: "DELETE", path: "/v1/admin/keys/{key_id}", headers: asAdmin, status: 404, golden: true},
+		{name: "put role", method: "PUT", path: "/v1/admin/roles/carol", fixture: "role.json", headers: asAdmin, status: 200},
+		{name: "list roles", method: "GET", path: "/v1/admin/roles", headers: asAdmin, status: 200, golden: true},
+		{name: "delete role", method: "DELETE", path: "/v1/admin/roles/carol", headers: asAdmin, status: 204},
+		{name: "delete missing role", method: "DELETE", path: "/v1/admin/roles/carol", headers: asAdmin, status: 404, golden: true},
+		{name: "audit", method: "GET", path: "/v1/admin/audit?route=/albums/:id&limit=2", headers: asAdmin, status: 200, check: bodyContains(`"path": "/v1/albums/4"`)},
+		{name: "audit bad query", method: "GET", path: "/v1/admin/audit?limit=0", headers: asAdmin, status: 400, golden: true},
+		{name: "get log level", method: "GET", path: "/v1/admin/log-level", headers: asAdmin, status: 200},
+		{name: "put log level", method: "PUT", path: "/v1/admin/log-level", fixture: "log-level.json", headers: asAdmin, status: 200, golden: true},
+		{name: "put bad log level", method: "PUT", path: "/v1/admin/log-level", fixture: "role.json", headers: asAdmin, status: 400, golden: true},
+		{name: "get debug", method: "GET", path: "/v1/admin/debug", headers: asAdmin, status: 200, golden: true},
+		{name: "profiles off", method: "GET", path: "/debug/pprof/", status: 404},
+		{name: "put debug", method: "PUT", path: "/v1/admin/debug", fixture: "on.json", headers: asAdmin, status: 200},
+		{name: "profiles on", method: "GET", path: "/debug/pprof/", status: 200},
+		{name: "list flags", method: "GET", path: "/v1/admin/flags", headers: asAdmin, status: 200},
+		{name: "put flag", method: "PUT", path: "/v1/admin/flags/beta", fixture: "flag.json", headers: asAdmin, status: 200, golden: true},
+		{name: "delete flag", method: "DELETE", path: "/v1/admin/flags/beta", headers: asAdmin, status: 204},
+		{name: "delete missing flag", method: "DELETE", path: "/v1/admin/flags/beta", headers: asAdmin, status: 404, golden: true},
+		{name: "get maintenance", method: "GET", path: "/v1/admin/maintenance", headers: asAdmin, status: 200},
+		{name: "put maintenance", method: "PUT", path: "/v1/admin/maintenance", fixture: "on.json", headers: asAdmin, status: 200},
+		{name: "in maintenance", method: "GET", path: "/v1/albums", status: 503, golden: true, check: hasHeader("Retry-After", "300")},
+		{name: "end maintenance", method: "PUT", path: "/v1/admin/maintenance", fixture: "off.json", headers: asAdmin, status: 200},
+
+		// Ending sessions.
+		{name: "logout without csrf", method: "POST", path: "/v1/logout", status: 403, session: true, golden: true},
+		{name: "logout", method: "POST", path: "/v1/logout", headers: []string{"X-CSRF-Token", "{csrf}"}, status: 204, session: true},
+		{name: "session after logout", method: "GET", path: "/v1/session", status: 401, session: true},
+		{name: "end sessions", method: "DELETE", path: "/v1/admin/sessions/ann", headers: asAdmin, status: 204},
+	}
+	for _, c := range cases {
+		s.run(t, c)
+		if t.Failed() {
+			t.Fatalf("case %q failed", c.name)
+		}
+	}
+}
+
+// bodyContains checks that the body holds want.
+func bodyContains(want string) func(t *testing.T, resp *http.Response, body []byte) {
+	return func(t *testing.T, resp *http.Response, body []byte) {
+		t.Helper()
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("body does not contain %s:\n%s", want, body)
+		}
+	}
+}
+
+// hasHeader checks that the response has the header name set to want.
+func hasHeader(name, want string) func(t *testing.T, resp *http.Response, body []byte) {
+	return func(t *testing.T, resp *http.Response, body []byte) {
+		t.Helper()
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAPIEventStream(t *testing.T) {
+	s := newAPISuite(t)
+	s.run(t, apiCase{name: "login", method: "POST", path: "/v1/login", fixture: "login-ann.json", status: 200, save: map[string]string{"ann": "token"}})
+	s.run(t, apiCase{name: "create album", method: "POST", path: "/v1/albums", fixture: "album.json", headers: asAnn, status: 201})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/v1/events", nil)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// The album created above is replayed first.
+	buf := make([]byte, 4096)
+	var got []byte
+	for !bytes.Contains(got, []byte("\n\n")) {
+		n, err := resp.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("read %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	s.compareGolden(t, "event stream", "text/event-stream", got)
+}
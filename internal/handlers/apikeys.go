@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyHeader carries an API key.
+const apiKeyHeader = "X-API-Key"
+
+// keySubjectPrefix starts the subject of every API key, keeping key names
+// apart from user names in role and tenant assignments, so a key can never
+// be issued as, or given the role of, a user.
+const keySubjectPrefix = "key:"
+
+// keySubject returns the subject of the API keys named name.
+func keySubject(name string) string { return keySubjectPrefix + name }
+
+// apiKey describes an issued key. The key itself is only ever stored as a
+// SHA-256 hash.
+type apiKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiKeyStore is a thread-safe set of hashed API keys.
+type apiKeyStore struct {
+	mu     sync.RWMutex
+	byHash map[string]apiKey
+	// roles gives the role of each key, by name.
+	roles *roleStore
+	// tenants gives the tenant of each key, by name.
+	tenants tenantAssignments
+}
+
+// newAPIKeyStore returns a store preloaded from hashes, a comma-separated
+// list of "name:hex-sha256" entries.
+func newAPIKeyStore(hashes string, roles *roleStore) (*apiKeyStore, error) {
+	s := &apiKeyStore{byHash: make(map[string]apiKey), roles: roles}
+	for _, entry := range strings.Split(hashes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, hash, ok := strings.Cut(entry, ":")
+		if b, err := hex.DecodeString(hash); !ok || err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API_KEY_HASHES entry %q must look like \"name:hex-sha256\"", entry)
+		}
+		s.byHash[strings.ToLower(hash)] = apiKey{ID: randomHex(8), Name: name, Subject: keySubject(name), CreatedAt: time.Now().UTC()}
+	}
+	return s, nil
+}
+
+// hashAPIKey returns the stored form of key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// issue creates a key named name and returns its record and the key, which
+// cannot be recovered later.
+func (s *apiKeyStore) issue(name string) (apiKey, string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	key := "ak_" + base64.RawURLEncoding.EncodeToString(b)
+	rec := apiKey{ID: randomHex(8), Name: name, Subject: keySubject(name), CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byHash[hashAPIKey(key)] = rec
+	return rec, key
+}
+
+// revoke deletes the key with the given ID and reports whether it existed.
+func (s *apiKeyStore) revoke(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, rec := range s.byHash {
+		if rec.ID == id {
+			delete(s.byHash, hash)
+			return true
+		}
+	}
+	return false
+}
+
+// list returns every key record, oldest first.
+func (s *apiKeyStore) list() []apiKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]apiKey, 0, len(s.byHash))
+	for _, rec := range s.byHash {
+		keys = append(keys, rec)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// authenticate looks up the request's X-API-Key.
+func (s *apiKeyStore) authenticate(r *http.Request) (*identity, error) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	s.mu.RLock()
+	rec, ok := s.byHash[hashAPIKey(key)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	return &identity{subject: rec.Subject, method: "api_key", role: s.roles.roleOf(rec.Subject), tenant: s.tenants.of(rec.Subject)}, nil
+}
+
+// known reports whether key is one of s. A nil store knows no keys.
+func (s *apiKeyStore) known(key string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.byHash[hashAPIKey(key)]
+	return ok
+}
+
+func (s *apiKeyStore) challenge() string {
+	return ""
+}
+
+// createAPIKey issues a key named by the JSON request body and responds
+// with it; this is the only time the key is shown. A role in the body is
+// assigned to the key's subject.
+func (s *apiKeyStore) createAPIKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required,max=100"`
+		Role string `json:"role" binding:"omitempty,oneof=readonly user admin"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	if req.Role != "" {
+		s.roles.assign(keySubject(req.Name), role(req.Role))
+	}
+	rec, key := s.issue(req.Name)
+	c.IndentedJSON(http.StatusCreated, gin.H{"id": rec.ID, "name": rec.Name, "subject": rec.Subject, "created_at": rec.CreatedAt, "key": key, "role": s.roles.roleOf(rec.Subject)})
+}
+
+// getAPIKeys responds with every key record, without the keys themselves.
+func (s *apiKeyStore) getAPIKeys(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, s.list())
+}
+
+// deleteAPIKey revokes the key whose ID matches the id parameter.
+func (s *apiKeyStore) deleteAPIKey(c *gin.Context) {
+	if !s.revoke(c.Param("id")) {
+		writeProblem(c, http.StatusNotFound, "api key not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/repository"
+)
+
+// Bounds of the limit parameter of GET /admin/audit.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// undigestedRoutes are routes whose bodies hold passwords. Their payload
+// digest is left empty, as a hash of a short secret could be reversed.
+var undigestedRoutes = map[string]bool{"/login": true}
+
+// auditEntry records one request that could change something.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	// Actor is the caller's subject, or "" if it did not authenticate.
+	Actor         string `json:"actor"`
+	AuthMethod    string `json:"auth_method,omitempty"`
+	ClientIP      string `json:"client_ip"`
+	Method        string `json:"method"`
+	Route         string `json:"route"`
+	Path          string `json:"path"`
+	Status        int    `json:"status"`
+	PayloadDigest string `json:"payload_digest,omitempty"`
+}
+
+// auditQuery selects entries from the audit trail. Zero fields match
+// everything.
+type auditQuery struct {
+	actor, route, requestID string
+	since, until            time.Time
+	limit                   int
+}
+
+// matches reports whether e is selected by q, ignoring its limit.
+func (q auditQuery) matches(e auditEntry) bool {
+	return (q.actor == "" || e.Actor == q.actor) &&
+		(q.route == "" || e.Route == q.route) &&
+		(q.requestID == "" || e.RequestID == q.requestID) &&
+		(q.since.IsZero() || !e.Time.Before(q.since)) &&
+		(q.until.IsZero() || e.Time.Before(q.until))
+}
+
+// auditSink is an append-only store of audit entries.
+type auditSink interface {
+	// record appends e.
+	record(ctx context.Context, e auditEntry) error
+	// query returns up to q.limit entries q selects, newest first.
+	query(ctx context.Context, q auditQuery) ([]auditEntry, error)
+	close() error
+}
+
+// errAuditOffline is returned by offlineAuditSink.
+var errAuditOffline = errors.New("the audit log is not open")
+
+// offlineAuditSink stands in for the configured sink on an offline
+// server, which never serves.
+type offlineAuditSink struct{}
+
+func (offlineAuditSink) record(context.Context, auditEntry) error { return errAuditOffline }
+
+func (offlineAuditSink) query(context.Context, auditQuery) ([]auditEntry, error) {
+	return nil, errAuditOffline
+}
+
+func (offlineAuditSink) close() error { return nil }
+
+// openAuditSink returns the sink cfg.AuditLog selects: a JSON Lines file at
+// AUDIT_FILE, or a table in the album store's SQL database.
+func openAuditSink(ctx context.Context, cfg config.Config) (auditSink, error) {
+	if cfg.AuditLog == "file" {
+		return openFileAuditSink(cfg.AuditFile)
+	}
+	db, d, err := repository.OpenDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlAuditSink{db: db, d: d}, nil
+}
+
+// auditRequests returns middleware that records every POST, PUT, PATCH and
+// DELETE to sink once it has been handled: who sent it, to which route,
+// a SHA-256 digest of the body the handler read, and the status.
+func auditRequests(sink auditSink) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		body := &digestReader{ReadCloser: c.Request.Body, h: sha256.New()}
+		c.Request.Body = body
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		e := auditEntry{
+			Time:      start.UTC(),
+			RequestID: requestIDFrom(c.Request.Context()),
+			ClientIP:  c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     strings.TrimPrefix(route, "/v1"),
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+		}
+		if id := identityFrom(c.Request.Context()); id != nil {
+			e.Actor, e.AuthMethod = id.subject, id.method
+		}
+		if body.n > 0 && !undigestedRoutes[e.Route] {
+			e.PayloadDigest = hex.EncodeToString(body.h.Sum(nil))
+		}
+		if err := sink.record(c.Request.Context(), e); err != nil {
+			slog.ErrorContext(c.Request.Context(), "audit record failed", "err", err)
+		}
+	}
+}
+
+// digestReader hashes a request body as the handler reads it, so bodies
+// need not be buffered, whatever their size.
+type digestReader struct {
+	io.ReadCloser
+	h hash.Hash
+	n int64
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// getAudit responds with the audit entries selected by the actor, route,
+// request_id, since and until parameters, newest first.
+func getAudit(sink auditSink) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, errs := parseAuditQuery(c)
+		if len(errs) > 0 {
+			writeProblem(c, http.StatusBadRequest, "invalid query parameters", errs...)
+			return
+		}
+		list, err := sink.query(c.Request.Context(), q)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "audit query failed", "err", err)
+			writeProblem(c, http.StatusInternalServerError, "could not read the audit log")
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"data": list})
+	}
+}
+
+func parseAuditQuery(c *gin.Context) (auditQuery, []fieldError) {
+	q := auditQuery{
+		actor:     c.Query("actor"),
+		route:     strings.TrimPrefix(c.Query("route"), "/v1"),
+		requestID: c.Query("request_id"),
+		limit:     defaultAuditLimit,
+	}
+	var errs []fieldError
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs = append(errs, fieldError{Field: p.name, Message: "must be an RFC 3339 time"})
+				continue
+			}
+			*p.dst = t.UTC()
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			errs = append(errs, fieldError{Field: "limit", Message: "must be between 1 and %d", args: []any{maxAuditLimit}})
+		} else {
+			q.limit = n
+		}
+	}
+	return q, errs
+}
+
+// fileAuditSink appends entries to a file as JSON Lines.
+type fileAuditSink struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+func openFileAuditSink(path string) (*fileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &fileAuditSink{f: f, path: path}, nil
+}
+
+func (s *fileAuditSink) record(_ context.Context, e auditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// query scans the whole file; it is meant for occasional use by admins.
+func (s *fileAuditSink) query(_ context.Context, q auditQuery) ([]auditEntry, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := []auditEntry{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e auditEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil || !q.matches(e) {
+			continue
+		}
+		list = append(list, e)
+		if len(list) > q.limit {
+			list = list[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
+
+func (s *fileAuditSink) close() error { return s.f.Close() }
+
+// sqlAuditSink keeps entries in the audit_log table, which only ever
+// gets inserts.
+type sqlAuditSink struct {
+	db *sql.DB
+	d  repository.Dialect
+}
+
+// auditColumns are the columns of audit_log an entry is stored in.
+const auditColumns = "time, request_id, actor, auth_method, client_ip, method, route, path, status, payload_digest"
+
+func (s *sqlAuditSink) record(ctx context.Context, e auditEntry) error {
+	args := []any{e.Time.Format(repository.TimeFormat), e.RequestID, e.Actor, e.AuthMethod, e.ClientIP, e.Method, e.Route, e.Path, e.Status, e.PayloadDigest}
+	ph := make([]string, len(args))
+	for i := range ph {
+		ph[i] = s.d.Placeholder(i + 1)
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO audit_log ("+auditColumns+") VALUES ("+strings.Join(ph, ", ")+")", args...)
+	return err
+}
+
+func (s *sqlAuditSink) query(ctx context.Context, q auditQuery) ([]auditEntry, error) {
+	var conds []string
+	var args []any
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+s.d.Placeholder(len(args)))
+	}
+	if q.actor != "" {
+		where("actor =", q.actor)
+	}
+	if q.route != "" {
+		where("route =", q.route)
+	}
+	if q.requestID != "" {
+		where("request_id =", q.requestID)
+	}
+	if !q.since.IsZero() {
+		where("time >=", q.since.Format(repository.TimeFormat))
+	}
+	if !q.until.IsZero() {
+		where("time <", q.until.Format(repository.TimeFormat))
+	}
+	var filter string
+	if len(conds) > 0 {
+		filter = " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, q.limit)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log"+filter+" ORDER BY id DESC LIMIT "+s.d.Placeholder(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []auditEntry{}
+	for rows.Next() {
+		var e auditEntry
+		var t any
+		if err := rows.Scan(&t, &e.RequestID, &e.Actor, &e.AuthMethod, &e.ClientIP, &e.Method, &e.Route, &e.Path, &e.Status, &e.PayloadDigest); err != nil {
+			return nil, err
+		}
+		if e.Time, err = repository.ScanTime(t); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlAuditSink) close() error { return s.db.Close() }
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// identityKey is the context key for the authenticated caller.
+type identityKey struct{}
+
+// identity describes an authenticated caller.
+type identity struct {
+	// subject names the user or API key.
+	subject string
+	// method is how the caller authenticated: "jwt", "api_key",
+	// "session" or "admin_token".
+	method string
+	// role is what the caller may do.
+	role role
+	// tenant is the tenant the caller belongs to. It is empty only for the
+	// admin token, which may act for any tenant.
+	tenant string
+}
+
+// identityFrom returns the caller that authenticated the request carrying
+// ctx, or nil.
+func identityFrom(ctx context.Context) *identity {
+	id, _ := ctx.Value(identityKey{}).(*identity)
+	return id
+}
+
+// errNoCredentials is returned by an authenticator when the request carries
+// no credentials of its kind.
+var errNoCredentials = errors.New("missing credentials")
+
+// authenticator checks one kind of request credentials.
+type authenticator interface {
+	// authenticate returns the caller identified by r's credentials,
+	// errNoCredentials if r has none of this kind, or an error describing
+	// why they were rejected.
+	authenticate(r *http.Request) (*identity, error)
+	// challenge is the WWW-Authenticate value for rejected requests, or "".
+	challenge() string
+}
+
+// requireAuth returns middleware that admits requests accepted by any of
+// auths, storing the caller's identity in the request context, and answers
+// 401 otherwise.
+func requireAuth(auths ...authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := authenticateAny(c.Request, auths)
+		if err != nil {
+			rejectAuth(c, auths, err)
+			return
+		}
+		ctx := context.WithValue(c.Request.Context(), identityKey{}, id)
+		c.Request = c.Request.WithContext(ctx)
+		addLogField(ctx, "user", id.subject)
+		c.Next()
+	}
+}
+
+// authenticateAny returns the caller identified by the first of auths that
+// finds credentials in r, or errNoCredentials if none does. Callers of
+// another tenant than r's get errWrongTenant.
+func authenticateAny(r *http.Request, auths []authenticator) (*identity, error) {
+	id, err := identify(r, auths)
+	if err != nil {
+		return nil, err
+	}
+	if id.tenant != "" && id.tenant != tenant.From(r.Context()) {
+		return nil, errWrongTenant
+	}
+	return id, nil
+}
+
+// identify is authenticateAny without the tenant check.
+func identify(r *http.Request, auths []authenticator) (*identity, error) {
+	for _, a := range auths {
+		id, err := a.authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return nil, errNoCredentials
+}
+
+// rejectAuth aborts the request with a 401 and the challenges of auths,
+// or with a 403 when err is errCSRF or errWrongTenant.
+func rejectAuth(c *gin.Context, auths []authenticator, err error) {
+	if errors.Is(err, errCSRF) || errors.Is(err, errWrongTenant) {
+		writeProblem(c, http.StatusForbidden, err.Error())
+		return
+	}
+	for _, a := range auths {
+		if ch := a.challenge(); ch != "" {
+			c.Writer.Header().Add("WWW-Authenticate", ch)
+		}
+	}
+	writeProblem(c, http.StatusUnauthorized, err.Error())
+}
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response takes as long as for a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// jwtAuth issues and validates HS256 bearer tokens for a fixed set of users.
+type jwtAuth struct {
+	secret *secretValue
+	ttl    time.Duration
+	users  map[string][]byte
+	// roles gives the role put in each user's tokens.
+	roles *roleStore
+	// tenants gives the tenant put in each user's tokens.
+	tenants tenantAssignments
+	// sessions, if set, also gets a session for each login.
+	sessions *sessionStore
+}
+
+// tokenClaims are the claims of an issued token.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// Role is the user's role at login. Tokens issued before roles have
+	// none and get roleUser.
+	Role role `json:"role,omitempty"`
+	// Tenant is the user's tenant at login; tokens without one belong to
+	// tenant.Default.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// newJWTAuth returns a jwtAuth signing with secret. users is a
+// comma-separated list of "name:bcrypt-hash" entries.
+func newJWTAuth(secret string, ttl time.Duration, users string, roles *roleStore) (*jwtAuth, error) {
+	a := &jwtAuth{secret: newSecretValue(secret), ttl: ttl, users: make(map[string][]byte), roles: roles}
+	for _, entry := range strings.Split(users, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, hash, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("AUTH_USERS entry %q must look like \"name:bcrypt-hash\"", entry)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("AUTH_USERS entry for %q: %w", name, err)
+		}
+		a.users[name] = []byte(hash)
+	}
+	return a, nil
+}
+
+// loginRequest is the body of POST /login.
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// login responds with a signed token when the request body holds valid
+// credentials.
+func (a *jwtAuth) login(c *gin.Context) {
+	var req loginRequest
+	if !bindBody(c, &req) {
+		return
+	}
+
+	hash, ok := a.users[req.Username]
+	if !ok {
+		hash = dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !ok {
+		writeProblem(c, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	a.respondToken(c, req.Username, a.roles.roleOf(req.Username))
+}
+
+// respondToken responds with a token for subject with role r, and starts
+// a session for it when sessions are on.
+func (a *jwtAuth) respondToken(c *gin.Context, subject string, r role) {
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.ttl)),
+		},
+		Role:   r,
+		Tenant: a.tenants.of(subject),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret.load())
+	if err != nil {
+		writeProblem(c, http.StatusInternalServerError, "could not issue token")
+		return
+	}
+	resp := gin.H{"token": token, "expires_at": claims.ExpiresAt.Time, "role": claims.Role, "tenant": claims.Tenant}
+	if a.sessions != nil {
+		sess, err := a.sessions.create(c, subject, r, claims.Tenant)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "session create failed", "err", err)
+			writeProblem(c, http.StatusServiceUnavailable, "could not start a session")
+			return
+		}
+		resp["csrf_token"] = sess.CSRFToken
+	}
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// authenticate validates the request's bearer token.
+func (a *jwtAuth) authenticate(r *http.Request) (*identity, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errNoCredentials
+	}
+
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (any, error) {
+		return a.secret.load(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, errors.New("token expired")
+	case err != nil:
+		return nil, errors.New("invalid token")
+	}
+	if claims.Role == "" {
+		claims.Role = roleUser
+	}
+	if _, err := parseRole(string(claims.Role)); err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Tenant == "" {
+		claims.Tenant = tenant.Default
+	}
+	return &identity{subject: claims.Subject, method: "jwt", role: claims.Role, tenant: claims.Tenant}, nil
+}
+
+func (a *jwtAuth) challenge() string {
+	return `Bearer realm="albums"`
+}
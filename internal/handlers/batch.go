@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"pspFileAPI/internal/i18n"
+)
+
+// Limits of POST /albums/batch.
+const (
+	maxBatchOperations = 100
+	// batchWorkers is how many operations of one batch run at once.
+	batchWorkers = 8
+)
+
+// batchOperation is one entry of a POST /albums/batch body. The album is
+// checked per operation, so one invalid album fails only its own entry.
+type batchOperation struct {
+	Op    string `json:"op" xml:"op" binding:"required,oneof=create update delete"`
+	ID    string `json:"id" xml:"id" binding:"omitempty,max=64,albumid"`
+	Album *album `json:"album" xml:"album" binding:"-"`
+}
+
+// batchResult is the outcome of one operation: the status it would have
+// got as a request of its own and the album, or what went wrong.
+type batchResult struct {
+	Status int          `json:"status"`
+	Album  *album       `json:"album,omitempty"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// postAlbumBatchOps runs up to maxBatchOperations creates, updates and
+// deletes concurrently and responds 200 with one result per operation, in
+// order. Operations are independent: unlike a POST /albums batch, some
+// may succeed while others fail.
+func (h *albumHandler) postAlbumBatchOps(c *gin.Context) {
+	var ops []batchOperation
+	if !bindBody(c, &ops) {
+		return
+	}
+	if len(ops) == 0 || len(ops) > maxBatchOperations {
+		writeProblem(c, http.StatusBadRequest, "validation failed",
+			fieldError{Field: "body", Message: "must hold between 1 and 100 operations"})
+		return
+	}
+	addLogField(c.Request.Context(), "batch_size", len(ops))
+
+	ctx := c.Request.Context()
+	results := make([]batchResult, len(ops))
+	sem := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+	for i, op := range ops {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, op batchOperation) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = h.runBatchOperation(ctx, op)
+		}(i, op)
+	}
+	wg.Wait()
+
+	lang := i18n.FromContext(ctx)
+	for i := range results {
+		results[i].Detail = i18n.T(lang, results[i].Detail)
+		results[i].Errors = translateFields(lang, results[i].Errors)
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"results": results})
+}
+
+// runBatchOperation validates and applies op.
+func (h *albumHandler) runBatchOperation(ctx context.Context, op batchOperation) batchResult {
+	if op.Op != "create" && op.ID == "" {
+		return batchResult{Status: http.StatusBadRequest, Detail: "validation failed", Errors: []fieldError{{Field: "id", Message: "is required"}}}
+	}
+	var a album
+	if op.Op != "delete" {
+		if op.Album == nil {
+			return batchResult{Status: http.StatusBadRequest, Detail: "validation failed", Errors: []fieldError{{Field: "album", Message: "is required"}}}
+		}
+		a = *op.Album
+		if errs := fieldErrors("album.", binding.Validator.ValidateStruct(&a)); len(errs) > 0 {
+			return batchResult{Status: http.StatusBadRequest, Detail: "validation failed", Errors: errs}
+		}
+		if err := h.sanitizer.sanitizeAlbum(&a); err != nil {
+			return batchResult{Status: http.StatusUnprocessableEntity, Detail: err.Error()}
+		}
+	}
+
+	switch op.Op {
+	case "create":
+		created, err := h.albums.Add(ctx, a)
+		if err != nil {
+			return batchStoreError(ctx, err)
+		}
+		return batchResult{Status: http.StatusCreated, Album: &created[0]}
+	case "update":
+		if a.ID != "" && a.ID != op.ID {
+			return batchResult{Status: http.StatusBadRequest, Detail: "album id does not match the operation id"}
+		}
+		updated, err := h.albums.Replace(ctx, op.ID, a)
+		if err != nil {
+			return batchStoreError(ctx, err)
+		}
+		return batchResult{Status: http.StatusOK, Album: &updated}
+	default:
+		if err := h.albums.Remove(ctx, op.ID); err != nil {
+			return batchStoreError(ctx, err)
+		}
+		return batchResult{Status: http.StatusNoContent}
+	}
+}
+
+// batchStoreError is the result of an operation the store refused.
+func batchStoreError(ctx context.Context, err error) batchResult {
+	status, detail := storeErrorStatus(ctx, err)
+	return batchResult{Status: status, Detail: detail}
+}
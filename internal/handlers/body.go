@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+
+	"pspFileAPI/internal/i18n"
+)
+
+// defaultMaxBodyBytes is the largest request body decoded on routes
+// limitBodies does not cover.
+const defaultMaxBodyBytes int64 = 1 << 20
+
+// maxBodyBytesKey holds the body limit limitBodies sets for a request.
+const maxBodyBytesKey = "maxBodyBytes"
+
+// limitBodies returns middleware capping the request bodies decoded by
+// later handlers at n bytes. Uploads have their own limit.
+func limitBodies(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(maxBodyBytesKey, n)
+	}
+}
+
+// msgpackHandle decodes MessagePack bodies, refusing map keys that name no
+// field. Like gin's renderer, it takes field names from json tags.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.ErrorIfNoField = true
+	return h
+}()
+
+// bodyError is a request body that cannot be decoded, with the status to
+// respond with.
+type bodyError struct {
+	status int
+	// detail is formatted with args, if any, once it is translated.
+	detail string
+	args   []any
+	fields []fieldError
+}
+
+func (e *bodyError) Error() string { return translate(i18n.English, e.detail, e.args...) }
+
+// limitBody caps the request body at the limit limitBodies set, or
+// defaultMaxBodyBytes. Reads beyond it fail with *http.MaxBytesError.
+func limitBody(c *gin.Context) {
+	n := defaultMaxBodyBytes
+	if v, ok := c.Get(maxBodyBytesKey); ok {
+		n = v.(int64)
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+}
+
+// decodeBody decodes the request body into dst according to its
+// Content-Type, which defaults to JSON. The body must hold exactly one
+// value within the limit of limitBody, and JSON and MessagePack objects may not
+// have members dst has no field for.
+// Failures are a *bodyError: 415 for an unsupported type, 413 for a body
+// that is too large and 400 otherwise.
+func decodeBody(c *gin.Context, dst any) error {
+	decode, ok := bodyDecoders[c.ContentType()]
+	if !ok {
+		return &bodyError{status: http.StatusUnsupportedMediaType, detail: "request bodies must be JSON, XML or MessagePack"}
+	}
+
+	limitBody(c)
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &bodyError{status: http.StatusRequestEntityTooLarge, detail: "request body must be at most %d bytes", args: []any{tooLarge.Limit}}
+		}
+		return &bodyError{status: http.StatusBadRequest, detail: "could not read request body"}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &bodyError{status: http.StatusBadRequest, detail: "request body is empty"}
+	}
+	if err := decode(body, dst); err != nil {
+		var be *bodyError
+		if errors.As(err, &be) {
+			return be
+		}
+		return &bodyError{status: http.StatusBadRequest, detail: "invalid body: %v", args: []any{err}}
+	}
+	return nil
+}
+
+// bodyDecoders decode a whole request body by Content-Type.
+var bodyDecoders = map[string]func(body []byte, dst any) error{
+	"":                  decodeJSON,
+	binding.MIMEJSON:    decodeJSON,
+	binding.MIMEXML:     decodeXML,
+	binding.MIMEXML2:    decodeXML,
+	mimeMsgPack:         decodeMsgpack,
+	binding.MIMEMSGPACK: decodeMsgpack,
+}
+
+func decodeJSON(body []byte, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return jsonError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &bodyError{status: http.StatusBadRequest, detail: "request body must hold a single JSON value"}
+	}
+	return nil
+}
+
+// jsonError describes a JSON decoding error, naming the field at fault
+// where there is one.
+func jsonError(err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		return &bodyError{status: http.StatusBadRequest, detail: "malformed JSON at byte %d", args: []any{syntax.Offset}}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &bodyError{status: http.StatusBadRequest, detail: "malformed JSON: unexpected end of body"}
+	case errors.As(err, &typ):
+		return &bodyError{status: http.StatusBadRequest, detail: "invalid body",
+			fields: []fieldError{{Field: jsonFieldPath(typ.Field), Message: "must be " + jsonKind(typ.Type.Kind().String())}}}
+	}
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &bodyError{status: http.StatusBadRequest, detail: "invalid body",
+			fields: []fieldError{{Field: strings.Trim(name, `"`), Message: "is not a known field"}}}
+	}
+	return err
+}
+
+// jsonFieldPath writes a decoder field path like "1.price" the way
+// validation errors name fields, "[1].price".
+func jsonFieldPath(path string) string {
+	if path == "" {
+		return "body"
+	}
+	var b strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// jsonKind names a Go kind the way a JSON client would.
+func jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice", kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
+
+// decodeXML decodes one XML document. Anything but whitespace, comments
+// and processing instructions after its root element is refused.
+func decodeXML(body []byte, dst any) error {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.Comment, xml.ProcInst:
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return &bodyError{status: http.StatusBadRequest, detail: "request body must hold a single XML document"}
+			}
+		default:
+			return &bodyError{status: http.StatusBadRequest, detail: "request body must hold a single XML document"}
+		}
+	}
+}
+
+func decodeMsgpack(body []byte, dst any) error {
+	dec := codec.NewDecoderBytes(body, msgpackHandle)
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if dec.NumBytesRead() != len(body) {
+		return &bodyError{status: http.StatusBadRequest, detail: "request body must hold a single MessagePack value"}
+	}
+	return nil
+}
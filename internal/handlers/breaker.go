@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState is where a circuit breaker is in its cycle.
+type breakerState int
+
+const (
+	// breakerClosed lets every call through, counting failures.
+	breakerClosed breakerState = iota
+	// breakerHalfOpen lets a single trial call through.
+	breakerHalfOpen
+	// breakerOpen rejects calls until its delay has passed.
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// circuitBreaker stops calls to a failing dependency so it can recover
+// and callers fail fast. After threshold consecutive failures it opens
+// for delay, then lets one trial call through: success closes it, failure
+// opens it again.
+type circuitBreaker struct {
+	threshold int
+	delay     time.Duration
+	// onChange, if set, is called with the new state whenever it changes.
+	onChange func(breakerState)
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// trial is set while the half-open trial call is in flight.
+	trial bool
+}
+
+// newCircuitBreaker returns a closed breaker, calling onChange, if not
+// nil, with each new state.
+func newCircuitBreaker(threshold int, delay time.Duration, onChange func(breakerState)) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, delay: delay, onChange: onChange}
+}
+
+// allow lets a call go ahead by returning a function to report its
+// outcome with, or returns nil and how long until a trial call may be
+// made.
+func (b *circuitBreaker) allow() (report func(ok bool), wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := time.Until(b.openedAt.Add(b.delay)); wait > 0 {
+			return nil, wait
+		}
+		b.set(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.trial {
+			return nil, b.delay
+		}
+		b.trial = true
+		return b.trialDone, 0
+	}
+	return b.done, 0
+}
+
+// done records the outcome of a call made while closed.
+func (b *circuitBreaker) done(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= b.threshold && b.state == breakerClosed {
+		b.open()
+	}
+}
+
+// trialDone records the outcome of the half-open trial call.
+func (b *circuitBreaker) trialDone(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !ok {
+		b.open()
+		return
+	}
+	b.failures = 0
+	b.set(breakerClosed)
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt, b.failures = time.Now(), 0
+	b.set(breakerOpen)
+}
+
+func (b *circuitBreaker) set(s breakerState) {
+	if b.state == s {
+		return
+	}
+	b.state = s
+	if b.onChange != nil {
+		b.onChange(s)
+	}
+}
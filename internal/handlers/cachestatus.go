@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/cache"
+)
+
+// cacheHeader tells clients whether a cache answered the request.
+const cacheHeader = "X-Cache"
+
+// cacheStatus returns middleware that gives responses an X-Cache header:
+// HIT when every cache lookup made for the request, in the album cache or
+// of an idempotent response, was answered from the cache, and MISS when
+// any was not. Responses made without a lookup get none.
+func cacheStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, status := cache.WithStatus(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		w := &cacheStatusWriter{ResponseWriter: c.Writer, status: status}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+		// Responses without a body have their header written after this.
+		w.setHeader()
+	}
+}
+
+// cacheStatusWriter sets the X-Cache header just before the response
+// header is written, once the handler has made its lookups.
+type cacheStatusWriter struct {
+	gin.ResponseWriter
+	status *cache.Status
+	set    bool
+}
+
+// Unwrap lets http.ResponseController reach the connection, so streams
+// can lift the write deadline.
+func (w *cacheStatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cacheStatusWriter) setHeader() {
+	if w.set || w.Written() {
+		return
+	}
+	w.set = true
+	if v := w.status.String(); v != "" {
+		w.Header().Set(cacheHeader, v)
+	}
+}
+
+func (w *cacheStatusWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheStatusWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheStatusWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *cacheStatusWriter) Flush() {
+	w.setHeader()
+	w.ResponseWriter.Flush()
+}
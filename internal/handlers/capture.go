@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureHeader must be present on a request for its bodies to be captured.
+const captureHeader = "X-Debug-Capture"
+
+// redactedHeaders are never written to the capture sink.
+var redactedHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// secretField matches JSON string members whose values must not be
+// captured, including a value cut short by truncation.
+var secretField = regexp.MustCompile(`(?i)("(?:password|secret|token|api_key)"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|\\?$)`)
+
+// cappedBuffer keeps the first limit bytes written to it.
+type cappedBuffer struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); len(p) > room {
+		b.buf = append(b.buf, p[:room]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// redacted returns the captured bytes with secret JSON values masked.
+func (b *cappedBuffer) redacted() string {
+	return secretField.ReplaceAllString(string(b.buf), `$1"[REDACTED]"`)
+}
+
+// captureWriter copies the response body into a cappedBuffer.
+type captureWriter struct {
+	gin.ResponseWriter
+	body *cappedBuffer
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// bodyCapture is one captured exchange as written to the sink.
+type bodyCapture struct {
+	RequestID         string      `json:"request_id,omitempty"`
+	Method            string      `json:"method"`
+	Path              string      `json:"path"`
+	Status            int         `json:"status"`
+	Header            http.Header `json:"header"`
+	Request           string      `json:"request"`
+	RequestTruncated  bool        `json:"request_truncated"`
+	Response          string      `json:"response"`
+	ResponseTruncated bool        `json:"response_truncated"`
+}
+
+// captureBodies returns middleware that writes the request and response
+// bodies of requests sent with X-Debug-Capture to sink, keeping at most
+// limit bytes of each. Capture is refused in release mode so it cannot leak
+// production data.
+func captureBodies(sink io.Writer, limit int) gin.HandlerFunc {
+	var mu sync.Mutex
+	enc := json.NewEncoder(sink)
+
+	return func(c *gin.Context) {
+		if gin.Mode() == gin.ReleaseMode || c.GetHeader(captureHeader) == "" {
+			c.Next()
+			return
+		}
+
+		req := &cappedBuffer{limit: limit}
+		c.Request.Body = readCloser{Reader: io.TeeReader(c.Request.Body, req), Closer: c.Request.Body}
+		resp := &captureWriter{ResponseWriter: c.Writer, body: &cappedBuffer{limit: limit}}
+		c.Writer = resp
+		c.Next()
+
+		header := c.Request.Header.Clone()
+		for _, h := range redactedHeaders {
+			if header.Get(h) != "" {
+				header.Set(h, "[REDACTED]")
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(bodyCapture{
+			RequestID:         requestIDFrom(c.Request.Context()),
+			Method:            c.Request.Method,
+			Path:              c.Request.URL.Path,
+			Status:            c.Writer.Status(),
+			Header:            header,
+			Request:           req.redacted(),
+			RequestTruncated:  req.truncated,
+			Response:          resp.body.redacted(),
+			ResponseTruncated: resp.body.truncated,
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureRouter returns a router capturing to sink at most limit bytes of
+// each body, whose /echo route answers with the request body.
+func captureRouter(sink io.Writer, limit int) *gin.Engine {
+	router := gin.New()
+	router.Use(captureBodies(sink, limit))
+	router.POST("/echo", func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/json", b)
+	})
+	return router
+}
+
+// postEcho sends body to /echo through router with headers given as name,
+// value pairs.
+func postEcho(router *gin.Engine, body string, headers ...string) {
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestCaptureIsTruncatedAndRedacted(t *testing.T) {
+	var sink bytes.Buffer
+	router := captureRouter(&sink, 40)
+	body := `{"user": "admin", "password": "hunter2", "note": "` + strings.Repeat("x", 100) + `"}`
+	postEcho(router, body, captureHeader, "1", "Authorization", "Bearer abc")
+
+	var got bodyCapture
+	if err := json.Unmarshal(sink.Bytes(), &got); err != nil {
+		t.Fatalf("capture %q: %v", sink.String(), err)
+	}
+	if got.Method != http.MethodPost || got.Path != "/echo" || got.Status != http.StatusOK {
+		t.Errorf("capture %+v, want POST /echo 200", got)
+	}
+	if !got.RequestTruncated || !got.ResponseTruncated {
+		t.Errorf("bodies over the cap not marked truncated: %+v", got)
+	}
+	for name, captured := range map[string]string{"request": got.Request, "response": got.Response} {
+		if strings.Contains(captured, "hunter2") || !strings.Contains(captured, `"password": "[REDACTED]"`) {
+			t.Errorf("%s body %q, want the password redacted", name, captured)
+		}
+		if !strings.HasPrefix(body, strings.Replace(captured, `"[REDACTED]"`, `"hunter2"`, 1)) {
+			t.Errorf("%s body %q, want the start of the body", name, captured)
+		}
+	}
+	if h := got.Header.Get("Authorization"); h != "[REDACTED]" {
+		t.Errorf("Authorization %q captured, want it redacted", h)
+	}
+}
+
+func TestCaptureOnlyWhenAskedOutsideReleaseMode(t *testing.T) {
+	var sink bytes.Buffer
+	router := captureRouter(&sink, 4096)
+	postEcho(router, `{}`)
+	if sink.Len() != 0 {
+		t.Errorf("captured without %s: %s", captureHeader, sink.String())
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.TestMode)
+	postEcho(router, `{}`, captureHeader, "1")
+	if sink.Len() != 0 {
+		t.Errorf("captured in release mode: %s", sink.String())
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressWriter holds back the start of a response until it reaches
+// minSize bytes, then compresses the rest of it if its content type is
+// worth compressing. Shorter responses are sent as they are.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been written so far. Responses flushed before they
+// reach minSize are streams and are not compressed.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide starts compressing when large is set and the response allows it,
+// then writes out the held-back bytes.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	// A Content-Range counts bytes of the uncompressed body, so ranges are
+	// sent as they are.
+	if large && compressible(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		w.Status() != http.StatusNoContent && w.Status() != http.StatusNotModified && w.Status() != http.StatusPartialContent {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", encodedETag(etag, w.encoding))
+		}
+		if w.encoding == "gzip" {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			w.enc = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// finish writes out anything still held back and ends the compressed
+// stream.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+// compressible reports whether responses of contentType shrink enough to
+// be worth compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/json" ||
+		strings.HasSuffix(mt, "+json") || mt == "application/x-ndjson" || mt == "application/javascript"
+}
+
+// negotiateEncoding returns the content coding to compress with for an
+// Accept-Encoding header: gzip, deflate, or "" for none. A coding is
+// acceptable when listed, or covered by "*", with a q above 0; gzip wins
+// ties.
+func negotiateEncoding(accept string) string {
+	// The q of each listed coding; "*" covers those not listed.
+	listed := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "deflate" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		listed[coding] = q
+	}
+	var best string
+	var bestQ float64
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := listed[coding]
+		if !ok {
+			q = listed["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compress returns middleware that gzip- or deflate-encodes text and JSON
+// responses of at least minSize bytes for clients that accept it.
+func compress(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minSize: minSize}
+		c.Writer = w
+		completed := false
+		defer func() {
+			c.Writer = w.ResponseWriter
+			// After a panic, what is held back is dropped so recovery's
+			// problem goes out in its place; a response already being
+			// sent is only ended.
+			if completed || w.decided {
+				w.finish()
+			}
+		}()
+		c.Next()
+		completed = true
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, c := range []struct {
+		accept, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br, *", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=0, deflate", "deflate"},
+		{"*;q=0", ""},
+		{"*;q=0, deflate;q=0.1", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"deflate;q=0, gzip;q=0", ""},
+		{"identity;q=0", ""},
+		{"gzip;q=x", ""},
+	} {
+		if got := negotiateEncoding(c.accept); got != c.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestCompressHonoursRefusedCodings(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"COMPRESS_MIN_SIZE": "1"})
+
+	w := ts.do(http.MethodGet, "/v1/albums", "", "Accept-Encoding", "gzip")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding %q for gzip, want gzip", got)
+	}
+
+	w = ts.do(http.MethodGet, "/v1/albums", "", "Accept-Encoding", "gzip;q=0")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q for gzip;q=0, want none", got)
+	}
+}
+
+func TestCompressedRepresentationsHaveTheirOwnETag(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"COMPRESS_MIN_SIZE": "1"})
+
+	plain := ts.do(http.MethodGet, "/v1/albums/1", "")
+	wantStatus(t, plain, http.StatusOK)
+	gzipped := ts.do(http.MethodGet, "/v1/albums/1", "", "Accept-Encoding", "gzip")
+	wantStatus(t, gzipped, http.StatusOK)
+	etag, gzipETag := plain.Header().Get("ETag"), gzipped.Header().Get("ETag")
+	if etag == "" || gzipETag == etag {
+		t.Fatalf("ETag %q without compression and %q with gzip, want them to differ", etag, gzipETag)
+	}
+
+	w := ts.do(http.MethodGet, "/v1/albums/1", "", "Accept-Encoding", "gzip", "If-None-Match", gzipETag)
+	wantStatus(t, w, http.StatusNotModified)
+	if got := w.Header().Get("ETag"); got != gzipETag {
+		t.Errorf("304 ETag %q, want %q", got, gzipETag)
+	}
+
+	body := `{"title": "Blue Train", "artist": "John Coltrane", "price": 9.99}`
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", body, "If-Match", gzipETag), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", body, "If-Match", gzipETag), http.StatusPreconditionFailed)
+}
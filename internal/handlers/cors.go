@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsConfig says which cross-origin browser requests are allowed.
+type corsConfig struct {
+	origins []string
+	methods []string
+	headers []string
+	expose  []string
+	maxAge  time.Duration
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed.
+func (cfg corsConfig) allowOrigin(origin string) string {
+	for _, o := range cfg.origins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// cors returns middleware that adds CORS headers for allowed origins and
+// answers preflight requests itself.
+func cors(cfg corsConfig) gin.HandlerFunc {
+	methods := strings.Join(cfg.methods, ", ")
+	headers := strings.Join(cfg.headers, ", ")
+	expose := strings.Join(cfg.expose, ", ")
+	maxAge := strconv.Itoa(int(cfg.maxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		allowed := cfg.allowOrigin(origin)
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if allowed == "" {
+			if preflight {
+				writeProblem(c, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			c.Next()
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allowed)
+
+		if !preflight {
+			if expose != "" {
+				h.Set("Access-Control-Expose-Headers", expose)
+			}
+			c.Next()
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", headers)
+		h.Set("Access-Control-Max-Age", maxAge)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
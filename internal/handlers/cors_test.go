@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS": "https://app.example",
+		"CORS_ALLOWED_METHODS": "GET,PUT",
+		"CORS_MAX_AGE":         "1h",
+	})
+	w := ts.do(http.MethodOptions, "/v1/albums/1", "", "Origin", "https://app.example", "Access-Control-Request-Method", "PUT")
+	wantStatus(t, w, http.StatusNoContent)
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example",
+		"Access-Control-Allow-Methods": "GET, PUT",
+		"Access-Control-Max-Age":       "3600",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("preflight %s %q, want %q", name, got, want)
+		}
+	}
+
+	w = ts.do(http.MethodOptions, "/v1/albums/1", "", "Origin", "https://evil.example", "Access-Control-Request-Method", "PUT")
+	wantStatus(t, w, http.StatusForbidden)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("refused preflight allows origin %q", got)
+	}
+}
+
+func TestCORSSimpleRequests(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example"})
+	w := ts.do(http.MethodGet, "/v1/albums/1", "", "Origin", "https://APP.example")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://APP.example" {
+		t.Errorf("Access-Control-Allow-Origin %q, want the origin", got)
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Error("no Access-Control-Expose-Headers on an allowed request")
+	}
+
+	// Other origins still get the response, which browsers then withhold.
+	w = ts.do(http.MethodGet, "/v1/albums/1", "", "Origin", "https://evil.example")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin %q for an origin not allowed", got)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseDeprecatedRoutes parses a comma-separated list of
+// "METHOD /path=YYYY-MM-DD" entries into sunset dates keyed by
+// "METHOD /path". Paths use the route pattern, e.g. /albums/:id.
+func parseDeprecatedRoutes(s string) (map[string]time.Time, error) {
+	routes := make(map[string]time.Time)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, date, ok := strings.Cut(entry, "=")
+		method, path, ok2 := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("DEPRECATED_ROUTES entry %q must look like \"GET /albums=2025-12-31\"", entry)
+		}
+		sunset, err := time.Parse(time.DateOnly, strings.TrimSpace(date))
+		if err != nil {
+			return nil, fmt.Errorf("DEPRECATED_ROUTES entry %q: %w", entry, err)
+		}
+		routes[strings.ToUpper(method)+" "+strings.TrimSpace(path)] = sunset
+	}
+	return routes, nil
+}
+
+// deprecated returns middleware that marks a response as coming from a
+// deprecated endpoint with Deprecation and Sunset (RFC 8594) headers. A
+// zero sunset omits the Sunset header.
+func deprecated(sunset time.Time) gin.HandlerFunc {
+	var value string
+	if !sunset.IsZero() {
+		value = sunset.UTC().Format(http.TimeFormat)
+	}
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if value != "" {
+			c.Header("Sunset", value)
+		}
+		addLogField(c.Request.Context(), "deprecated", true)
+		slog.WarnContext(c.Request.Context(), "deprecated endpoint called",
+			"method", c.Request.Method, "route", c.FullPath(), "sunset", value)
+		c.Next()
+	}
+}
+
+// deprecatedRoutes returns middleware that applies deprecated to the routes
+// listed in routes.
+func deprecatedRoutes(routes map[string]time.Time) gin.HandlerFunc {
+	handlers := make(map[string]gin.HandlerFunc, len(routes))
+	for route, sunset := range routes {
+		handlers[route] = deprecated(sunset)
+	}
+	return func(c *gin.Context) {
+		if h, ok := handlers[c.Request.Method+" "+c.FullPath()]; ok {
+			h(c)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"DEPRECATED_ROUTES": "GET /v1/albums/:id=2027-01-31"})
+
+	w := ts.do(http.MethodGet, "/v1/albums/1", "")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation %q on a deprecated route, want true", got)
+	}
+	if got, want := w.Header().Get("Sunset"), "Sun, 31 Jan 2027 00:00:00 GMT"; got != want {
+		t.Errorf("Sunset %q, want %q", got, want)
+	}
+
+	// Other methods and routes are not deprecated.
+	for _, w := range []*httptest.ResponseRecorder{
+		ts.do(http.MethodGet, "/v1/albums", ""),
+		ts.do(http.MethodDelete, "/v1/albums/1", ""),
+	} {
+		if got := w.Header().Get("Deprecation"); got != "" {
+			t.Errorf("Deprecation %q on a route not listed", got)
+		}
+	}
+}
+
+func TestUnversionedRoutesAreDeprecated(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"UNVERSIONED_SUNSET": "2027-06-30"})
+	w := ts.do(http.MethodGet, "/albums/1", "")
+	wantStatus(t, w, http.StatusOK)
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("unversioned headers %v, want Deprecation and Sunset", w.Header())
+	}
+	if got, want := w.Header().Get("Link"), `</v1/albums/1>; rel="successor-version"`; got != want {
+		t.Errorf("Link %q, want %q", got, want)
+	}
+}
+
+func TestParseDeprecatedRoutesRejectsBadEntries(t *testing.T) {
+	for _, spec := range []string{"GET /albums", "/albums=2027-01-31", "GET /albums=31/01/2027"} {
+		if _, err := parseDeprecatedRoutes(spec); err == nil {
+			t.Errorf("parseDeprecatedRoutes(%q) succeeded, want an error", spec)
+		}
+	}
+}
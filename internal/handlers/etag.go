@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etagFor returns a strong entity tag for a representation.
+func etagFor(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// albumETag returns the entity tag of a, which names its version, so it
+// changes whenever any of its fields do.
+func albumETag(a album) string {
+	return `"v` + strconv.Itoa(a.Version) + `"`
+}
+
+// encodedETag returns the entity tag of the representation etag names
+// once compressed with encoding. A strong tag must differ between
+// encodings, so it gets the encoding as a suffix, as in "v3-gzip"; weak
+// tags may be shared and stay as they are.
+func encodedETag(etag, encoding string) string {
+	if strings.HasPrefix(etag, "W/") || len(etag) < 2 || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// decodedETag strips the suffix encodedETag adds, so conditions sent with
+// the tag of a compressed representation are checked against the
+// resource's.
+func decodedETag(tag string) string {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if t, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok {
+			return t + `"`
+		}
+	}
+	return tag
+}
+
+// requestVersion returns the album version a replacement is based on:
+// the one its If-Match header names, or else the body's version, and
+// whether it came from If-Match. If-Match: * leaves the version to the
+// body. A request naming no version gets 428, and one whose If-Match is
+// not a single album ETag gets 412; both return false.
+func requestVersion(c *gin.Context, body int) (int, bool, bool) {
+	im := strings.TrimSpace(c.GetHeader("If-Match"))
+	if im == "" || im == "*" {
+		if body == 0 && im == "" {
+			writeProblem(c, http.StatusPreconditionRequired, "send the album's ETag in If-Match or its version in the body")
+			return 0, false, false
+		}
+		return body, false, true
+	}
+	im = decodedETag(im)
+	v, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(im, `"v`), `"`))
+	if err != nil || v < 1 || albumETag(album{Version: v}) != im {
+		writeProblem(c, http.StatusPreconditionFailed, "If-Match must be one ETag of the album")
+		return 0, false, false
+	}
+	if body != 0 && body != v {
+		writeProblem(c, http.StatusBadRequest, "album version does not match If-Match")
+		return 0, false, false
+	}
+	return v, true, true
+}
+
+// etagMatches reports whether the If-Match or If-None-Match header value
+// lists etag, or the tag of a compressed representation of it. Weak tags
+// match only when weak is set, as If-None-Match allows.
+func etagMatches(header, etag string, weak bool) bool {
+	_, ok := matchingETag(header, etag, weak)
+	return ok
+}
+
+// matchingETag is etagMatches, also returning the tag in header that
+// matched, as the client sent it.
+func matchingETag(header, etag string, weak bool) (string, bool) {
+	if strings.TrimSpace(header) == "*" {
+		return etag, true
+	}
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, sent := range strings.Split(header, ",") {
+		sent = strings.TrimSpace(sent)
+		tag := decodedETag(sent)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return sent, true
+		}
+	}
+	return "", false
+}
+
+// skipETagKey marks a request whose handler answers conditional requests
+// itself.
+const skipETagKey = "skipETag"
+
+// skipETag tells etags that the handler sets its own ETag and answers
+// conditional requests, so its response is passed through unbuffered.
+func skipETag(c *gin.Context) {
+	c.Set(skipETagKey, true)
+}
+
+// etagWriter buffers a response so it can be tagged, until the handler
+// flushes it or opts out with skipETag. Such responses are passed through.
+type etagWriter struct {
+	bufferedWriter
+	c         *gin.Context
+	streaming bool
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.streaming && w.body.Len() == 0 && w.c.GetBool(skipETagKey) {
+		w.streaming = true
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// etags returns middleware that tags successful GET responses with an ETag,
+// hashing the body unless the handler set one, and answers 304 Not
+// Modified when If-None-Match already lists it. Streamed responses are
+// not tagged.
+func etags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+		w := &etagWriter{bufferedWriter: bufferedWriter{ResponseWriter: c.Writer}, c: c}
+		c.Writer = w
+		// Restored even if the handler panics, so recovery's problem is
+		// written to the client rather than the buffer.
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.streaming || c.GetBool(skipETagKey) {
+			c.Writer.Write(w.body.Bytes())
+			return
+		}
+
+		if c.Writer.Status() == http.StatusOK {
+			etag := c.Writer.Header().Get("ETag")
+			if etag == "" {
+				etag = etagFor(w.body.Bytes())
+				c.Header("ETag", etag)
+			}
+			if sent, ok := matchingETag(c.GetHeader("If-None-Match"), etag, true); ok {
+				// The client may hold a compressed representation, whose
+				// tag the 304 must carry; compress leaves 304s alone.
+				c.Header("ETag", sent)
+				c.Writer.Header().Del("Content-Type")
+				c.Writer.Header().Del("Content-Length")
+				c.Status(http.StatusNotModified)
+				c.Writer.WriteHeaderNow()
+				return
+			}
+		}
+		c.Writer.Write(w.body.Bytes())
+	}
+}
+
+// checkIfMatch enforces the request's If-Match header against the current
+// version of album id, responding 412 Precondition Failed or with the store
+// error and returning false when the request must not go ahead.
+func (h *albumHandler) checkIfMatch(c *gin.Context, id string) bool {
+	im := c.GetHeader("If-Match")
+	if im == "" {
+		return true
+	}
+	current, err := h.albums.Get(c.Request.Context(), id)
+	if err != nil {
+		respondStoreError(c, err)
+		return false
+	}
+	if !etagMatches(im, albumETag(current), false) {
+		writeProblem(c, http.StatusPreconditionFailed, "album has changed since it was fetched")
+		return false
+	}
+	return true
+}
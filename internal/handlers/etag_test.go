@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReplacementsNameTheVersionTheyReplace(t *testing.T) {
+	ts := newTestServer(t, nil)
+	body := `{"title": "Blue Train", "artist": "John Coltrane", "price": 19.99}`
+
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", body), http.StatusPreconditionRequired)
+
+	w := ts.do(http.MethodPut, "/v1/albums/1", body, "If-Match", `"v1"`)
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"v2"` {
+		t.Errorf("ETag %q after the first change, want \"v2\"", got)
+	}
+	var got album
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if got.Version != 2 || got.Price != 19.99 {
+		t.Errorf("replaced album %+v, want the new price at version 2", got)
+	}
+
+	// A writer still holding version 1 is refused and changes nothing.
+	stale := `{"title": "Blue Train", "artist": "John Coltrane", "price": 1}`
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", stale, "If-Match", `"v1"`), http.StatusPreconditionFailed)
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 1, "version": 1}`), http.StatusConflict)
+	json.Unmarshal(ts.do(http.MethodGet, "/v1/albums/1", "").Body.Bytes(), &got)
+	if got.Version != 2 || got.Price != 19.99 {
+		t.Errorf("album %+v after stale writes, want version 2 unchanged", got)
+	}
+
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 2, "version": 2}`), http.StatusOK)
+}
+
+func TestDeleteHonoursIfMatch(t *testing.T) {
+	ts := newTestServer(t, nil)
+	wantStatus(t, ts.do(http.MethodDelete, "/v1/albums/2", "", "If-Match", `"v7"`), http.StatusPreconditionFailed)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums/2", ""), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodDelete, "/v1/albums/2", "", "If-Match", `"v1"`), http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/service"
+	"pspFileAPI/internal/tenant"
+)
+
+// heartbeatInterval is how often idle event streams get a comment so
+// proxies keep them open.
+const heartbeatInterval = 15 * time.Second
+
+// writeEvent writes e in the text/event-stream format. Deletions carry
+// only the album's ID.
+func writeEvent(w gin.ResponseWriter, e service.Event) error {
+	var payload any = e.Album
+	if e.Type == service.EventAlbumDeleted {
+		payload = gin.H{"id": e.Album.ID}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// getEvents streams the tenant's album changes as server-sent events,
+// starting after the Last-Event-ID a reconnecting client sends.
+func (h *albumHandler) getEvents(c *gin.Context) {
+	var lastID uint64
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, "Last-Event-ID must be an event id")
+			return
+		}
+		lastID = id
+	}
+
+	// The stream outlives WRITE_TIMEOUT. If a writer in the chain hides
+	// the connection, the stream would be cut off then, so it fails now.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		slog.ErrorContext(c.Request.Context(), "event stream cannot lift the write deadline", "err", err)
+		writeProblem(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	replay, ch := h.events.Subscribe(tenant.From(c.Request.Context()), lastID)
+	defer h.events.Unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, e := range replay {
+		if writeEvent(c.Writer, e) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if writeEvent(c.Writer, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		case <-h.events.Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportWriteTimeout is how long a client may take to read each batch of
+// an export before it is dropped. The export as a whole may take longer
+// than WRITE_TIMEOUT.
+const exportWriteTimeout = time.Minute
+
+// exportTypes are the media types of the export formats.
+var exportTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportColumns heads the columns of CSV exports.
+var exportColumns = []string{"id", "title", "artist", "price", "version", "deleted_at"}
+
+// albumEncoder writes albums in an export format.
+type albumEncoder interface {
+	encode(a album) error
+	// flush writes out anything the encoder buffers.
+	flush() error
+}
+
+// csvEncoder writes albums as CSV rows under a header row.
+type csvEncoder struct{ w *csv.Writer }
+
+// newCSVEncoder returns a csvEncoder writing to w, with the header row
+// buffered to go out with the first batch.
+func newCSVEncoder(w io.Writer) csvEncoder {
+	e := csvEncoder{csv.NewWriter(w)}
+	e.w.Write(exportColumns)
+	return e
+}
+
+func (e csvEncoder) encode(a album) error {
+	deletedAt := ""
+	if a.DeletedAt != nil {
+		deletedAt = a.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return e.w.Write([]string{csvText(a.ID), csvText(a.Title), csvText(a.Artist), strconv.FormatFloat(a.Price, 'f', -1, 64), strconv.Itoa(a.Version), deletedAt})
+}
+
+// csvText returns v as a CSV cell that spreadsheets show as text: a value
+// they would run as a formula, starting with =, +, -, @, a tab or a
+// carriage return, gets a leading '.
+func csvText(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func (e csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonEncoder writes albums as one JSON object per line, as
+// /albums/stream does.
+type ndjsonEncoder struct{ enc *json.Encoder }
+
+func (e ndjsonEncoder) encode(a album) error { return e.enc.Encode(a) }
+
+func (ndjsonEncoder) flush() error { return nil }
+
+// getAlbumExport sends every album matching the query's filters, in the
+// query's order, as a file download: CSV by default, or newline-delimited
+// JSON with format=ndjson, gzipped into a .gz file with gzip=true. Albums
+// are read, written and flushed a batch at a time, so a slow client holds
+// up the reads instead of the server buffering the export.
+func (h *albumHandler) getAlbumExport(c *gin.Context) {
+	v := c.Request.URL.Query()
+	q, _, errs := parseListQuery(v)
+	format := v.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if _, ok := exportTypes[format]; !ok {
+		errs = append(errs, fieldError{Field: "format", Message: "must be csv or ndjson"})
+	}
+	var zip bool
+	if s := v.Get("gzip"); s != "" {
+		var err error
+		if zip, err = strconv.ParseBool(s); err != nil {
+			errs = append(errs, fieldError{Field: "gzip", Message: "must be true or false"})
+		}
+	}
+	if len(errs) > 0 {
+		writeProblem(c, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+
+	// Read the first batch before sending headers, so a store failure can
+	// still be answered with a problem instead of a broken download.
+	ctx := c.Request.Context()
+	q.Offset, q.Limit = 0, streamBatchSize
+	page, _, err := h.albums.List(ctx, q)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	// Each batch gets its own write deadline. If a writer in the chain
+	// hides the connection, the export would be cut off at WRITE_TIMEOUT,
+	// so it fails before anything is sent.
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		slog.ErrorContext(ctx, "album export cannot set the write deadline", "err", err)
+		writeProblem(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	name := "albums-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	contentType := exportTypes[format]
+	var w io.Writer = c.Writer
+	var zw *gzip.Writer
+	if zip {
+		// A gzip file rather than a Content-Encoding, so the download is
+		// saved compressed. The compress middleware leaves it alone.
+		name += ".gz"
+		contentType = "application/gzip"
+		zw = gzip.NewWriter(c.Writer)
+		defer zw.Close()
+		w = zw
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	var enc albumEncoder = ndjsonEncoder{json.NewEncoder(w)}
+	if format == "csv" {
+		enc = newCSVEncoder(w)
+	}
+	for {
+		for _, a := range page {
+			if err := enc.encode(a); err != nil {
+				return
+			}
+		}
+		if err := enc.flush(); err != nil {
+			return
+		}
+		if zw != nil {
+			if err := zw.Flush(); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		if len(page) < q.Limit || ctx.Err() != nil {
+			return
+		}
+		q.Offset += len(page)
+		if stopRequested(ctx) {
+			// End after a whole batch, with the gzip stream closed, so the
+			// file is short but not corrupt.
+			slog.WarnContext(ctx, "album export ended by shutdown", "sent", q.Offset)
+			return
+		}
+		if page, _, err = h.albums.List(ctx, q); err != nil {
+			// The status is already sent, so the download just ends early.
+			slog.ErrorContext(ctx, "album export failed", "err", err, "sent", q.Offset)
+			return
+		}
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+			slog.ErrorContext(ctx, "album export cannot set the write deadline", "err", err, "sent", q.Offset)
+			return
+		}
+	}
+}
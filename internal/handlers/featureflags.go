@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/flags"
+)
+
+// flagSubject names the caller for percentage rollouts: the user or API
+// key that authenticated, else nobody, so the tenant decides.
+func flagSubject(ctx context.Context) string {
+	if id := identityFrom(ctx); id != nil {
+		return id.subject
+	}
+	return ""
+}
+
+// withFlags returns middleware that lets handlers, and the services they
+// call, check flags.Enabled against set.
+func withFlags(set *flags.Set) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(flags.NewContext(c.Request.Context(), set))
+		c.Next()
+	}
+}
+
+// flagAdmin serves /admin/flags, flipping flags until the next reload
+// that changes FEATURE_FLAGS.
+type flagAdmin struct {
+	set *flags.Set
+}
+
+// getFlags responds with every flag.
+func (a *flagAdmin) getFlags(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, a.set.List())
+}
+
+// putFlag sets the flag named by the name parameter to the rules in the
+// request body.
+func (a *flagAdmin) putFlag(c *gin.Context) {
+	var req struct {
+		On      bool     `json:"on"`
+		Tenants []string `json:"tenants"`
+		Percent int      `json:"percent"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	f := flags.Flag{Name: c.Param("name"), On: req.On, Tenants: req.Tenants, Percent: req.Percent}
+	if err := f.Validate(); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.set.Put(f)
+	slog.WarnContext(c.Request.Context(), "feature flag changed", "flag", f.Name, "on", f.On, "tenants", f.Tenants, "percent", f.Percent)
+	c.IndentedJSON(http.StatusOK, f)
+}
+
+// deleteFlag removes the flag named by the name parameter, turning it off
+// for everyone.
+func (a *flagAdmin) deleteFlag(c *gin.Context) {
+	name := c.Param("name")
+	if !a.set.Delete(name) {
+		writeProblem(c, http.StatusNotFound, "flag not found")
+		return
+	}
+	slog.WarnContext(c.Request.Context(), "feature flag removed", "flag", name)
+	c.Status(http.StatusNoContent)
+}
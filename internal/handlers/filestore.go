@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/tenant"
+)
+
+// errFileNotFound is returned by every fileStore for unknown IDs.
+var errFileNotFound = errors.New("file not found")
+
+// fileInfo describes a stored file.
+type fileInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Tenant uploaded the file; only it can download it. Files from before
+	// tenancy have none and belong to tenant.Default.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// fileStore keeps uploaded files. Implementations must be safe for
+// concurrent use.
+type fileStore interface {
+	// save stores the contents of r under a new ID, filling in the ID,
+	// size, hash, creation time and ctx's tenant in info.
+	save(ctx context.Context, info fileInfo, r io.Reader) (fileInfo, error)
+	// open returns ctx's tenant's file with the given id for reading.
+	open(ctx context.Context, id string) (fileInfo, io.ReadSeekCloser, error)
+}
+
+// openFileStore returns the fileStore FILE_STORE selects. Only "disk" is
+// available; it keeps files in UPLOAD_DIR.
+func openFileStore(cfg config.Config) (fileStore, error) {
+	switch backend := cfg.FileStore; backend {
+	case "disk":
+		return newDiskFileStore(cfg.UploadDir)
+	default:
+		return nil, fmt.Errorf("unknown FILE_STORE %q", backend)
+	}
+}
+
+// diskFileStore stores each file in a directory next to a JSON file with
+// its fileInfo.
+type diskFileStore struct {
+	dir string
+}
+
+// newDiskFileStore returns a store in dir, creating it if needed.
+func newDiskFileStore(dir string) (*diskFileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &diskFileStore{dir: dir}, nil
+}
+
+func (s *diskFileStore) save(ctx context.Context, info fileInfo, r io.Reader) (fileInfo, error) {
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return fileInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return fileInfo{}, err
+	}
+
+	info.ID = uuid.NewString()
+	info.Size = n
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	info.CreatedAt = time.Now().UTC()
+	info.Tenant = tenant.From(ctx)
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	// Write the metadata last so a file is only found once it is complete.
+	if err := os.Rename(tmp.Name(), s.path(info.ID)); err != nil {
+		return fileInfo{}, err
+	}
+	if err := os.WriteFile(s.path(info.ID)+".json", meta, 0o640); err != nil {
+		os.Remove(s.path(info.ID))
+		return fileInfo{}, err
+	}
+	return info, nil
+}
+
+func (s *diskFileStore) open(ctx context.Context, id string) (fileInfo, io.ReadSeekCloser, error) {
+	// Only IDs this store issued can name a file, which keeps id from
+	// escaping dir.
+	if uuid.Validate(id) != nil {
+		return fileInfo{}, nil, errFileNotFound
+	}
+	meta, err := os.ReadFile(s.path(id) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return fileInfo{}, nil, errFileNotFound
+	} else if err != nil {
+		return fileInfo{}, nil, err
+	}
+	var info fileInfo
+	if err := json.Unmarshal(meta, &info); err != nil {
+		return fileInfo{}, nil, err
+	}
+	if info.Tenant == "" {
+		info.Tenant = tenant.Default
+	}
+	if info.Tenant != tenant.From(ctx) {
+		return fileInfo{}, nil, errFileNotFound
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return fileInfo{}, nil, err
+	}
+	return info, f, nil
+}
+
+// path returns where the file with the given id is kept.
+func (s *diskFileStore) path(id string) string {
+	return filepath.Join(s.dir, id)
+}
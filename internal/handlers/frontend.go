@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frontend is the demo page served at / and its assets.
+//
+//go:embed web
+var frontend embed.FS
+
+// frontendAssets is web/assets, served under /assets.
+var frontendAssets = func() fs.FS {
+	sub, err := fs.Sub(frontend, "web/assets")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}()
+
+// getIndex responds with the demo page. It is always revalidated so a new
+// build is picked up at once.
+func getIndex(c *gin.Context) {
+	page, err := frontend.ReadFile("web/index.html")
+	if err != nil {
+		writeProblem(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
+
+// getAsset responds with a file from web/assets. Asset names do not change
+// between builds, so they are cached briefly and then revalidated by ETag.
+func getAsset(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if info, err := fs.Stat(frontendAssets, name); err != nil || info.IsDir() {
+		writeProblem(c, http.StatusNotFound, tr(c, "no asset %s", name))
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.FileFromFS(name, http.FS(frontendAssets))
+}
+
+// wantsPage reports whether a request that matched no route is a browser
+// navigating within the page, which gets the page rather than a 404 so
+// client-side routes survive a reload.
+func wantsPage(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet &&
+		strings.Contains(c.GetHeader("Accept"), "text/html") &&
+		!strings.HasPrefix(c.Request.URL.Path, "/v1/") &&
+		!strings.HasPrefix(c.Request.URL.Path, "/assets/")
+}
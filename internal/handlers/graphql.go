@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+// graphqlSchema describes the albums offered at /graphql.
+//
+//go:embed graphql/schema.graphql
+var graphqlSchema string
+
+// graphiqlPage is the GraphiQL playground for /graphql.
+//
+//go:embed graphql/graphiql.html
+var graphiqlPage []byte
+
+// maxGraphQLDepth bounds how deeply a query may nest selections.
+const maxGraphQLDepth = 10
+
+// graphqlHandler serves GraphQL queries and mutations over the album
+// repository.
+type graphqlHandler struct {
+	schema *graphql.Schema
+	albums *service.Albums
+	// auths identify the caller; mutations need one of them to succeed.
+	// With none, mutations are open as over REST.
+	auths     []authenticator
+	sanitizer textSanitizer
+}
+
+// graphqlRequest is the body of a GraphQL request.
+type graphqlRequest struct {
+	Query         string         `json:"query" binding:"required"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	// Extensions is accepted for clients that always send it, and ignored.
+	Extensions map[string]any `json:"extensions"`
+}
+
+// newGraphQLHandler parses the schema and binds it to resolvers working on
+// albums.
+func newGraphQLHandler(albums *service.Albums, auths []authenticator, sanitizer textSanitizer) *graphqlHandler {
+	h := &graphqlHandler{albums: albums, auths: auths, sanitizer: sanitizer}
+	h.schema = graphql.MustParseSchema(graphqlSchema, &graphqlResolver{h}, graphql.MaxDepth(maxGraphQLDepth))
+	return h
+}
+
+// postGraphQL runs a query or mutation. Credentials, when sent, are checked
+// up front so a bad token is refused like over REST; whether the operation
+// needs them is up to its resolvers.
+func (h *graphqlHandler) postGraphQL(c *gin.Context) {
+	var req graphqlRequest
+	if !bindBody(c, &req) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(h.auths) > 0 {
+		id, err := authenticateAny(c.Request, h.auths)
+		switch {
+		case err == nil:
+			ctx = context.WithValue(ctx, identityKey{}, id)
+			addLogField(ctx, "user", id.subject)
+		case !errors.Is(err, errNoCredentials):
+			rejectAuth(c, h.auths, err)
+			return
+		}
+	}
+	addLogField(ctx, "graphql_operation", req.OperationName)
+
+	c.JSON(http.StatusOK, h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// getGraphiQL responds with the GraphiQL playground.
+func getGraphiQL(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", graphiqlPage)
+}
+
+// graphqlError is a resolver error. Its code and field errors are reported
+// in the error's extensions.
+type graphqlError struct {
+	message string
+	code    string
+	fields  []fieldError
+}
+
+func (e *graphqlError) Error() string { return e.message }
+
+// Extensions implements graphql-go's extension hook.
+func (e *graphqlError) Extensions() map[string]any {
+	ext := map[string]any{"code": e.code}
+	if len(e.fields) > 0 {
+		ext["errors"] = e.fields
+	}
+	return ext
+}
+
+// invalidInput reports the fields of an argument that failed validation.
+func invalidInput(errs []fieldError) error {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + " " + e.Message
+	}
+	return &graphqlError{message: strings.Join(msgs, "; "), code: "BAD_USER_INPUT", fields: errs}
+}
+
+// graphqlStoreError converts a repository.Repository error. Unexpected
+// errors are logged rather than shown to the client.
+func graphqlStoreError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrAlbumNotFound):
+		return &graphqlError{message: err.Error(), code: "NOT_FOUND"}
+	case errors.Is(err, repository.ErrAlbumExists), errors.Is(err, repository.ErrVersionConflict):
+		return &graphqlError{message: err.Error(), code: "CONFLICT"}
+	}
+	slog.ErrorContext(ctx, "album store failed", "err", err)
+	return &graphqlError{message: "internal error", code: "INTERNAL"}
+}
+
+// graphqlResolver resolves the Query and Mutation root fields.
+type graphqlResolver struct {
+	h *graphqlHandler
+}
+
+func (r *graphqlResolver) Albums(ctx context.Context, args struct {
+	Page, Limit         *int32
+	Sort, Artist, Title *string
+	MinPrice, MaxPrice  *float64
+}) (*albumPageResolver, error) {
+	p := listParams{
+		page:     int(deref(args.Page)),
+		limit:    int(deref(args.Limit)),
+		sort:     deref(args.Sort),
+		artist:   deref(args.Artist),
+		title:    deref(args.Title),
+		minPrice: args.MinPrice,
+		maxPrice: args.MaxPrice,
+	}
+	q, page, errs := parseListQuery(p.values())
+	if len(errs) > 0 {
+		return nil, invalidInput(errs)
+	}
+
+	list, total, err := r.h.albums.List(ctx, q)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err)
+	}
+	return &albumPageResolver{list: list, total: total, page: page, limit: q.Limit}, nil
+}
+
+func (r *graphqlResolver) Album(ctx context.Context, args struct{ ID graphql.ID }) (*albumResolver, error) {
+	a, err := r.h.albums.Get(ctx, string(args.ID))
+	if errors.Is(err, repository.ErrAlbumNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err)
+	}
+	return &albumResolver{a}, nil
+}
+
+// albumInput is the AlbumInput argument of mutations.
+type albumInput struct {
+	ID      *graphql.ID
+	Title   string
+	Artist  string
+	Price   float64
+	Version *int32
+}
+
+// album checks in and returns it as an album, applying the binding rules
+// of the REST handlers and sanitizer. prefix names the argument in field
+// errors.
+func (in albumInput) album(prefix string, sanitizer textSanitizer) (album, error) {
+	a := album{Title: in.Title, Artist: in.Artist, Price: in.Price, Version: int(deref(in.Version))}
+	if in.ID != nil {
+		a.ID = string(*in.ID)
+	}
+	if errs := fieldErrors(prefix, binding.Validator.ValidateStruct(&a)); len(errs) > 0 {
+		return album{}, invalidInput(errs)
+	}
+	if err := sanitizer.sanitizeAlbum(&a); err != nil {
+		return album{}, invalidInput([]fieldError{{Field: strings.TrimSuffix(prefix, "."), Message: err.Error()}})
+	}
+	return a, nil
+}
+
+// authorize refuses a mutation from an unidentified caller, or one whose
+// role may not change albums, when credentials are configured.
+func (r *graphqlResolver) authorize(ctx context.Context) error {
+	if len(r.h.auths) == 0 {
+		return nil
+	}
+	id := identityFrom(ctx)
+	if id == nil {
+		return &graphqlError{message: errNoCredentials.Error(), code: "UNAUTHENTICATED"}
+	}
+	if !id.role.atLeast(roleUser) {
+		return &graphqlError{message: "this request needs the user role", code: "FORBIDDEN"}
+	}
+	return nil
+}
+
+func (r *graphqlResolver) CreateAlbums(ctx context.Context, args struct{ Albums []albumInput }) ([]*albumResolver, error) {
+	if err := r.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if len(args.Albums) == 0 {
+		return nil, invalidInput([]fieldError{{Field: "albums", Message: "must not be empty"}})
+	}
+	list := make([]album, len(args.Albums))
+	for i, in := range args.Albums {
+		a, err := in.album("albums["+strconv.Itoa(i)+"].", r.h.sanitizer)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = a
+	}
+
+	created, err := r.h.albums.Add(ctx, list...)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err)
+	}
+	res := make([]*albumResolver, len(created))
+	for i, a := range created {
+		res[i] = &albumResolver{a}
+	}
+	return res, nil
+}
+
+func (r *graphqlResolver) UpdateAlbum(ctx context.Context, args struct {
+	ID    graphql.ID
+	Album albumInput
+}) (*albumResolver, error) {
+	if err := r.authorize(ctx); err != nil {
+		return nil, err
+	}
+	a, err := args.Album.album("album.", r.h.sanitizer)
+	if err != nil {
+		return nil, err
+	}
+	if a.ID != "" && a.ID != string(args.ID) {
+		return nil, invalidInput([]fieldError{{Field: "album.id", Message: "must match id"}})
+	}
+
+	updated, err := r.h.albums.Replace(ctx, string(args.ID), a)
+	if err != nil {
+		return nil, graphqlStoreError(ctx, err)
+	}
+	return &albumResolver{updated}, nil
+}
+
+func (r *graphqlResolver) DeleteAlbum(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	if err := r.authorize(ctx); err != nil {
+		return false, err
+	}
+	if err := r.h.albums.Remove(ctx, string(args.ID)); err != nil {
+		return false, graphqlStoreError(ctx, err)
+	}
+	return true, nil
+}
+
+// deref returns *p, or the zero value when p is nil.
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// albumResolver resolves the fields of an Album.
+type albumResolver struct {
+	a album
+}
+
+func (r *albumResolver) ID() graphql.ID { return graphql.ID(r.a.ID) }
+func (r *albumResolver) Title() string  { return r.a.Title }
+func (r *albumResolver) Artist() string { return r.a.Artist }
+func (r *albumResolver) Price() float64 { return r.a.Price }
+func (r *albumResolver) Version() int32 { return int32(r.a.Version) }
+
+// albumPageResolver resolves the fields of an AlbumPage.
+type albumPageResolver struct {
+	list               []album
+	total, page, limit int
+}
+
+func (r *albumPageResolver) Albums() []*albumResolver {
+	res := make([]*albumResolver, len(r.list))
+	for i, a := range r.list {
+		res[i] = &albumResolver{a}
+	}
+	return res
+}
+
+func (r *albumPageResolver) Total() int32 { return int32(r.total) }
+func (r *albumPageResolver) Page() int32  { return int32(r.page) }
+func (r *albumPageResolver) Limit() int32 { return int32(r.limit) }
@@ -0,0 +1,396 @@
+package handlers
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=pspFileAPI --go-grpc_out=../.. --go-grpc_opt=module=pspFileAPI albums/v1/albums.proto
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"pspFileAPI/albumspb"
+	"pspFileAPI/internal/flags"
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+	"pspFileAPI/internal/tenant"
+)
+
+// grpcWriteMethods are the AlbumService methods that change albums and so
+// need the same credentials as album writes over HTTP.
+var grpcWriteMethods = map[string]bool{
+	albumspb.AlbumService_CreateAlbums_FullMethodName: true,
+	albumspb.AlbumService_UpdateAlbum_FullMethodName:  true,
+	albumspb.AlbumService_DeleteAlbum_FullMethodName:  true,
+}
+
+// albumServer implements AlbumService on the album repository.
+type albumServer struct {
+	albumspb.UnimplementedAlbumServiceServer
+	albums    *service.Albums
+	events    *service.Events
+	sanitizer textSanitizer
+}
+
+// newGRPCServer returns a server for AlbumService, with reflection so
+// tools such as grpcurl can discover it. Writes are admitted by any of
+// auths; with none, they are open as over HTTP.
+func newGRPCServer(albums *service.Albums, events *service.Events, sanitizer textSanitizer, auths []authenticator, tenants tenantResolver, set *flags.Set, m *maintenance) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(grpcLogger, grpcRecovery, grpcMaintenance(m), grpcFlags(set), grpcTenant(tenants), grpcAuth(auths)),
+		grpc.ChainStreamInterceptor(grpcStreamLogger, grpcStreamMaintenance(m), grpcStreamFlags(set), grpcStreamTenant(tenants)),
+	)
+	albumspb.RegisterAlbumServiceServer(srv, albumServer{albums: albums, events: events, sanitizer: sanitizer})
+	reflection.Register(srv)
+	return srv
+}
+
+// grpcListener returns a listener serving srv on addr. Shutdown waits for
+// in-flight calls until ctx is done and then closes the rest.
+func grpcListener(addr string, srv *grpc.Server) listener {
+	return listener{
+		name: "grpc",
+		addr: addr,
+		start: func(lis net.Listener) error {
+			return srv.Serve(lis)
+		},
+		shutdown: func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				srv.Stop()
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+func (s albumServer) ListAlbums(ctx context.Context, req *albumspb.ListAlbumsRequest) (*albumspb.ListAlbumsResponse, error) {
+	q, page, errs := parseListQuery(listParams{
+		page:     int(req.Page),
+		limit:    int(req.Limit),
+		sort:     req.Sort,
+		artist:   req.Artist,
+		title:    req.Title,
+		minPrice: req.MinPrice,
+		maxPrice: req.MaxPrice,
+	}.values())
+	if len(errs) > 0 {
+		return nil, invalidArgument(errs)
+	}
+
+	list, total, err := s.albums.List(ctx, q)
+	if err != nil {
+		return nil, grpcStoreError(ctx, err)
+	}
+	resp := &albumspb.ListAlbumsResponse{Total: int32(total), Page: int32(page), Limit: int32(q.Limit)}
+	for _, a := range list {
+		resp.Albums = append(resp.Albums, albumToPB(a))
+	}
+	return resp, nil
+}
+
+func (s albumServer) GetAlbum(ctx context.Context, req *albumspb.GetAlbumRequest) (*albumspb.Album, error) {
+	a, err := s.albums.Get(ctx, req.Id)
+	if err != nil {
+		return nil, grpcStoreError(ctx, err)
+	}
+	return albumToPB(a), nil
+}
+
+func (s albumServer) CreateAlbums(ctx context.Context, req *albumspb.CreateAlbumsRequest) (*albumspb.CreateAlbumsResponse, error) {
+	if len(req.Albums) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "albums must not be empty")
+	}
+	list := make([]album, len(req.Albums))
+	for i, pb := range req.Albums {
+		list[i] = albumFromPB(pb)
+		if err := s.checkAlbum(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	created, err := s.albums.Add(ctx, list...)
+	if err != nil {
+		return nil, grpcStoreError(ctx, err)
+	}
+	resp := &albumspb.CreateAlbumsResponse{}
+	for _, a := range created {
+		resp.Albums = append(resp.Albums, albumToPB(a))
+	}
+	return resp, nil
+}
+
+func (s albumServer) UpdateAlbum(ctx context.Context, req *albumspb.UpdateAlbumRequest) (*albumspb.Album, error) {
+	a := albumFromPB(req.Album)
+	if a.ID != "" && a.ID != req.Id {
+		return nil, status.Error(codes.InvalidArgument, "album id does not match the request id")
+	}
+	if err := s.checkAlbum(&a); err != nil {
+		return nil, err
+	}
+	updated, err := s.albums.Replace(ctx, req.Id, a)
+	if err != nil {
+		return nil, grpcStoreError(ctx, err)
+	}
+	return albumToPB(updated), nil
+}
+
+func (s albumServer) DeleteAlbum(ctx context.Context, req *albumspb.DeleteAlbumRequest) (*emptypb.Empty, error) {
+	if err := s.albums.Remove(ctx, req.Id); err != nil {
+		return nil, grpcStoreError(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s albumServer) WatchAlbums(req *albumspb.WatchAlbumsRequest, stream albumspb.AlbumService_WatchAlbumsServer) error {
+	replay, ch := s.events.Subscribe(tenant.From(stream.Context()), req.LastEventId)
+	defer s.events.Unsubscribe(ch)
+	send := func(e service.Event) error {
+		return stream.Send(&albumspb.AlbumEvent{Id: e.ID, Type: e.Type, Album: albumToPB(e.Album)})
+	}
+	for _, e := range replay {
+		if err := send(e); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "fell behind; resume with last_event_id")
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		case <-s.events.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// checkAlbum applies the album's binding rules and the text sanitizer, as
+// the HTTP handlers do when binding a body.
+func (s albumServer) checkAlbum(a *album) error {
+	if errs := validate(a); len(errs) > 0 {
+		return invalidArgument(errs)
+	}
+	if err := s.sanitizer.sanitizeAlbum(a); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// invalidArgument joins field errors into one InvalidArgument status.
+func invalidArgument(errs []fieldError) error {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + " " + e.Message
+	}
+	return status.Error(codes.InvalidArgument, strings.Join(msgs, "; "))
+}
+
+func albumToPB(a album) *albumspb.Album {
+	return &albumspb.Album{Id: a.ID, Title: a.Title, Artist: a.Artist, Price: a.Price}
+}
+
+func albumFromPB(pb *albumspb.Album) album {
+	return album{ID: pb.GetId(), Title: pb.GetTitle(), Artist: pb.GetArtist(), Price: pb.GetPrice()}
+}
+
+// grpcStoreError returns the status matching a repository.Repository error.
+// Unexpected errors are logged rather than shown to the client.
+func grpcStoreError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrAlbumNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrAlbumExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	slog.ErrorContext(ctx, "album store failed", "err", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// grpcAuth returns an interceptor that runs the HTTP authenticators on the
+// call's metadata for write methods, so the same Authorization and
+// X-API-Key credentials are accepted.
+func grpcAuth(auths []authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if len(auths) == 0 || !grpcWriteMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		id, err := authenticateAny(metadataRequest(ctx), auths)
+		if errors.Is(err, errWrongTenant) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if !id.role.atLeast(roleUser) {
+			return nil, status.Error(codes.PermissionDenied, "this call needs the user role")
+		}
+		return handler(context.WithValue(ctx, identityKey{}, id), req)
+	}
+}
+
+// metadataRequest returns an HTTP request carrying the call's metadata as
+// headers and its context, so HTTP authenticators and the tenant resolver
+// can read it. The :authority pseudo-header becomes the host.
+func metadataRequest(ctx context.Context) *http.Request {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := (&http.Request{Header: http.Header{}}).WithContext(ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if v := md.Get(":authority"); len(v) > 0 {
+		r.Host = v[0]
+	}
+	return r
+}
+
+// grpcTenant returns an interceptor that resolves each call's tenant from
+// its metadata, and checks the caller may act for it, as the HTTP
+// middleware does from headers.
+func grpcTenant(t tenantResolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		name, err := grpcResolveTenant(t, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(tenant.With(ctx, name), req)
+	}
+}
+
+// grpcStreamTenant is grpcTenant for streaming calls.
+func grpcStreamTenant(t tenantResolver) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		name, err := grpcResolveTenant(t, ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: tenant.With(ss.Context(), name)})
+	}
+}
+
+// grpcResolveTenant returns the tenant of the call carrying ctx, or the
+// status error to fail it with.
+func grpcResolveTenant(t tenantResolver, ctx context.Context) (string, error) {
+	r := metadataRequest(ctx)
+	name, err := t.resolve(r)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if t.source == "" {
+		return name, nil
+	}
+	if err := t.admit(r, name); errors.Is(err, errWrongTenant) {
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	} else if err != nil {
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return name, nil
+}
+
+// grpcMaintenance returns an interceptor failing calls with Unavailable
+// while m is on.
+func grpcMaintenance(m *maintenance) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if m.on.Load() {
+			return nil, status.Error(codes.Unavailable, errMaintenance.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcStreamMaintenance is grpcMaintenance for streaming calls.
+func grpcStreamMaintenance(m *maintenance) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if m.on.Load() {
+			return status.Error(codes.Unavailable, errMaintenance.Error())
+		}
+		return handler(srv, ss)
+	}
+}
+
+// grpcFlags returns an interceptor that lets calls check flags.Enabled
+// against set, as withFlags does for HTTP.
+func grpcFlags(set *flags.Set) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(flags.NewContext(ctx, set), req)
+	}
+}
+
+// grpcStreamFlags is grpcFlags for streaming calls.
+func grpcStreamFlags(set *flags.Set) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: flags.NewContext(ss.Context(), set)})
+	}
+}
+
+// contextStream is a ServerStream with the context interceptors built.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// grpcRecovery turns a panicking call into an Internal error.
+func grpcRecovery(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "panic handling call", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// grpcLogger logs each unary call like the HTTP access log.
+func grpcLogger(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// grpcStreamLogger logs each streaming call when it ends.
+func grpcStreamLogger(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// logCall logs a finished call at a level matching its status code.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "grpc call", "method", method, "code", code.String(), "duration", time.Since(start))
+}
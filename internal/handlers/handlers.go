@@ -0,0 +1,503 @@
+// Package handlers serves the album services over HTTP, GraphQL and gRPC,
+// with the middleware, authentication and listeners the configuration asks
+// for.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/flags"
+	"pspFileAPI/internal/health"
+	"pspFileAPI/internal/httpclient"
+	"pspFileAPI/internal/notify"
+	"pspFileAPI/internal/scheduler"
+	"pspFileAPI/internal/service"
+)
+
+// Services are the services the API front ends call.
+type Services struct {
+	Albums *service.Albums
+	Events *service.Events
+	Jobs   *service.Jobs
+	// Cache, when set, holds idempotent responses and sessions in place
+	// of the stores the configuration opens. The caller closes it.
+	Cache cache.Cache
+	// Health holds the checks /readyz runs, to which the server adds its
+	// own; when nil it starts empty.
+	Health *health.Registry
+	// Notifier, when set, is told of webhook deliveries given up on.
+	Notifier *notify.Notifier
+	// Offline builds the server without connecting to anything, for
+	// listing its routes: caches stay in memory, and the audit sink, OIDC
+	// provider, trace exporter and access log are not opened. An offline
+	// server cannot serve.
+	Offline bool
+}
+
+// Server is the API and every listener the configuration enables, ready to
+// serve.
+type Server struct {
+	cfg       config.Config
+	router    *gin.Engine
+	live      *reloader
+	listeners []listener
+	webhooks  *webhookDispatcher
+	jobs      *scheduler.Scheduler
+	// debug is whether profiles are served; /admin/debug changes it.
+	debug atomic.Bool
+	// maintenance is on while MAINTENANCE or /admin/maintenance say so.
+	maintenance maintenance
+	// maxBodyBytes caps decoded request bodies, from MAX_BODY_BYTES.
+	maxBodyBytes int64
+	// sanitizer applies TEXT_SANITIZE and TEXT_NORMALIZE to album text.
+	sanitizer textSanitizer
+	// closers release what NewServer opened, in reverse order.
+	closers []func()
+	// offline is set when the server was built from offline Services.
+	offline bool
+}
+
+// NewServer configures the API over svc. While serving, load is called
+// again to reload the configuration.
+func NewServer(cfg config.Config, load func() (config.Config, error), svc Services) (_ *Server, err error) {
+	s := &Server{cfg: cfg, offline: svc.Offline}
+	if svc.Health == nil {
+		svc.Health = health.NewRegistry(cfg.ReadyTimeout)
+	}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	if err := setupValidation(); err != nil {
+		return nil, err
+	}
+
+	s.maxBodyBytes = cfg.MaxBodyBytes
+	s.sanitizer, err = newTextSanitizer(cfg.TextSanitize, cfg.TextNormalize)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations, err := parseDeprecatedRoutes(cfg.DeprecatedRoutes)
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := parseRouteTimeouts(cfg.RouteTimeouts)
+	if err != nil {
+		return nil, err
+	}
+
+	router := gin.New()
+	s.router = router
+	// Believe X-Forwarded-For and X-Real-IP only from TRUSTED_PROXIES, for
+	// logs and rate limits. gin trusts every peer unless told otherwise;
+	// with the setting unset, nil trusts none.
+	var proxies []string
+	if len(cfg.TrustedProxies) > 0 {
+		proxies = cfg.TrustedProxies
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+
+	// Trace every request when an OTLP endpoint is configured.
+	if tracingEnabled() && !svc.Offline {
+		shutdown, err := setupTracing(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, func() { shutdown(context.Background()) })
+		router.Use(otelgin.Middleware(service.ServiceName))
+	}
+
+	// Browsers' favicon requests and probes are neither logged nor counted.
+	quiet := []string{"/favicon.ico", "/healthz", "/readyz"}
+	metrics := newHTTPMetrics(cfg.MetricsNamespace, cfg.MetricsGatherTimeout, cfg.MetricsMaxStale)
+	// Write access log lines to ACCESS_LOG, apart from the application
+	// log, when it is set.
+	var access *slog.Logger
+	if cfg.AccessLog != "" && !svc.Offline {
+		var f *os.File
+		if access, f, err = openAccessLog(cfg.AccessLog); err != nil {
+			return nil, err
+		}
+		if f != nil {
+			s.closers = append(s.closers, func() { f.Close() })
+		}
+	}
+	router.Use(withRequestID(), accessLogger(access, quiet...), metrics.middleware(quiet...), recovery())
+	router.Use(deprecatedRoutes(deprecations))
+	// Give each request REQUEST_TIMEOUT, or its route's ROUTE_TIMEOUTS.
+	router.Use(requestTimeouts(cfg.RequestTimeout, timeouts))
+	// Answer errors in the language Accept-Language asks for.
+	router.Use(localize())
+	router.Use(limitBodies(s.maxBodyBytes))
+	// In maintenance mode, turn away everything but probes, metrics and
+	// /admin, and report not ready.
+	s.maintenance.on.Store(cfg.Maintenance)
+	s.maintenance.retryAfter = cfg.MaintenanceRetryAfter
+	router.Use(s.maintenance.middleware())
+	svc.Health.Register("maintenance", true, s.maintenance.check)
+
+	// Evaluate FEATURE_FLAGS for each request; /admin/flags and reloads
+	// change them.
+	parsedFlags, _ := flags.Parse(cfg.FeatureFlags) // checked by validate
+	featureFlags := flags.New(parsedFlags, flagSubject)
+	router.Use(withFlags(featureFlags))
+
+	// Record who changed what when AUDIT_LOG is set.
+	var audit auditSink
+	if cfg.AuditLog != "" {
+		if svc.Offline {
+			audit = offlineAuditSink{}
+		} else if audit, err = openAuditSink(context.Background(), cfg); err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, func() { audit.close() })
+		router.Use(auditRequests(audit))
+	}
+
+	// Let browsers on CORS_ALLOWED_ORIGINS call the API.
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		router.Use(cors(corsConfig{
+			origins: cfg.CORSAllowedOrigins,
+			methods: cfg.CORSAllowedMethods,
+			headers: cfg.CORSAllowedHeaders,
+			expose:  []string{requestIDHeader, "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "Deprecation", "Sunset", "Link", "ETag", "Age", cacheHeader, signatureHeader},
+			maxAge:  cfg.CORSMaxAge,
+		}))
+	}
+
+	// Limit each client to RATE_LIMIT requests per second when set. The
+	// limiter stays installed so a reload can turn it on.
+	limiter := newRateLimiter(cfg.RateLimit, cfg.Burst())
+	router.Use(limiter.middleware())
+
+	// Capture request and response bodies for debugging when enabled.
+	if cfg.BodyCapture {
+		router.Use(captureBodies(os.Stderr, cfg.BodyCaptureLimit))
+	}
+
+	// Compress responses of at least COMPRESS_MIN_SIZE bytes. This runs
+	// outside signing so signatures cover the uncompressed body.
+	router.Use(compress(cfg.CompressMinSize))
+
+	// Sign response bodies when a shared secret is configured.
+	s.live = &reloader{load: load, current: cfg, limiter: limiter, flags: featureFlags, maintenance: &s.maintenance}
+	if cfg.ResponseSigningSecret != "" {
+		s.live.signingSecret = newSecretValue(cfg.ResponseSigningSecret)
+		router.Use(signResponses(s.live.signingSecret))
+	}
+	router.Use(etags())
+	// Tell clients whether the idempotency and album caches answered.
+	router.Use(cacheStatus())
+
+	api := apiRoutes{
+		albums: &albumHandler{albums: svc.Albums, events: svc.Events, jobs: svc.Jobs, sanitizer: s.sanitizer},
+		audit:  audit,
+	}
+
+	// Keep responses to POSTs for clients retrying with an Idempotency-Key,
+	// in Redis when REDIS_URL is set so every instance sees them.
+	var idempotencyStore cache.Cache
+	var idem *idempotency
+	if cfg.IdempotencyTTL > 0 {
+		store, err := s.openStore(svc, "idempotency_cache")
+		if err != nil {
+			return nil, err
+		}
+		idempotencyStore = store
+		idem = newIdempotency(store, cfg.IdempotencyTTL)
+		api.idempotency = append(api.idempotency, idem.middleware())
+	}
+
+	// Calls to other services share one retry budget.
+	budget := httpclient.NewBudget(cfg.OutboundRetryBudget, retryBudgetBurst)
+
+	// With a JWT secret or API keys configured, changing albums requires a
+	// token from POST /login or an X-API-Key, and a role accessPolicy
+	// allows.
+	roles, err := newRoleStore(cfg.AuthRoles)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := parseTenantAssignments(cfg.AuthTenants)
+	if err != nil {
+		return nil, err
+	}
+	var auths []authenticator
+	if cfg.JWTSecret != "" {
+		if api.jwt, err = newJWTAuth(cfg.JWTSecret, cfg.JWTTTL, cfg.AuthUsers, roles); err != nil {
+			return nil, err
+		}
+		api.jwt.tenants = tenants
+		s.live.jwtSecret = api.jwt.secret
+		auths = append(auths, api.jwt)
+	}
+	// Browsers may instead get a session cookie at login, kept in Redis
+	// when REDIS_URL is set.
+	if cfg.SessionsEnabled {
+		store, err := s.openStore(svc, "session_cache")
+		if err != nil {
+			return nil, err
+		}
+		api.sessions = newSessionStore(store, cfg.SessionIdleTimeout, cfg.SessionMaxAge, cfg.SessionCookieSecure)
+		api.jwt.sessions = api.sessions
+		auths = append(auths, api.sessions)
+	}
+	// Users may also log in through an OIDC provider, getting the same
+	// tokens as from POST /login.
+	if cfg.OIDCIssuer != "" && svc.Offline {
+		api.oidc = &oidcLogin{jwt: api.jwt}
+	} else if cfg.OIDCIssuer != "" {
+		client := httpclient.New(outbound(cfg, cfg.OutboundTimeout, budget))
+		if api.oidc, err = newOIDCLogin(context.Background(), cfg, api.jwt, client); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AdminToken != "" || cfg.APIKeyHashes != "" {
+		if api.keys, err = newAPIKeyStore(cfg.APIKeyHashes, roles); err != nil {
+			return nil, err
+		}
+		api.keys.tenants = tenants
+		auths = append(auths, api.keys)
+		// Only keys the store knows get a rate limit and idempotency
+		// scope of their own.
+		limiter.keys = api.keys
+		if idem != nil {
+			idem.keys = api.keys
+		}
+	}
+	if len(auths) > 0 {
+		api.writeAuth = append(api.writeAuth, requireAuth(auths...), enforcePolicy())
+		api.roles = roles
+	}
+	// /ws runs album operations and sends events over a WebSocket.
+	api.sockets = &socketHandler{albums: api.albums, events: svc.Events, auths: auths, origins: cfg.CORSAllowedOrigins}
+	// /admin takes ADMIN_TOKEN or a caller with the admin role.
+	if cfg.AdminToken != "" {
+		s.live.adminToken = newSecretValue(cfg.AdminToken)
+	}
+	if s.live.adminToken != nil || len(auths) > 0 {
+		api.adminAuth = requireAdmin(s.live.adminToken, auths)
+	}
+
+	// Scope every request, and the albums, events, webhooks, jobs and files
+	// it reaches, to the tenant TENANT_SOURCE names.
+	resolver, err := newTenantResolver(cfg.TenantSource, cfg.TenantDomain, auths, cfg.Tenants, tenants.tenants())
+	if err != nil {
+		return nil, err
+	}
+	resolver.adminToken = s.live.adminToken
+	if cfg.TenantSource != "" {
+		router.Use(resolver.middleware())
+	}
+
+	// Serve profiles when DEBUG_ENDPOINTS is set, on DEBUG_ADDR if given so
+	// they need not be reachable from the public port. With the admin
+	// endpoints on they are routed either way, for /admin/debug to turn on.
+	s.debug.Store(cfg.DebugEndpoints)
+	if cfg.DebugEndpoints || api.adminAuth != nil {
+		if cfg.DebugAddr != "" {
+			s.listeners = append(s.listeners, plain("debug", &http.Server{Addr: cfg.DebugAddr, Handler: debugOnly(&s.debug, pprofHandler()), ReadHeaderTimeout: 5 * time.Second}))
+		} else {
+			router.Any("/debug/pprof/*profile", debugRoute(&s.debug, pprofHandler()))
+		}
+	}
+	if api.adminAuth != nil {
+		api.runtime = &runtimeAdmin{live: s.live, debug: &s.debug, maintenance: &s.maintenance}
+		api.flags = &flagAdmin{set: featureFlags}
+	}
+
+	router.NoRoute(noRoute)
+	router.HandleMethodNotAllowed = true
+	router.NoMethod(methodNotAllowed(router))
+	router.GET("/favicon.ico", getFavicon)
+	router.GET("/healthz", getHealthz)
+	router.GET("/readyz", getReadyz(svc.Health))
+	router.GET("/metrics", metrics.handler())
+	router.GET("/openapi.json", getOpenAPI)
+	router.GET("/docs", getDocs)
+	router.POST("/graphql", newGraphQLHandler(svc.Albums, auths, s.sanitizer).postGraphQL)
+	if cfg.GraphiQL {
+		router.GET("/graphiql", getGraphiQL)
+	}
+	router.GET("/", getIndex)
+	router.GET("/assets/*filepath", getAsset)
+
+	files, err := openFileStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	api.uploads = &uploadHandler{store: files, maxBytes: cfg.UploadMaxBytes, allowed: cfg.UploadAllowedTypes}
+	svc.Health.Register("upload_dir", false, health.DiskSpace(cfg.UploadDir, uint64(cfg.DiskMinFree)))
+
+	// Proxy reads of /upstreams/{name} to the UPSTREAMS APIs, each behind
+	// its own circuit breaker.
+	if cfg.Upstreams != "" {
+		if api.upstreams, err = newUpstreamProxy(cfg.Upstreams, httpclient.NewTransport(outbound(cfg, cfg.UpstreamTimeout, budget)), cfg.BreakerFailures, cfg.BreakerOpenDelay, metrics.registerer); err != nil {
+			return nil, err
+		}
+	}
+
+	// Let clients register callbacks for album changes and finished jobs.
+	if cfg.WebhooksEnabled {
+		api.webhooks = newWebhookDispatcher(svc.Events, cfg.WebhookMaxAttempts, cfg.WebhookTimeout, cfg.WebhookAllowPrivate)
+		s.webhooks = api.webhooks
+		svc.Jobs.Finished = api.webhooks.jobFinished
+		api.webhooks.notifier = svc.Notifier
+	}
+
+	// Purge, sweep and retry in the background on the *_SCHEDULE
+	// schedules.
+	s.jobs = scheduler.New(metrics.registerer)
+	if err := scheduleJobs(s.jobs, cfg, svc.Albums, idempotencyStore, api.webhooks); err != nil {
+		return nil, err
+	}
+
+	var sunset time.Time
+	if cfg.UnversionedSunset != "" {
+		sunset, _ = time.Parse(time.DateOnly, cfg.UnversionedSunset)
+	}
+	api.registerVersions(router, sunset)
+
+	port, _ := config.ParsePort(cfg.Port)
+	if warning := privilegedPortWarning(port, unprivilegedPortStart(), canBindPrivileged()); warning != "" {
+		slog.Warn(warning)
+	}
+
+	// Accept HTTP/2 without TLS for clients and proxies that speak h2c.
+	router.UseH2C = cfg.H2C
+	srv := newServer(cfg, router.Handler())
+	srv.RegisterOnShutdown(svc.Events.Close)
+	// Serve the gRPC AlbumService on GRPC_ADDR, with the same credentials
+	// guarding writes.
+	if cfg.GRPCAddr != "" {
+		s.listeners = append(s.listeners, grpcListener(cfg.GRPCAddr, newGRPCServer(svc.Albums, svc.Events, s.sanitizer, auths, resolver, featureFlags, &s.maintenance)))
+	}
+
+	web, redirect, err := configureTLS(srv, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Shutting the server down does not wait for its WebSockets, which
+	// close themselves when it starts; wait for them here.
+	shutdownWeb := web.shutdown
+	web.shutdown = func(ctx context.Context) error {
+		err := shutdownWeb(ctx)
+		if werr := api.sockets.wait(ctx); err == nil {
+			err = werr
+		}
+		return err
+	}
+	s.listeners = append(s.listeners, web)
+	if redirect != nil {
+		s.listeners = append(s.listeners, *redirect)
+	}
+	return s, nil
+}
+
+// openStore returns svc.Cache if set, and otherwise opens a store of its
+// own, closed with the server, whose entries last until their ttl rather
+// than competing for the response cache's CACHE_SIZE; offline, it is kept
+// in memory. A store on a server is checked by /readyz as name.
+func (s *Server) openStore(svc Services, name string) (cache.Cache, error) {
+	store := svc.Cache
+	if store == nil {
+		cfg := s.cfg
+		if svc.Offline {
+			cfg.RedisURL = ""
+		}
+		var err error
+		if store, err = cache.OpenStore(context.Background(), cfg); err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, func() { store.Close() })
+	}
+	if p, ok := store.(interface{ Ping(context.Context) error }); ok {
+		svc.Health.Register(name, true, p.Ping)
+	}
+	return store, nil
+}
+
+// retryBudgetBurst is how many retries of outbound calls can be saved up
+// for a burst of failures.
+const retryBudgetBurst = 10
+
+// outbound returns the client options OUTBOUND_* describe, bounding each
+// attempt by timeout and spending retries from budget.
+func outbound(cfg config.Config, timeout time.Duration, budget *httpclient.Budget) httpclient.Options {
+	return httpclient.Options{
+		Timeout:     timeout,
+		MaxAttempts: cfg.OutboundAttempts,
+		BaseDelay:   cfg.OutboundRetryDelay,
+		MaxDelay:    cfg.OutboundRetryMaxDelay,
+		Budget:      budget,
+	}
+}
+
+// Serve runs every listener and the scheduled jobs until the process
+// receives SIGINT or SIGTERM, then shuts them down gracefully.
+func (s *Server) Serve() error {
+	if s.offline {
+		return errors.New("an offline server cannot serve")
+	}
+	// Apply changed live settings on SIGHUP, when the config file changes
+	// and every SECRETS_REFRESH.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.live.watch(ctx)
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		s.jobs.Run(ctx)
+	}()
+
+	err := serve(s.cfg.ShutdownDelay, s.cfg.ShutdownTimeout, s.cfg.UpgradeTimeout, s.listeners...)
+	// Let scheduled jobs running at shutdown return before the stores they
+	// use close.
+	cancel()
+	<-jobsDone
+	return err
+}
+
+// Routes returns the routes the API serves, sorted by path and method.
+func (s *Server) Routes() gin.RoutesInfo {
+	routes := s.router.Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// Close stops webhook deliveries and releases what NewServer opened. Call
+// it once the jobs requests queued have drained, so their webhooks still
+// go out.
+func (s *Server) Close() {
+	if s.webhooks != nil {
+		s.webhooks.close()
+		s.webhooks = nil
+	}
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+}
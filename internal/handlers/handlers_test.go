@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/fake"
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testAlbums seed the repository of test servers.
+var testAlbums = []repository.Album{
+	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
+}
+
+// testServer is a Server over a fake repository and cache, configured by
+// env on top of the defaults.
+type testServer struct {
+	*Server
+	repo  *fake.Repository
+	cache *fake.Cache
+	clock *fake.Clock
+}
+
+// newTestServer returns a testServer closed when t ends.
+func newTestServer(t *testing.T, env map[string]string) *testServer {
+	t.Helper()
+	vars := map[string]string{"UPLOAD_DIR": t.TempDir(), "LOG_LEVEL": "error"}
+	for k, v := range env {
+		vars[k] = v
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	cfg, err := config.Load(nil, lookup)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	ts := &testServer{repo: fake.NewRepository(testAlbums...), clock: fake.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}
+	ts.cache = fake.NewCache(ts.clock)
+	events := service.NewEvents()
+	jobs := service.NewJobs(1, 10, time.Minute)
+	t.Cleanup(func() { jobs.Drain(time.Second) })
+	ts.Server, err = NewServer(cfg, func() (config.Config, error) { return cfg, nil }, Services{
+		Albums: service.NewAlbums(ts.repo, events),
+		Events: events,
+		Jobs:   jobs,
+		Cache:  ts.cache,
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with body, if not empty, as JSON, and headers given as
+// name, value pairs.
+func (ts *testServer) do(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// wantStatus fails t unless w has status want.
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status %d, want %d; body: %s", w.Code, want, w.Body)
+	}
+}
+
+func TestIdempotentReplayIsCacheHit(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"IDEMPOTENCY_TTL": "1h"})
+	body := `{"title": "Kind of Blue", "artist": "Miles Davis", "price": 9.99}`
+
+	first := ts.do(http.MethodPost, "/v1/albums", body, "Idempotency-Key", "k1")
+	wantStatus(t, first, http.StatusCreated)
+	if got := first.Header().Get(cacheHeader); got != "MISS" {
+		t.Errorf("first %s = %q, want MISS", cacheHeader, got)
+	}
+
+	ts.clock.Advance(5 * time.Second)
+	again := ts.do(http.MethodPost, "/v1/albums", body, "Idempotency-Key", "k1")
+	wantStatus(t, again, http.StatusCreated)
+	if got := again.Header().Get(cacheHeader); got != "HIT" {
+		t.Errorf("replay %s = %q, want HIT", cacheHeader, got)
+	}
+	if again.Body.String() != first.Body.String() {
+		t.Errorf("replay body %s, want %s", again.Body, first.Body)
+	}
+
+	if got := ts.do(http.MethodGet, "/v1/albums/1", "").Header().Get(cacheHeader); got != "" {
+		t.Errorf("uncached read has %s %q", cacheHeader, got)
+	}
+}
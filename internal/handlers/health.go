@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/health"
+)
+
+// shuttingDown is set once a graceful shutdown starts, failing /readyz.
+var shuttingDown atomic.Bool
+
+// getHealthz reports that the process is up and serving requests.
+func getHealthz(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// getReadyz reports whether this instance should receive traffic: it is
+// not shutting down and no critical check fails. The body lists every
+// check with its status and latency; failing optional checks report the
+// instance degraded but still ready.
+func getReadyz(checks *health.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if shuttingDown.Load() {
+			writeProblem(c, http.StatusServiceUnavailable, "shutting down")
+			return
+		}
+		report := checks.Check(c.Request.Context())
+		status := http.StatusOK
+		if report.Status == health.StatusUnavailable {
+			status = http.StatusServiceUnavailable
+		}
+		for _, res := range report.Checks {
+			if res.Status == health.StatusFail {
+				slog.WarnContext(c.Request.Context(), "health check failed", "check", res.Name, "critical", res.Critical, "err", res.Error)
+			}
+		}
+		c.IndentedJSON(status, report)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"pspFileAPI/internal/health"
+)
+
+// readyCheck returns the result for check name in a /readyz response.
+func readyCheck(t *testing.T, body []byte, name string) (health.Report, health.Result) {
+	t.Helper()
+	var report health.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("readyz body %s: %v", body, err)
+	}
+	for _, res := range report.Checks {
+		if res.Name == name {
+			return report, res
+		}
+	}
+	t.Fatalf("readyz body %s has no %s check", body, name)
+	return report, health.Result{}
+}
+
+func TestReadyzFailsOnCriticalCheck(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.do(http.MethodGet, "/readyz", "")
+	wantStatus(t, w, http.StatusOK)
+	if report, res := readyCheck(t, w.Body.Bytes(), "maintenance"); report.Status != health.StatusReady || res.Status != health.StatusPass {
+		t.Errorf("readyz %+v, want ready with maintenance passing", report)
+	}
+
+	ts = newTestServer(t, map[string]string{"MAINTENANCE": "true"})
+	w = ts.do(http.MethodGet, "/readyz", "")
+	wantStatus(t, w, http.StatusServiceUnavailable)
+	report, res := readyCheck(t, w.Body.Bytes(), "maintenance")
+	if report.Status != health.StatusUnavailable || res.Status != health.StatusFail || !res.Critical || res.Error == "" {
+		t.Errorf("readyz %+v, want unavailable with maintenance failing", report)
+	}
+}
+
+func TestReadyzDegradesOnOptionalCheck(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"DISK_MIN_FREE": "9223372036854775807"})
+	w := ts.do(http.MethodGet, "/readyz", "")
+	wantStatus(t, w, http.StatusOK)
+	report, res := readyCheck(t, w.Body.Bytes(), "upload_dir")
+	if report.Status != health.StatusDegraded || res.Status != health.StatusFail || res.Critical {
+		t.Errorf("readyz %+v, want degraded with upload_dir failing", report)
+	}
+}
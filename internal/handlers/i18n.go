@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+
+	"pspFileAPI/internal/i18n"
+)
+
+// localize returns middleware that answers each request in the language
+// its Accept-Language header prefers among those with a catalog.
+func localize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lang := i18n.Match(c.GetHeader("Accept-Language"))
+		c.Request = c.Request.WithContext(i18n.NewContext(c.Request.Context(), lang))
+		c.Next()
+	}
+}
+
+// tr returns msg in the language of c's request, formatted with args if
+// there are any. Handlers pass it English; messages without a translation
+// stay as they are.
+func tr(c *gin.Context, msg string, args ...any) string {
+	return translate(i18n.FromContext(c.Request.Context()), msg, args...)
+}
+
+// translate is tr for lang.
+func translate(lang language.Tag, msg string, args ...any) string {
+	if len(args) == 0 {
+		// Not a format: an error's text may hold a stray '%'.
+		return i18n.T(lang, msg)
+	}
+	return i18n.Sprintf(lang, msg, args...)
+}
+
+// translateFields returns a copy of errs with the messages in lang.
+func translateFields(lang language.Tag, errs []fieldError) []fieldError {
+	if len(errs) == 0 {
+		return errs
+	}
+	out := make([]fieldError, len(errs))
+	for i, fe := range errs {
+		out[i] = fieldError{Field: fe.Field, Message: translate(lang, fe.Message, fe.args...)}
+	}
+	return out
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/tenant"
+)
+
+// Headers of the Idempotency-Key protocol.
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKey is the longest Idempotency-Key accepted.
+const maxIdempotencyKey = 255
+
+// replayedHeaders are the response headers stored with a response and sent
+// again when it is replayed. Others, like the request ID, belong to the
+// retry.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// storedResponse is a response kept for an idempotency key.
+type storedResponse struct {
+	// Fingerprint identifies the request the response answered, so a key
+	// reused for a different request is refused.
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+	// StoredAt is when the response was stored, for the Age of replays.
+	StoredAt time.Time `json:"stored_at"`
+}
+
+// idempotency replays the first response to a POST for retries sent with
+// the same Idempotency-Key.
+type idempotency struct {
+	store cache.Cache
+	ttl   time.Duration
+	// keys are the API keys that identify callers, as for rate limits.
+	keys *apiKeyStore
+
+	// inFlight holds the keys of requests being handled by this process.
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// newIdempotency returns idempotency keeping responses in store for ttl.
+func newIdempotency(store cache.Cache, ttl time.Duration) *idempotency {
+	return &idempotency{store: store, ttl: ttl, inFlight: make(map[string]struct{})}
+}
+
+// middleware returns middleware that handles requests with an
+// Idempotency-Key once per caller and key. Retries get the stored response
+// with Idempotent-Replayed: true, a retry while the first request is still
+// running gets 409, and reusing a key for a different request gets 422.
+// Server errors and 429s are not stored, so they can be retried. Requests
+// without the header pass through.
+func (i *idempotency) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeProblem(c, http.StatusBadRequest, "invalid Idempotency-Key",
+				fieldError{Field: idempotencyKeyHeader, Message: "must be at most 255 characters"})
+			return
+		}
+
+		limitBody(c)
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeProblem(c, http.StatusRequestEntityTooLarge, tr(c, "request body must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			writeProblem(c, http.StatusBadRequest, "could not read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request, body)
+
+		// Keys are scoped to the tenant and the caller so one client cannot
+		// replay another's responses, even under the same name in another
+		// tenant.
+		caller := clientKey(c, i.keys)
+		if id := identityFrom(c.Request.Context()); id != nil {
+			caller = "user:" + id.subject
+		}
+		sum := sha256.Sum256([]byte(tenant.From(c.Request.Context()) + "\x00" + caller + "\x00" + c.Request.Method + " " + c.Request.URL.Path + "\x00" + key))
+		storeKey := "idempotency:" + hex.EncodeToString(sum[:])
+		ctx := c.Request.Context()
+		addLogField(ctx, "idempotency_key", key)
+
+		if !i.acquire(storeKey) {
+			c.Header("Retry-After", "1")
+			writeProblem(c, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+			return
+		}
+		defer i.release(storeKey)
+
+		if b, ok, err := i.store.Get(ctx, storeKey); err != nil {
+			slog.WarnContext(ctx, "idempotency lookup failed", "err", err)
+		} else if ok {
+			var prev storedResponse
+			if err := json.Unmarshal(b, &prev); err == nil {
+				if prev.Fingerprint != fingerprint {
+					writeProblem(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+					return
+				}
+				cache.Record(ctx, true)
+				replay(c, prev)
+				return
+			}
+		}
+		cache.Record(ctx, false)
+
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+		c.Writer = w.ResponseWriter
+		c.Writer.Write(w.body.Bytes())
+
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			return
+		}
+		resp := storedResponse{Fingerprint: fingerprint, Status: status, Header: http.Header{}, Body: w.body.Bytes(), StoredAt: time.Now().UTC()}
+		for _, h := range replayedHeaders {
+			if v := c.Writer.Header().Values(h); len(v) > 0 {
+				resp.Header[h] = v
+			}
+		}
+		b, _ := json.Marshal(resp)
+		if err := i.store.Set(ctx, storeKey, b, i.ttl); err != nil {
+			slog.WarnContext(ctx, "idempotency store failed", "err", err)
+		}
+	}
+}
+
+// acquire marks key as in flight, reporting false if it already was.
+func (i *idempotency) acquire(key string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.inFlight[key]; ok {
+		return false
+	}
+	i.inFlight[key] = struct{}{}
+	return true
+}
+
+func (i *idempotency) release(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.inFlight, key)
+}
+
+// requestFingerprint hashes what makes two requests the same: the query and
+// the body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.RawQuery + "\x00"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a stored response instead of handling the request.
+func replay(c *gin.Context, resp storedResponse) {
+	c.Abort()
+	for k, v := range resp.Header {
+		c.Writer.Header()[k] = v
+	}
+	c.Header(idempotentReplayedHeader, "true")
+	if !resp.StoredAt.IsZero() {
+		c.Header("Age", strconv.Itoa(int(time.Since(resp.StoredAt).Seconds())))
+	}
+	if len(resp.Body) == 0 {
+		c.Status(resp.Status)
+		return
+	}
+	c.Data(resp.Status, resp.Header.Get("Content-Type"), resp.Body)
+}
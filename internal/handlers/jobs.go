@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// acceptJob queues run and responds 202 with the job and its Location, or
+// 503 when the queue is full or stopping.
+func (h *albumHandler) acceptJob(c *gin.Context, run func(ctx context.Context) (any, error)) {
+	j, err := h.jobs.Enqueue(c.Request.Context(), run)
+	if err != nil {
+		c.Header("Retry-After", "1")
+		writeProblem(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	addLogField(c.Request.Context(), "job_id", j.ID)
+	c.Header("Location", path.Join(path.Dir(c.FullPath()), "jobs", j.ID))
+	c.IndentedJSON(http.StatusAccepted, j)
+}
+
+// getJob responds with the status of one of the tenant's jobs, and its
+// result once it has succeeded.
+func (h *albumHandler) getJob(c *gin.Context) {
+	j, ok := h.jobs.Get(c.Param("id"))
+	if !ok || j.Tenant != tenant.From(c.Request.Context()) {
+		writeProblem(c, http.StatusNotFound, "job not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, j)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logFieldsKey is the context key for the request's logFields.
+type logFieldsKey struct{}
+
+// logFields collects key/value pairs that are emitted together on the
+// request's access log line. Handlers may add to it from any goroutine.
+type logFields struct {
+	mu     sync.Mutex
+	fields map[string]any
+}
+
+// addLogField attaches key=value to the access log line of the request
+// carrying ctx. It does nothing outside a request.
+func addLogField(ctx context.Context, key string, value any) {
+	f, ok := ctx.Value(logFieldsKey{}).(*logFields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fields[key] = value
+}
+
+// attrs returns the fields as log attributes in key order.
+func (f *logFields) attrs() []slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.fields))
+	for k := range f.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, len(keys))
+	for i, k := range keys {
+		attrs[i] = slog.Any(k, f.fields[k])
+	}
+	return attrs
+}
+
+// accessLogger returns middleware that logs one line per request with its
+// method, path, status and duration, the client and its user agent,
+// followed by any fields handlers attached with addLogField. Lines go to
+// sink, or the application log if it is nil. Requests for skipPaths are
+// not logged.
+func accessLogger(sink *slog.Logger, skipPaths ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(skipPaths))
+	for _, p := range skipPaths {
+		skip[p] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		f := &logFields{fields: make(map[string]any)}
+		ctx := context.WithValue(c.Request.Context(), logFieldsKey{}, f)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if skip[path] {
+			return
+		}
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("remote_addr", c.RemoteIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		attrs = append(attrs, f.attrs()...)
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger := sink
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
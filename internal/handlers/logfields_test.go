@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogRouter returns a router logging requests as JSON to buf, with
+// handler on /work.
+func accessLogRouter(buf *bytes.Buffer, handler gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.Use(accessLogger(slog.New(slog.NewJSONHandler(buf, nil)), "/healthz"))
+	router.GET("/work", handler)
+	router.GET("/healthz", getHealthz)
+	return router
+}
+
+func TestLogFieldsAppearOnTheAccessLogLine(t *testing.T) {
+	var buf bytes.Buffer
+	router := accessLogRouter(&buf, func(c *gin.Context) {
+		ctx := c.Request.Context()
+		addLogField(ctx, "tenant", "acme")
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				addLogField(ctx, fmt.Sprint("worker_", i), i)
+			}(i)
+		}
+		wg.Wait()
+		c.Status(http.StatusNoContent)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/work", nil))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("access log %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "request" || line["status"] != float64(http.StatusNoContent) || line["tenant"] != "acme" {
+		t.Errorf("access log line %v, want the request with tenant=acme", line)
+	}
+	for i := 0; i < 10; i++ {
+		if line[fmt.Sprint("worker_", i)] != float64(i) {
+			t.Errorf("access log line %v lacks worker_%d", line, i)
+		}
+	}
+}
+
+func TestAccessLogSkipsPathsAndIgnoresFieldsOutsideRequests(t *testing.T) {
+	var buf bytes.Buffer
+	router := accessLogRouter(&buf, getHealthz)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("skipped path logged: %s", buf.String())
+	}
+
+	// Outside a request there is nothing to attach to.
+	addLogField(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "tenant", "acme")
+}
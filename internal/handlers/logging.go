@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"pspFileAPI/internal/config"
+)
+
+// logLevel is the lowest level logged. Reloading the configuration
+// changes it without replacing the logger.
+var logLevel slog.LevelVar
+
+// newLogger returns a logger writing to w in format ("text", the default,
+// or "json") and discarding records below level. Records logged with a
+// request's context carry its request ID.
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(requestIDHandler{slog.NewTextHandler(w, opts)}), nil
+	case "json":
+		return slog.New(requestIDHandler{slog.NewJSONHandler(w, opts)}), nil
+	}
+	return nil, fmt.Errorf("LOG_FORMAT %q must be \"text\" or \"json\"", format)
+}
+
+// NewLogger returns the logger cfg asks for, writing to w. Its level
+// follows LOG_LEVEL across reloads.
+func NewLogger(w io.Writer, cfg config.Config) (*slog.Logger, error) {
+	level, _ := config.ParseLogLevel(cfg.LogLevel) // checked by validate
+	logLevel.Set(level)
+	return newLogger(w, cfg.LogFormat, &logLevel)
+}
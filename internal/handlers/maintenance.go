@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errMaintenance is why requests are refused in maintenance mode.
+var errMaintenance = errors.New("the service is down for maintenance")
+
+// maintenance is the switch that takes the API out of service while
+// operators work on it, leaving probes, metrics and /admin up. MAINTENANCE
+// sets it at startup and on reloads that change it, /admin/maintenance in
+// between.
+type maintenance struct {
+	on atomic.Bool
+	// retryAfter is how long clients are told to wait before retrying.
+	retryAfter time.Duration
+}
+
+// maintenanceRoutes are served in maintenance mode: probes, metrics and
+// profiles, and below the prefixes in maintenancePrefixes.
+var maintenanceRoutes = map[string]bool{
+	"/healthz":              true,
+	"/readyz":               true,
+	"/metrics":              true,
+	"/favicon.ico":          true,
+	"/debug/pprof/*profile": true,
+}
+
+// maintenancePrefixes start the routes of the admin endpoints, needed to
+// end maintenance.
+var maintenancePrefixes = []string{"/admin/", "/v1/admin/"}
+
+// check is a critical readiness check that fails in maintenance mode, so
+// load balancers stop routing to the instance.
+func (m *maintenance) check(context.Context) error {
+	if m.on.Load() {
+		return errMaintenance
+	}
+	return nil
+}
+
+// middleware answers requests with 503 and Retry-After in maintenance
+// mode, except for maintenanceRoutes and maintenancePrefixes.
+func (m *maintenance) middleware() gin.HandlerFunc {
+	retry := strconv.Itoa(int(math.Ceil(m.retryAfter.Seconds())))
+	return func(c *gin.Context) {
+		if m.on.Load() && !maintenanceExempt(c.FullPath()) {
+			c.Header("Retry-After", retry)
+			writeProblem(c, http.StatusServiceUnavailable, errMaintenance.Error())
+			return
+		}
+		c.Next()
+	}
+}
+
+// maintenanceExempt reports whether route is served in maintenance mode.
+func maintenanceExempt(route string) bool {
+	if maintenanceRoutes[route] {
+		return true
+	}
+	for _, p := range maintenancePrefixes {
+		if strings.HasPrefix(route, p) {
+			return true
+		}
+	}
+	return false
+}
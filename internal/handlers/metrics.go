@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// unmatchedRoute labels requests that matched no route, so arbitrary paths
+// cannot create new series.
+const unmatchedRoute = "unmatched"
+
+// httpMetrics are the request metrics collected by middleware.
+type httpMetrics struct {
+	registry *prometheus.Registry
+	// registerer registers metrics on registry under the configured
+	// namespace. Everything served on /metrics is registered through it.
+	registerer prometheus.Registerer
+	gatherer   *boundedGatherer
+
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	responseSize *prometheus.HistogramVec
+	inFlight     prometheus.Gauge
+}
+
+// newHTTPMetrics registers the request metrics, along with Go runtime and
+// process metrics, on a new registry. Every name starts with namespace and
+// an underscore when namespace is set. /metrics waits up to gatherTimeout
+// for the registry to be gathered, then falls back on metrics gathered at
+// most maxStale ago.
+func newHTTPMetrics(namespace string, gatherTimeout, maxStale time.Duration) *httpMetrics {
+	labels := []string{"route", "method", "status"}
+	m := &httpMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by route, method and status code.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of HTTP response bodies.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}, labels),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being handled.",
+		}),
+	}
+	m.registerer = m.registry
+	if namespace != "" {
+		m.registerer = prometheus.WrapRegistererWithPrefix(namespace+"_", m.registry)
+	}
+	m.registerer.MustRegister(
+		m.requests, m.duration, m.responseSize, m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.gatherer = &boundedGatherer{gatherer: m.registry, timeout: gatherTimeout, maxStale: maxStale}
+	return m
+}
+
+// middleware returns middleware that records every request except those
+// for skipPaths.
+func (m *httpMetrics) middleware(skipPaths ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(skipPaths))
+	for _, p := range skipPaths {
+		skip[p] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": c.Request.Method,
+			"status": strconv.Itoa(c.Writer.Status()),
+		}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		m.responseSize.With(labels).Observe(float64(max(c.Writer.Size(), 0)))
+	}
+}
+
+// handler serves the registry in the Prometheus exposition format. When
+// gathering times out with nothing recent enough to fall back on, it
+// answers 503 with Retry-After.
+func (m *httpMetrics) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		families, err := m.gatherer.Gather()
+		if errors.Is(err, errGatherTimeout) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(m.gatherer.timeout.Seconds()))))
+			writeProblem(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, err })
+		promhttp.HandlerFor(gathered, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// errGatherTimeout is returned by boundedGatherer.Gather when gathering
+// outlasts the timeout and no earlier result is recent enough.
+var errGatherTimeout = errors.New("gathering metrics timed out")
+
+// boundedGatherer waits at most timeout for gatherer. A gather that times
+// out carries on in the background, and scrapes meanwhile wait on it
+// rather than starting another, so a slow collector holds up at most one
+// gather however often /metrics is scraped. The last successful result is
+// kept to answer scrapes that time out, while it is at most maxStale old.
+type boundedGatherer struct {
+	gatherer prometheus.Gatherer
+	// timeout is 0 for no limit.
+	timeout  time.Duration
+	maxStale time.Duration
+
+	mu sync.Mutex
+	// running is the gather in progress, or nil.
+	running *gatherRun
+	last    []*dto.MetricFamily
+	lastAt  time.Time
+}
+
+// gatherRun is one call of the wrapped gatherer. done is closed once
+// families and err are set.
+type gatherRun struct {
+	done     chan struct{}
+	families []*dto.MetricFamily
+	err      error
+}
+
+// Gather returns the result of the gather in progress, starting one if
+// none is, or the last result while it is fresh enough if that gather
+// takes longer than the timeout.
+func (b *boundedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	if b.timeout == 0 {
+		return b.gatherer.Gather()
+	}
+	b.mu.Lock()
+	run := b.running
+	if run == nil {
+		run = &gatherRun{done: make(chan struct{})}
+		b.running = run
+		go b.gather(run)
+	}
+	b.mu.Unlock()
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case <-run.done:
+		return run.families, run.err
+	case <-timer.C:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last == nil || time.Since(b.lastAt) > b.maxStale {
+		slog.Warn("gathering metrics timed out", "timeout", b.timeout)
+		return nil, errGatherTimeout
+	}
+	slog.Warn("gathering metrics timed out; serving earlier metrics", "timeout", b.timeout, "age", time.Since(b.lastAt))
+	return b.last, nil
+}
+
+// gather runs the wrapped gatherer for run, keeping the result for later
+// scrapes if it succeeded.
+func (b *boundedGatherer) gather(run *gatherRun) {
+	run.families, run.err = b.gatherer.Gather()
+	b.mu.Lock()
+	if run.err == nil {
+		b.last, b.lastAt = run.families, time.Now()
+	}
+	b.running = nil
+	b.mu.Unlock()
+	close(run.done)
+}
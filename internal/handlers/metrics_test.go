@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsNamespace(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"METRICS_NAMESPACE": "myapp"})
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", ""), http.StatusOK)
+
+	w := ts.do(http.MethodGet, "/metrics", "")
+	wantStatus(t, w, http.StatusOK)
+	body := w.Body.String()
+	names := 0
+	lines := bufio.NewScanner(strings.NewReader(body))
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names++
+		if !strings.HasPrefix(line, "myapp_") {
+			t.Errorf("metric without the namespace: %s", line)
+		}
+	}
+	if names == 0 {
+		t.Fatal("no metrics served")
+	}
+	if !strings.Contains(body, `myapp_http_requests_total{method="GET",route="/v1/albums",status="200"} 1`) {
+		t.Errorf("no myapp_http_requests_total for the request in:\n%s", body)
+	}
+}
+
+// slowCollector collects one gauge, holding up the collection while slow
+// is set until release is closed.
+type slowCollector struct {
+	gauge   prometheus.Gauge
+	slow    atomic.Bool
+	release chan struct{}
+}
+
+func (s *slowCollector) Describe(ch chan<- *prometheus.Desc) { s.gauge.Describe(ch) }
+
+func (s *slowCollector) Collect(ch chan<- prometheus.Metric) {
+	if s.slow.Load() {
+		<-s.release
+	}
+	s.gauge.Collect(ch)
+}
+
+func TestMetricsGatherTimeout(t *testing.T) {
+	scrape := func(m *httpMetrics) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.GET("/metrics", m.handler())
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w
+	}
+	newMetrics := func(t *testing.T, maxStale time.Duration) (*httpMetrics, *slowCollector) {
+		m := newHTTPMetrics("", 50*time.Millisecond, maxStale)
+		c := &slowCollector{
+			gauge:   prometheus.NewGauge(prometheus.GaugeOpts{Name: "slow_gauge", Help: "A gauge slow to collect."}),
+			release: make(chan struct{}),
+		}
+		m.registerer.MustRegister(c)
+		t.Cleanup(func() { close(c.release) })
+		return m, c
+	}
+
+	t.Run("no earlier metrics", func(t *testing.T) {
+		m, c := newMetrics(t, time.Minute)
+		c.slow.Store(true)
+		start := time.Now()
+		w := scrape(m)
+		wantStatus(t, w, http.StatusServiceUnavailable)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("timed-out scrape took %v", elapsed)
+		}
+		if got := w.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Retry-After = %q, want 1", got)
+		}
+	})
+
+	t.Run("recent metrics", func(t *testing.T) {
+		m, c := newMetrics(t, time.Minute)
+		c.gauge.Set(1)
+		wantStatus(t, scrape(m), http.StatusOK)
+		c.gauge.Set(2)
+		c.slow.Store(true)
+		w := scrape(m)
+		wantStatus(t, w, http.StatusOK)
+		if body := w.Body.String(); !strings.Contains(body, "slow_gauge 1\n") {
+			t.Errorf("timed-out scrape did not serve the earlier metrics:\n%s", body)
+		}
+	})
+
+	t.Run("stale metrics", func(t *testing.T) {
+		m, c := newMetrics(t, 0)
+		wantStatus(t, scrape(m), http.StatusOK)
+		c.slow.Store(true)
+		wantStatus(t, scrape(m), http.StatusServiceUnavailable)
+	})
+
+	t.Run("one gather at a time", func(t *testing.T) {
+		m, c := newMetrics(t, 0)
+		c.slow.Store(true)
+		wantStatus(t, scrape(m), http.StatusServiceUnavailable)
+		m.gatherer.mu.Lock()
+		first := m.gatherer.running
+		m.gatherer.mu.Unlock()
+		wantStatus(t, scrape(m), http.StatusServiceUnavailable)
+		m.gatherer.mu.Lock()
+		second := m.gatherer.running
+		m.gatherer.mu.Unlock()
+		if first == nil || first != second {
+			t.Error("a scrape started a second gather while the first was running")
+		}
+	})
+}
+
+func TestFaviconIsNotCounted(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.do(http.MethodGet, "/favicon.ico", "")
+	wantStatus(t, w, http.StatusNoContent)
+	if w.Body.Len() != 0 {
+		t.Errorf("favicon body %q, want none", w.Body)
+	}
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", ""), http.StatusOK)
+
+	body := ts.do(http.MethodGet, "/metrics", "").Body.String()
+	if !strings.Contains(body, `route="/v1/albums"`) {
+		t.Fatalf("no request metrics for /v1/albums in:\n%s", body)
+	}
+	if strings.Contains(body, `route="/favicon.ico"`) {
+		t.Errorf("favicon requests counted in:\n%s", body)
+	}
+}
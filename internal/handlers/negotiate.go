@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// mimeMsgPack is the MessagePack media type; application/x-msgpack is
+// accepted on requests too.
+const mimeMsgPack = "application/msgpack"
+
+// offeredFormats are the media types album endpoints respond with, in order
+// of preference.
+var offeredFormats = []string{binding.MIMEJSON, binding.MIMEXML, mimeMsgPack}
+
+// respond writes obj with status in the format the Accept header prefers,
+// or responds 406 Not Acceptable if it allows none of them.
+func respond(c *gin.Context, status int, obj any) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch c.NegotiateFormat(offeredFormats...) {
+	case binding.MIMEJSON:
+		c.IndentedJSON(status, obj)
+	case binding.MIMEXML:
+		c.XML(status, obj)
+	case mimeMsgPack:
+		c.Render(status, render.MsgPack{Data: obj})
+	default:
+		writeProblem(c, http.StatusNotAcceptable, "responses are available as JSON, XML or MessagePack")
+	}
+}
+
+// isBatch reports whether the request body holds a list of albums rather
+// than one, restoring the body for decoding.
+func isBatch(c *gin.Context) bool {
+	switch c.ContentType() {
+	case binding.MIMEXML, binding.MIMEXML2:
+		var root string
+		root, c.Request.Body = sniffXMLRoot(c.Request.Body)
+		return root == "albums"
+	case mimeMsgPack, binding.MIMEMSGPACK:
+		var first byte
+		first, c.Request.Body = sniffFirst(c.Request.Body)
+		// fixarray, array 16 and array 32.
+		return first&0xf0 == 0x90 || first == 0xdc || first == 0xdd
+	}
+	var first byte
+	first, c.Request.Body = sniffJSON(c.Request.Body)
+	return first == '['
+}
+
+// albumList is a list of albums that is one <albums> element in XML
+// instead of a run of <album> elements.
+type albumList []album
+
+type xmlAlbums struct {
+	Albums []album `xml:"album"`
+}
+
+func (l albumList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "albums"
+	return e.EncodeElement(xmlAlbums{Albums: l}, start)
+}
+
+func (l *albumList) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v xmlAlbums
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*l = v.Albums
+	return nil
+}
+
+// sniffXMLRoot returns the name of the root element of an XML body, or ""
+// if it does not start within sniffLimit bytes, together with a
+// replacement body that still yields every byte of the original.
+func sniffXMLRoot(body io.ReadCloser) (string, io.ReadCloser) {
+	peek, rc := peekBody(body)
+	d := xml.NewDecoder(bytes.NewReader(peek))
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return "", rc
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, rc
+		}
+	}
+}
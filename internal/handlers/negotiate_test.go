@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestAlbumsInXMLAndMessagePack(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	w := ts.do(http.MethodGet, "/v1/albums/1", "", "Accept", "application/xml")
+	wantStatus(t, w, http.StatusOK)
+	var got album
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Title != "Blue Train" {
+		t.Errorf("XML album %s (%v), want Blue Train", w.Body, err)
+	}
+
+	w = ts.do(http.MethodGet, "/v1/albums/2", "", "Accept", mimeMsgPack)
+	wantStatus(t, w, http.StatusOK)
+	got = album{}
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), new(codec.MsgpackHandle)).Decode(&got); err != nil || got.Title != "Jeru" {
+		t.Errorf("MessagePack album %+v (%v), want Jeru", got, err)
+	}
+
+	// Bodies may be sent in either format too.
+	req := httptest.NewRequest(http.MethodPost, "/v1/albums", strings.NewReader(
+		`<album><title>Kind of Blue</title><artist>Miles Davis</artist><price>9.99</price></album>`))
+	req.Header.Set("Content-Type", "application/xml")
+	rec := httptest.NewRecorder()
+	ts.router.ServeHTTP(rec, req)
+	wantStatus(t, rec, http.StatusCreated)
+
+	var body []byte
+	codec.NewEncoderBytes(&body, new(codec.MsgpackHandle)).Encode(map[string]any{"title": "Giant Steps", "artist": "John Coltrane", "price": 11.5})
+	req = httptest.NewRequest(http.MethodPost, "/v1/albums", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", mimeMsgPack)
+	rec = httptest.NewRecorder()
+	ts.router.ServeHTTP(rec, req)
+	wantStatus(t, rec, http.StatusCreated)
+}
+
+func TestNegotiationFailures(t *testing.T) {
+	ts := newTestServer(t, nil)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums/1", "", "Accept", "image/png"), http.StatusNotAcceptable)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/albums", strings.NewReader("title=Kind of Blue"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	wantStatus(t, w, http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"pspFileAPI/internal/config"
+)
+
+// oidcCookie holds the state, nonce and PKCE verifier of a login between
+// /auth/login and /auth/callback.
+const oidcCookie = "oidc_login"
+
+// oidcLoginTTL is how long a login started at /auth/login may take.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLogin logs users in through an external OpenID Connect provider,
+// such as Google or Keycloak, and issues them the same tokens as
+// POST /login.
+type oidcLogin struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	jwt      *jwtAuth
+	// client makes the calls to the provider.
+	client *http.Client
+	// usernameClaim is the ID token claim that names the local user.
+	usernameClaim string
+	// rolesClaim, if set, is the ID token claim listing the user's roles
+	// at the provider. Assignments in the role store take precedence.
+	rolesClaim string
+}
+
+// newOIDCLogin discovers the provider at cfg.OIDCIssuer, calling it with
+// client.
+func newOIDCLogin(ctx context.Context, cfg config.Config, jwt *jwtAuth, client *http.Client) (*oidcLogin, error) {
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(ctx, client), 10*time.Second)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+	return &oidcLogin{
+		oauth: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       cfg.OIDCScopes,
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+		jwt:           jwt,
+		client:        client,
+		usernameClaim: cfg.OIDCUsernameClaim,
+		rolesClaim:    cfg.OIDCRolesClaim,
+	}, nil
+}
+
+// login redirects to the provider's authorization page. The state, nonce
+// and PKCE verifier go in a short-lived cookie for the callback to check.
+func (o *oidcLogin) login(c *gin.Context) {
+	state, nonce, verifier := randomHex(16), randomHex(16), oauth2.GenerateVerifier()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     "/",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusFound, o.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+}
+
+// callback completes a login: it checks the state against the cookie set
+// by login, exchanges the code for tokens, verifies the ID token and its
+// nonce, and responds with a local token for the user it names.
+func (o *oidcLogin) callback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		detail := "login refused by provider: " + e
+		if desc := c.Query("error_description"); desc != "" {
+			detail += ": " + desc
+		}
+		writeProblem(c, http.StatusUnauthorized, detail)
+		return
+	}
+
+	raw, err := c.Cookie(oidcCookie)
+	http.SetCookie(c.Writer, &http.Cookie{Name: oidcCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	parts := strings.Split(raw, ".")
+	if err != nil || len(parts) != 3 {
+		writeProblem(c, http.StatusBadRequest, "no login in progress; start at /auth/login")
+		return
+	}
+	state, nonce, verifier := parts[0], parts[1], parts[2]
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(state)) != 1 {
+		writeProblem(c, http.StatusBadRequest, "state does not match the login in progress")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		writeProblem(c, http.StatusBadRequest, "invalid callback", fieldError{Field: "code", Message: "is required"})
+		return
+	}
+
+	ctx := oidc.ClientContext(c.Request.Context(), o.client)
+	tok, err := o.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		slog.WarnContext(ctx, "OIDC code exchange failed", "err", err)
+		writeProblem(c, http.StatusBadGateway, "could not exchange the authorization code")
+		return
+	}
+	rawID, ok := tok.Extra("id_token").(string)
+	if !ok {
+		writeProblem(c, http.StatusBadGateway, "provider returned no ID token")
+		return
+	}
+	idToken, err := o.verifier.Verify(ctx, rawID)
+	if err != nil {
+		slog.WarnContext(ctx, "OIDC ID token rejected", "err", err)
+		writeProblem(c, http.StatusUnauthorized, "invalid ID token")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		writeProblem(c, http.StatusUnauthorized, "ID token nonce does not match the login in progress")
+		return
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		writeProblem(c, http.StatusBadGateway, "could not read ID token claims")
+		return
+	}
+	subject, err := o.username(claims)
+	if err != nil {
+		writeProblem(c, http.StatusForbidden, err.Error())
+		return
+	}
+	addLogField(ctx, "user", subject)
+	o.jwt.respondToken(c, subject, o.roleOf(subject, claims))
+}
+
+// username returns the local name of the user the claims describe. An
+// email is only taken once the provider has verified it, and names that
+// look like API key subjects are refused.
+func (o *oidcLogin) username(claims map[string]any) (string, error) {
+	name, _ := claims[o.usernameClaim].(string)
+	if name == "" {
+		return "", fmt.Errorf("ID token has no %s claim", o.usernameClaim)
+	}
+	if strings.HasPrefix(name, keySubjectPrefix) {
+		return "", fmt.Errorf("ID token %s claim %q is reserved for API keys", o.usernameClaim, name)
+	}
+	if o.usernameClaim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", errors.New("email address is not verified by the provider")
+		}
+	}
+	return name, nil
+}
+
+// roleOf returns subject's role: its assignment in the role store if it
+// has one, else the highest role the roles claim lists, else roleReadonly,
+// since anyone with an account at the provider may log in.
+func (o *oidcLogin) roleOf(subject string, claims map[string]any) role {
+	if r, ok := o.jwt.roles.lookup(subject); ok {
+		return r
+	}
+	best := roleReadonly
+	if o.rolesClaim == "" {
+		return best
+	}
+	var listed []any
+	switch v := claims[o.rolesClaim].(type) {
+	case []any:
+		listed = v
+	case string:
+		listed = []any{v}
+	}
+	found := false
+	for _, v := range listed {
+		s, _ := v.(string)
+		r, err := parseRole(s)
+		if err != nil {
+			continue
+		}
+		if !found || roleRank[r] > roleRank[best] {
+			best, found = r, true
+		}
+	}
+	return best
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import "testing"
+
+func TestOIDCUsername(t *testing.T) {
+	o := &oidcLogin{usernameClaim: "email"}
+	for _, c := range []struct {
+		claims map[string]any
+		want   string
+	}{
+		{map[string]any{"email": "ann@example.com", "email_verified": true}, "ann@example.com"},
+		{map[string]any{"email": "ann@example.com", "email_verified": false}, ""},
+		{map[string]any{"email": "ann@example.com"}, ""},
+		{map[string]any{"email": "ann@example.com", "email_verified": "true"}, ""},
+		{map[string]any{"email": "key:ci", "email_verified": true}, ""},
+		{map[string]any{"email_verified": true}, ""},
+	} {
+		got, err := o.username(c.claims)
+		if got != c.want || (err == nil) != (c.want != "") {
+			t.Errorf("username(%v) = %q, %v; want %q", c.claims, got, err, c.want)
+		}
+	}
+
+	// Other claims need no verification.
+	o.usernameClaim = "preferred_username"
+	if got, err := o.username(map[string]any{"preferred_username": "ann"}); got != "ann" || err != nil {
+		t.Errorf("username by preferred_username = %q, %v; want ann", got, err)
+	}
+}
+
+func TestOIDCRoleOf(t *testing.T) {
+	roles, err := newRoleStore("ann:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &oidcLogin{jwt: &jwtAuth{roles: roles}, rolesClaim: "groups"}
+	for _, c := range []struct {
+		subject string
+		claims  map[string]any
+		want    role
+	}{
+		{"ann", map[string]any{"groups": []any{"readonly"}}, roleAdmin},
+		{"bob", map[string]any{}, roleReadonly},
+		{"bob", map[string]any{"groups": []any{"staff", "sales"}}, roleReadonly},
+		{"bob", map[string]any{"groups": []any{"user", "readonly"}}, roleUser},
+		{"bob", map[string]any{"groups": "admin"}, roleAdmin},
+	} {
+		if got := o.roleOf(c.subject, c.claims); got != c.want {
+			t.Errorf("roleOf(%s, %v) = %s, want %s", c.subject, c.claims, got, c.want)
+		}
+	}
+
+	o.rolesClaim = ""
+	if got := o.roleOf("bob", map[string]any{"groups": []any{"admin"}}); got != roleReadonly {
+		t.Errorf("roleOf without OIDC_ROLES_CLAIM = %s, want readonly", got)
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes every endpoint. Keep it in step with the routes
+// registered in run.
+//
+//go:embed openapi/openapi.json
+var openAPISpec []byte
+
+// docsPage renders openAPISpec with Swagger UI.
+//
+//go:embed openapi/docs.html
+var docsPage []byte
+
+// getOpenAPI responds with the OpenAPI document.
+func getOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+// getDocs responds with the interactive API documentation.
+func getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/repository"
+)
+
+// Page sizes for list endpoints.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+	// maxPage keeps the offset of a page within an int32, which every store
+	// takes.
+	maxPage = math.MaxInt32 / maxPageLimit
+)
+
+// albumPage is the envelope list endpoints respond with.
+type albumPage struct {
+	XMLName xml.Name  `json:"-" xml:"page"`
+	Data    albumList `json:"data" xml:"albums"`
+	Total   int       `json:"total" xml:"total"`
+	Page    int       `json:"page" xml:"page_number"`
+	Limit   int       `json:"limit" xml:"limit"`
+	Links   pageLinks `json:"links" xml:"links"`
+}
+
+// pageLinks point to the neighbouring pages, when there are any.
+type pageLinks struct {
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
+	Prev string `json:"prev,omitempty" xml:"prev,omitempty"`
+}
+
+// listParams is a list request made through the gRPC or GraphQL API.
+// Zero values are left unset.
+type listParams struct {
+	page, limit         int
+	sort, artist, title string
+	minPrice, maxPrice  *float64
+}
+
+// values returns p as query parameters, so parseListQuery checks it the
+// same way as a request to GET /albums.
+func (p listParams) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			v.Set(key, value)
+		}
+	}
+	set("page", strconv.Itoa(p.page))
+	set("limit", strconv.Itoa(p.limit))
+	set("sort", p.sort)
+	set("artist", p.artist)
+	set("title", p.title)
+	if p.minPrice != nil {
+		v.Set("min_price", strconv.FormatFloat(*p.minPrice, 'g', -1, 64))
+	}
+	if p.maxPrice != nil {
+		v.Set("max_price", strconv.FormatFloat(*p.maxPrice, 'g', -1, 64))
+	}
+	return v
+}
+
+// parseListQuery reads the page, limit, sort, filter and include_deleted
+// query parameters.
+// It returns the page number along with the query, or the parameters that
+// are invalid.
+func parseListQuery(v url.Values) (repository.ListQuery, int, []fieldError) {
+	q := repository.ListQuery{Artist: v.Get("artist"), Title: v.Get("title"), Limit: defaultPageLimit}
+	var errs []fieldError
+
+	page := 1
+	if s := v.Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPage {
+			errs = append(errs, fieldError{Field: "page", Message: "must be between 1 and %d", args: []any{maxPage}})
+			n = 1
+		}
+		page = n
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageLimit {
+			errs = append(errs, fieldError{Field: "limit", Message: "must be between 1 and %d", args: []any{maxPageLimit}})
+		}
+		q.Limit = n
+	}
+	q.Offset = (page - 1) * q.Limit
+
+	if s := v.Get("sort"); s != "" {
+		field, dir, _ := strings.Cut(s, ":")
+		if _, ok := repository.SortFields[field]; !ok {
+			errs = append(errs, fieldError{Field: "sort", Message: "must be one of id, title, artist or price"})
+		}
+		switch dir {
+		case "", "asc":
+		case "desc":
+			q.Desc = true
+		default:
+			errs = append(errs, fieldError{Field: "sort", Message: "order must be asc or desc"})
+		}
+		q.Sort = field
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **float64
+	}{
+		{"min_price", &q.MinPrice},
+		{"max_price", &q.MaxPrice},
+	} {
+		if s := v.Get(p.name); s != "" {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				errs = append(errs, fieldError{Field: p.name, Message: "must be a number"})
+			}
+			*p.dst = &f
+		}
+	}
+	if s := v.Get("include_deleted"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "include_deleted", Message: "must be true or false"})
+		}
+		q.IncludeDeleted = b
+	}
+	return q, page, errs
+}
+
+// pageLink returns the request's URL with page set to n.
+func pageLink(c *gin.Context, n int) string {
+	u := *c.Request.URL
+	v := u.Query()
+	v.Set("page", strconv.Itoa(n))
+	u.RawQuery = v.Encode()
+	return u.RequestURI()
+}
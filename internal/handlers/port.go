@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetBindService is the capability bit that allows binding ports below
+// the unprivileged port range.
+const capNetBindService = 10
+
+// privilegedPortWarning explains why binding port is likely to fail, or
+// returns "" when the process should be able to bind it. unprivilegedStart
+// is the first port the kernel lets any process bind.
+func privilegedPortWarning(port, unprivilegedStart int, canBind bool) string {
+	if port >= unprivilegedStart || canBind {
+		return ""
+	}
+	return fmt.Sprintf("APP_PORT %d is a privileged port (below %d) and this process "+
+		"lacks CAP_NET_BIND_SERVICE; binding will fail. Grant the capability "+
+		"(e.g. setcap cap_net_bind_service=+ep) or use a port >= %d",
+		port, unprivilegedStart, unprivilegedStart)
+}
+
+// unprivilegedPortStart reports the first unprivileged port, honoring the
+// Linux net.ipv4.ip_unprivileged_port_start sysctl when it is available.
+func unprivilegedPortStart() int {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return 1024
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 1024
+	}
+	return n
+}
+
+// canBindPrivileged reports whether the process holds CAP_NET_BIND_SERVICE.
+// Where the effective capability set cannot be read, running as root is
+// taken to be sufficient.
+func canBindPrivileged() bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return os.Geteuid() == 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hex, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+		if err != nil {
+			break
+		}
+		return caps&(1<<capNetBindService) != 0
+	}
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/i18n"
+)
+
+// problemContentType is the media type of RFC 7807 error bodies.
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 problem details body.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// writeProblem aborts the request with a problem+json response for status
+// explaining detail. errs lists invalid request fields, if any. The title,
+// detail and field messages are translated into the request's language.
+func writeProblem(c *gin.Context, status int, detail string, errs ...fieldError) {
+	lang := i18n.FromContext(c.Request.Context())
+	c.Abort()
+	c.Header("Content-Type", problemContentType)
+	c.Header("Content-Language", lang.String())
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	c.IndentedJSON(status, problem{
+		Type:      "about:blank",
+		Title:     i18n.T(lang, http.StatusText(status)),
+		Status:    status,
+		Detail:    i18n.T(lang, detail),
+		Instance:  c.Request.URL.Path,
+		RequestID: requestIDFrom(c.Request.Context()),
+		Errors:    translateFields(lang, errs),
+	})
+}
+
+// noRoute answers requests that match no route. Browsers navigating
+// within the demo page get the page instead.
+func noRoute(c *gin.Context) {
+	if wantsPage(c) {
+		getIndex(c)
+		return
+	}
+	writeProblem(c, http.StatusNotFound, tr(c, "no route matches %s", c.Request.URL.Path))
+}
+
+// methodNotAllowed answers requests whose path is routed for other methods
+// only, listing those methods in the Allow header.
+func methodNotAllowed(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var allow []string
+		for _, r := range router.Routes() {
+			if matchRoute(r.Path, c.Request.URL.Path) && !slices.Contains(allow, r.Method) {
+				allow = append(allow, r.Method)
+			}
+		}
+		slices.Sort(allow)
+		c.Header("Allow", strings.Join(allow, ", "))
+		writeProblem(c, http.StatusMethodNotAllowed, tr(c, "%s is not allowed on %s", c.Request.Method, c.Request.URL.Path))
+	}
+}
+
+// matchRoute reports whether path matches the gin route pattern, where
+// :name matches one segment and *name the rest of the path.
+func matchRoute(pattern, path string) bool {
+	want, got := strings.Split(pattern, "/"), strings.Split(path, "/")
+	for i, seg := range want {
+		if strings.HasPrefix(seg, "*") {
+			return true
+		}
+		if i >= len(got) || seg != got[i] && (!strings.HasPrefix(seg, ":") || got[i] == "") {
+			return false
+		}
+	}
+	return len(want) == len(got)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamProxy forwards GET /upstreams/{name}/{path} to the upstream API
+// called name, each behind its own circuit breaker.
+type upstreamProxy struct {
+	upstreams map[string]*upstream
+
+	calls    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	state    *prometheus.GaugeVec
+}
+
+// upstream is one API the proxy forwards to.
+type upstream struct {
+	proxy   *httputil.ReverseProxy
+	breaker *circuitBreaker
+}
+
+// Headers carrying the caller's credentials for this API, which upstreams
+// must not see.
+var credentialHeaders = []string{"Authorization", "Cookie", apiKeyHeader, csrfHeader}
+
+// newUpstreamProxy returns a proxy to the name=URL pairs in spec, calling
+// them through transport and opening an upstream's breaker after failures
+// consecutive failures for delay. Its metrics are registered on reg.
+func newUpstreamProxy(spec string, transport http.RoundTripper, failures int, delay time.Duration, reg prometheus.Registerer) (*upstreamProxy, error) {
+	p := &upstreamProxy{
+		upstreams: make(map[string]*upstream),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_requests_total",
+			Help: "Proxied upstream calls, retries included, by upstream and result: success, failure or rejected by the open breaker.",
+		}, []string{"upstream", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Time taken by proxied upstream calls.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"upstream"}),
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "upstream_breaker_state",
+			Help: "State of each upstream's circuit breaker: 0 closed, 1 half-open, 2 open.",
+		}, []string{"upstream"}),
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("UPSTREAMS entry %q must look like \"name=URL\"", entry)
+		}
+		target, err := url.Parse(raw)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, fmt.Errorf("UPSTREAMS entry for %q: %q must be an http or https URL", name, raw)
+		}
+		if _, dup := p.upstreams[name]; dup {
+			return nil, fmt.Errorf("UPSTREAMS names %q twice", name)
+		}
+		p.upstreams[name] = p.newUpstream(name, target, transport, failures, delay)
+		p.state.WithLabelValues(name).Set(float64(breakerClosed))
+	}
+	reg.MustRegister(p.calls, p.duration, p.state)
+	return p, nil
+}
+
+func (p *upstreamProxy) newUpstream(name string, target *url.URL, transport http.RoundTripper, failures int, delay time.Duration) *upstream {
+	u := &upstream{
+		breaker: newCircuitBreaker(failures, delay, func(s breakerState) {
+			slog.Warn("upstream circuit breaker changed", "upstream", name, "state", s.String())
+			p.state.WithLabelValues(name).Set(float64(s))
+		}),
+	}
+	u.proxy = &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			for _, h := range credentialHeaders {
+				r.Out.Header.Del(h)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return &upstreamStatusError{resp.StatusCode}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			c := r.Context().Value(proxyContextKey{}).(*gin.Context)
+			var se *upstreamStatusError
+			switch {
+			case errors.As(err, &se):
+				writeProblem(c, http.StatusBadGateway, tr(c, "upstream %s answered %d", name, se.status))
+			case errors.Is(err, context.DeadlineExceeded):
+				writeProblem(c, http.StatusGatewayTimeout, tr(c, "upstream %s did not answer in time", name))
+			default:
+				slog.WarnContext(r.Context(), "upstream call failed", "upstream", name, "err", err)
+				writeProblem(c, http.StatusBadGateway, tr(c, "upstream %s is unreachable", name))
+			}
+		},
+	}
+	return u
+}
+
+// upstreamStatusError is a server error answered by an upstream, counted
+// as a failure by its breaker.
+type upstreamStatusError struct{ status int }
+
+func (e *upstreamStatusError) Error() string {
+	return "upstream answered " + strconv.Itoa(e.status)
+}
+
+// proxyContextKey carries the gin context to the reverse proxy's error
+// handler.
+type proxyContextKey struct{}
+
+// getUpstream handles GET /upstreams/:name/*path, answering 503 with
+// Retry-After while the upstream's breaker is open.
+func (p *upstreamProxy) getUpstream(c *gin.Context) {
+	name := c.Param("name")
+	u, ok := p.upstreams[name]
+	if !ok {
+		writeProblem(c, http.StatusNotFound, "upstream not found")
+		return
+	}
+	report, wait := u.breaker.allow()
+	if report == nil {
+		p.calls.WithLabelValues(name, "rejected").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeProblem(c, http.StatusServiceUnavailable, tr(c, "upstream %s is unavailable", name))
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), proxyContextKey{}, c)
+	req := c.Request.Clone(ctx)
+	req.URL.Path, req.URL.RawPath = c.Param("path"), ""
+
+	start := time.Now()
+	u.proxy.ServeHTTP(c.Writer, req)
+	p.duration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+	// A caller hanging up says nothing about the upstream.
+	failed := c.Writer.Status() >= http.StatusInternalServerError && c.Request.Context().Err() == nil
+	report(!failed)
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+	p.calls.WithLabelValues(name, result).Inc()
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a client's bucket is kept after its last request.
+const limiterIdle = 10 * time.Minute
+
+// clientLimiter is the token bucket of one client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per client. A zero limit lets every
+// request through.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+	// keys, when set, are the API keys callers get a bucket of their own
+	// by.
+	keys *apiKeyStore
+}
+
+// newRateLimiter returns a limiter allowing each client perSecond requests
+// per second on average and bursts of up to burst requests.
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:     rate.Limit(perSecond),
+		burst:     burst,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// setLimit changes the rate and burst of every client, keeping the tokens
+// they have left.
+func (l *rateLimiter) setLimit(perSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit, l.burst = rate.Limit(perSecond), burst
+	now := time.Now()
+	for _, c := range l.clients {
+		c.limiter.SetLimitAt(now, l.limit)
+		c.limiter.SetBurstAt(now, burst)
+	}
+}
+
+// get returns the bucket for key, creating it if needed, and drops buckets
+// of clients that have gone quiet. It returns nil while limiting is off.
+func (l *rateLimiter) get(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit == 0 {
+		return nil
+	}
+
+	if now.Sub(l.lastSweep) > limiterIdle {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// clientKey identifies the caller: by API key when it sends one of keys,
+// otherwise by IP address. Unknown keys count against the IP, so they
+// cannot be varied to get fresh buckets.
+func clientKey(c *gin.Context, keys *apiKeyStore) string {
+	if key := c.GetHeader(apiKeyHeader); key != "" && keys.known(key) {
+		return "key:" + hashAPIKey(key)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// middleware returns middleware that answers 429 with Retry-After once a
+// client's bucket is empty, and reports the client's budget in
+// X-RateLimit-* headers on every response.
+func (l *rateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		lim := l.get(clientKey(c, l.keys), now)
+		if lim == nil {
+			c.Next()
+			return
+		}
+
+		r := lim.ReserveN(now, 1)
+		delay := r.DelayFrom(now)
+		if delay > 0 {
+			r.CancelAt(now)
+		}
+
+		tokens := math.Max(lim.TokensAt(now), 0)
+		reset := time.Duration((float64(lim.Burst()) - tokens) / float64(lim.Limit()) * float64(time.Second))
+		h := c.Writer.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(lim.Burst()))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if delay > 0 {
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeProblem(c, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimitPerClient(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"RATE_LIMIT":     "0.01",
+		"RATE_BURST":     "2",
+		"API_KEY_HASHES": "ci:" + hashAPIKey("ci-key"),
+	})
+	for remaining := 1; remaining >= 0; remaining-- {
+		w := ts.do(http.MethodGet, "/v1/albums", "")
+		wantStatus(t, w, http.StatusOK)
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("X-RateLimit-Limit %q, want 2", got)
+		}
+		if got, want := w.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(remaining); got != want {
+			t.Errorf("X-RateLimit-Remaining %q, want %s", got, want)
+		}
+	}
+
+	w := ts.do(http.MethodGet, "/v1/albums", "")
+	wantStatus(t, w, http.StatusTooManyRequests)
+	if got := w.Header().Get("Retry-After"); got == "" || got == "0" {
+		t.Errorf("Retry-After %q on a 429, want the seconds to wait", got)
+	}
+	if w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Error("429 without X-RateLimit-Reset")
+	}
+
+	// A known API key has a bucket of its own; an unknown one counts
+	// against the IP.
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", apiKeyHeader, "ci-key"), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", apiKeyHeader, "made-up"), http.StatusTooManyRequests)
+}
+
+func TestRateLimitOff(t *testing.T) {
+	ts := newTestServer(t, nil)
+	for i := 0; i < 20; i++ {
+		w := ts.do(http.MethodGet, "/v1/albums", "")
+		wantStatus(t, w, http.StatusOK)
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "" {
+			t.Fatalf("X-RateLimit-Limit %q without RATE_LIMIT", got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// role is what an authenticated caller may do. Each role may do everything
+// the ones before it may.
+type role string
+
+const (
+	// roleReadonly may read webhooks and deliveries but change nothing.
+	roleReadonly role = "readonly"
+	// roleUser may change albums and webhooks. Callers without an
+	// assignment get it.
+	roleUser role = "user"
+	// roleAdmin may also use the /admin endpoints.
+	roleAdmin role = "admin"
+)
+
+var roleRank = map[role]int{roleReadonly: 1, roleUser: 2, roleAdmin: 3}
+
+// parseRole returns the role named s.
+func parseRole(s string) (role, error) {
+	r := role(s)
+	if _, ok := roleRank[r]; !ok {
+		return "", fmt.Errorf("role %q must be readonly, user or admin", s)
+	}
+	return r, nil
+}
+
+// atLeast reports whether r may do what min may.
+func (r role) atLeast(min role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// accessPolicy is the least role each authenticated route needs, keyed by
+// method and route without the version prefix. Routes missing from it
+// need roleAdmin, so a new route is closed until it is listed.
+var accessPolicy = map[string]role{
+	"POST /albums":         roleUser,
+	"POST /albums/batch":   roleUser,
+	"PUT /albums/:id":      roleUser,
+	"DELETE /albums/:id":   roleUser,
+	"POST /upload":         roleUser,
+	"POST /webhooks":       roleUser,
+	"GET /webhooks":        roleReadonly,
+	"DELETE /webhooks/:id": roleUser,
+
+	"POST /albums/:id/restore":                       roleUser,
+	"GET /webhooks/:id/deliveries":                   roleReadonly,
+	"POST /webhooks/:id/deliveries/:delivery/replay": roleUser,
+}
+
+// requiredRole returns the role accessPolicy asks of the request's route.
+func requiredRole(c *gin.Context) role {
+	route := strings.TrimPrefix(c.FullPath(), "/v1")
+	if r, ok := accessPolicy[c.Request.Method+" "+route]; ok {
+		return r
+	}
+	return roleAdmin
+}
+
+// enforcePolicy returns middleware that responds 403 when the caller's
+// role is below what accessPolicy asks of the route. It must run after
+// requireAuth.
+func enforcePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		need := requiredRole(c)
+		if id := identityFrom(c.Request.Context()); id == nil || !id.role.atLeast(need) {
+			writeProblem(c, http.StatusForbidden, tr(c, "this request needs the %s role", need))
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin returns middleware admitting requests whose X-Admin-Token
+// header equals the current token, or, without that header, callers that
+// authenticate through one of auths with the admin role. Either way the
+// caller's identity goes in the request context.
+func requireAdmin(token *secretValue, auths []authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var id *identity
+		if sent := c.GetHeader("X-Admin-Token"); sent != "" || len(auths) == 0 {
+			if token == nil || subtle.ConstantTimeCompare([]byte(sent), token.load()) != 1 {
+				writeProblem(c, http.StatusUnauthorized, "invalid admin token")
+				return
+			}
+			id = &identity{subject: "admin-token", method: "admin_token", role: roleAdmin}
+		} else {
+			var err error
+			if id, err = authenticateAny(c.Request, auths); err != nil {
+				rejectAuth(c, auths, err)
+				return
+			}
+			if !id.role.atLeast(roleAdmin) {
+				writeProblem(c, http.StatusForbidden, "this request needs the admin role")
+				return
+			}
+		}
+		ctx := context.WithValue(c.Request.Context(), identityKey{}, id)
+		c.Request = c.Request.WithContext(ctx)
+		addLogField(ctx, "user", id.subject)
+		c.Next()
+	}
+}
+
+// roleAssignment gives a user or API key name a role.
+type roleAssignment struct {
+	Subject string `json:"subject"`
+	Role    role   `json:"role"`
+}
+
+// roleStore holds the role of each user and API key name that has been
+// assigned one.
+type roleStore struct {
+	mu    sync.RWMutex
+	roles map[string]role
+}
+
+// newRoleStore returns a store preloaded from spec, a comma-separated list
+// of "name:role" entries, where API keys are named "key:name".
+func newRoleStore(spec string) (*roleStore, error) {
+	s := &roleStore{roles: make(map[string]role)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, r, ok := cutLast(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("AUTH_ROLES entry %q must look like \"name:role\"", entry)
+		}
+		parsed, err := parseRole(r)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_ROLES entry for %q: %w", name, err)
+		}
+		s.roles[name] = parsed
+	}
+	return s, nil
+}
+
+// roleOf returns subject's role, roleUser if it has none assigned.
+func (s *roleStore) roleOf(subject string) role {
+	if r, ok := s.lookup(subject); ok {
+		return r
+	}
+	return roleUser
+}
+
+// lookup returns subject's assigned role, reporting whether it has one.
+func (s *roleStore) lookup(subject string) (role, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.roles[subject]
+	return r, ok
+}
+
+// assign gives subject role r.
+func (s *roleStore) assign(subject string, r role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[subject] = r
+}
+
+// list returns every assignment, by subject.
+func (s *roleStore) list() []roleAssignment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]roleAssignment, 0, len(s.roles))
+	for subject, r := range s.roles {
+		list = append(list, roleAssignment{Subject: subject, Role: r})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return list
+}
+
+// getRoles responds with every role assignment.
+func (s *roleStore) getRoles(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, s.list())
+}
+
+// putRole assigns the role in the request body to the subject parameter.
+// Users get it on their next login and API keys on their next request.
+func (s *roleStore) putRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required,oneof=readonly user admin"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	a := roleAssignment{Subject: c.Param("subject"), Role: role(req.Role)}
+	s.assign(a.Subject, a.Role)
+	c.IndentedJSON(http.StatusOK, a)
+}
+
+// deleteRole removes the subject parameter's assignment, returning it to
+// roleUser.
+func (s *roleStore) deleteRole(c *gin.Context) {
+	subject := c.Param("subject")
+	s.mu.Lock()
+	_, ok := s.roles[subject]
+	delete(s.roles, subject)
+	s.mu.Unlock()
+	if !ok {
+		writeProblem(c, http.StatusNotFound, "role assignment not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recovery returns middleware that turns a panic in a later handler into a
+// logged stack trace and a 500 problem+json response, keeping the server
+// alive.
+func recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// net/http uses this panic to abort a response deliberately.
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+
+			ctx := c.Request.Context()
+			if err, ok := r.(error); ok && clientGone(err) {
+				slog.WarnContext(ctx, "client went away", "err", err)
+				c.Abort()
+				return
+			}
+
+			slog.ErrorContext(ctx, "panic handling request", "panic", r, "stack", string(debug.Stack()))
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			writeProblem(c, http.StatusInternalServerError, "internal error")
+		}()
+		c.Next()
+	}
+}
+
+// clientGone reports whether err means the connection was closed by the
+// client, so no response can be delivered.
+func clientGone(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var sysErr *os.SyscallError
+	return errors.As(opErr, &sysErr) && (errors.Is(sysErr, syscall.EPIPE) || errors.Is(sysErr, syscall.ECONNRESET))
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/flags"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// reloader re-reads the configuration while the server runs and applies
+// the settings tagged reload:"live".
+type reloader struct {
+	load func() (config.Config, error)
+	// mu guards current, which only reload changes.
+	mu      sync.RWMutex
+	current config.Config
+	limiter *rateLimiter
+	flags   *flags.Set
+	// maintenance is switched when MAINTENANCE changes.
+	maintenance *maintenance
+	// Secrets in use, nil for features that are off.
+	signingSecret, jwtSecret, adminToken *secretValue
+}
+
+// refreshTrigger names reloads done every SECRETS_REFRESH.
+const refreshTrigger = "secrets refresh"
+
+// watch reloads the configuration whenever the process receives SIGHUP,
+// the config file's modification time changes or SECRETS_REFRESH passes,
+// until ctx is done.
+func (r *reloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	tick := time.NewTicker(configPollInterval)
+	defer tick.Stop()
+	var refresh <-chan time.Time
+	if d := r.current.SecretsRefresh; d > 0 {
+		t := time.NewTicker(d)
+		defer t.Stop()
+		refresh = t.C
+	}
+
+	modified := modTime(r.current.File())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-refresh:
+			r.reload(refreshTrigger)
+		case <-tick.C:
+			if m := modTime(r.current.File()); !m.Equal(modified) {
+				modified = m
+				r.reload(r.current.File() + " changed")
+			}
+		}
+	}
+}
+
+// modTime returns when the file at path was last modified, or the zero
+// time if it does not exist.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reload loads the configuration, logs each live setting that changed and
+// applies it. Other changes, and secrets turning a feature on or off, are
+// logged by name only and wait for a restart. Secret values are never
+// logged. An invalid configuration is logged and the current settings are
+// kept.
+func (r *reloader) reload(trigger string) {
+	next, err := r.load()
+	if err != nil {
+		slog.Error("config reload failed; keeping current settings", "trigger", trigger, "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed, restart := r.current.Update(next)
+	if len(restart) > 0 {
+		slog.Warn("config changes need a restart to take effect", "settings", restart)
+	}
+	if len(changed) == 0 {
+		level := slog.LevelInfo
+		if trigger == refreshTrigger {
+			level = slog.LevelDebug
+		}
+		slog.Log(context.Background(), level, "config reloaded; no live settings changed", "trigger", trigger)
+		return
+	}
+	r.apply(changed)
+	slog.Info("config reloaded", "trigger", trigger, "changed", changed)
+}
+
+// apply puts the current live settings into effect. The log level, flags
+// and maintenance mode are only replaced when LOG_LEVEL, FEATURE_FLAGS or
+// MAINTENANCE are among changed, so what /admin set lasts until then.
+func (r *reloader) apply(changed []string) {
+	if slices.Contains(changed, "LOG_LEVEL") {
+		level, _ := config.ParseLogLevel(r.current.LogLevel) // checked by validate
+		logLevel.Set(level)
+	}
+	if slices.Contains(changed, "FEATURE_FLAGS") {
+		parsed, _ := flags.Parse(r.current.FeatureFlags) // checked by validate
+		r.flags.Replace(parsed)
+	}
+	if slices.Contains(changed, "MAINTENANCE") {
+		r.maintenance.on.Store(r.current.Maintenance)
+	}
+	r.limiter.setLimit(r.current.RateLimit, r.current.Burst())
+	for _, s := range []struct {
+		v     *secretValue
+		value string
+	}{
+		{r.signingSecret, r.current.ResponseSigningSecret},
+		{r.jwtSecret, r.current.JWTSecret},
+		{r.adminToken, r.current.AdminToken},
+	} {
+		if s.v != nil {
+			s.v.store(s.value)
+		}
+	}
+}
+
+// config returns the settings in effect.
+func (r *reloader) config() config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// secretValue is a credential that can be replaced while the server runs.
+type secretValue struct {
+	v atomic.Pointer[[]byte]
+}
+
+// newSecretValue returns a secretValue holding s.
+func newSecretValue(s string) *secretValue {
+	sv := &secretValue{}
+	sv.store(s)
+	return sv
+}
+
+// load returns the current value.
+func (s *secretValue) load() []byte {
+	return *s.v.Load()
+}
+
+// store replaces the value.
+func (s *secretValue) store(v string) {
+	b := []byte(v)
+	s.v.Store(&b)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiRoutes holds the handlers that make up the versioned API.
+type apiRoutes struct {
+	// albums serves /albums, /events and /jobs.
+	albums *albumHandler
+	// writeAuth guards requests that change albums.
+	writeAuth []gin.HandlerFunc
+	// idempotency replays responses to POSTs retried with the same
+	// Idempotency-Key; empty when that is off.
+	idempotency []gin.HandlerFunc
+	// jwt serves /login; nil when JWT auth is off.
+	jwt *jwtAuth
+	// sessions serves /logout and /admin/sessions; nil when sessions are
+	// off.
+	sessions *sessionStore
+	// oidc serves /auth/login and /auth/callback; nil when OIDC login is
+	// off.
+	oidc *oidcLogin
+	// keys serves /admin/keys; nil when API keys are off.
+	keys *apiKeyStore
+	// adminAuth guards /admin; nil disables the admin endpoints.
+	adminAuth gin.HandlerFunc
+	// roles serves /admin/roles; nil when auth is off.
+	roles *roleStore
+	// audit serves /admin/audit; nil when the audit log is off.
+	audit auditSink
+	// runtime serves /admin/config, /admin/log-level, /admin/debug and
+	// /admin/maintenance.
+	runtime *runtimeAdmin
+	// flags serves /admin/flags.
+	flags *flagAdmin
+	// uploads serves /upload and /files.
+	uploads *uploadHandler
+	// webhooks serves /webhooks; nil when webhooks are off.
+	webhooks *webhookDispatcher
+	// upstreams serves /upstreams; nil when no upstreams are configured.
+	upstreams *upstreamProxy
+	// sockets serves /ws.
+	sockets *socketHandler
+}
+
+// registerV1 registers version 1 of the API on g. A future version gets
+// its own register function and prefix, so both can be served at once.
+func (a apiRoutes) registerV1(g *gin.RouterGroup) {
+	g.GET("/albums", a.includeDeletedAuth(), a.albums.getAlbums)
+	g.GET("/albums/stream", a.includeDeletedAuth(), a.albums.getAlbumStream)
+	g.GET("/albums/export", a.includeDeletedAuth(), a.albums.getAlbumExport)
+	g.GET("/albums/:id", a.albums.getAlbumByID)
+	g.GET("/events", a.albums.getEvents)
+	g.GET("/ws", a.sockets.getSocket)
+	g.GET("/jobs/:id", a.albums.getJob)
+
+	writes := g.Group("/", a.writeAuth...)
+	retryable := writes.Group("/", a.idempotency...)
+	retryable.POST("/albums", a.albums.postAlbums)
+	retryable.POST("/albums/batch", a.albums.postAlbumBatchOps)
+	writes.PUT("/albums/:id", a.albums.putAlbum)
+	writes.DELETE("/albums/:id", a.albums.deleteAlbum)
+	writes.POST("/albums/:id/restore", a.albums.postAlbumRestore)
+	writes.POST("/upload", a.uploads.postUpload)
+	g.GET("/files/:id", a.uploads.getFile)
+	if a.upstreams != nil {
+		g.GET("/upstreams/:name/*path", a.upstreams.getUpstream)
+	}
+	if a.webhooks != nil {
+		writes.POST("/webhooks", a.webhooks.registerWebhook)
+		writes.GET("/webhooks", a.webhooks.getWebhooks)
+		writes.DELETE("/webhooks/:id", a.webhooks.deleteWebhook)
+		writes.GET("/webhooks/:id/deliveries", a.webhooks.getDeliveries)
+		retryable.POST("/webhooks/:id/deliveries/:delivery/replay", a.webhooks.replayDelivery)
+	}
+
+	if a.jwt != nil {
+		g.POST("/login", a.jwt.login)
+	}
+	if a.sessions != nil {
+		g.GET("/session", a.sessions.getSession)
+		g.POST("/logout", a.sessions.logout)
+	}
+	if a.oidc != nil {
+		g.GET("/auth/login", a.oidc.login)
+		g.GET("/auth/callback", a.oidc.callback)
+	}
+	if a.adminAuth != nil {
+		admin := g.Group("/admin", a.adminAuth)
+		if a.keys != nil {
+			admin.GET("/keys", a.keys.getAPIKeys)
+			admin.POST("/keys", a.keys.createAPIKey)
+			admin.DELETE("/keys/:id", a.keys.deleteAPIKey)
+		}
+		if a.roles != nil {
+			admin.GET("/roles", a.roles.getRoles)
+			admin.PUT("/roles/:subject", a.roles.putRole)
+			admin.DELETE("/roles/:subject", a.roles.deleteRole)
+		}
+		if a.audit != nil {
+			admin.GET("/audit", getAudit(a.audit))
+		}
+		if a.sessions != nil {
+			admin.DELETE("/sessions/:subject", a.sessions.deleteSessions)
+		}
+		admin.GET("/config", a.runtime.getConfig)
+		admin.GET("/log-level", a.runtime.getLogLevel)
+		admin.PUT("/log-level", a.runtime.putLogLevel)
+		admin.GET("/debug", a.runtime.getDebug)
+		admin.PUT("/debug", a.runtime.putDebug)
+		admin.GET("/maintenance", a.runtime.getMaintenance)
+		admin.PUT("/maintenance", a.runtime.putMaintenance)
+		admin.GET("/flags", a.flags.getFlags)
+		admin.PUT("/flags/:name", a.flags.putFlag)
+		admin.DELETE("/flags/:name", a.flags.deleteFlag)
+	}
+}
+
+// registerVersions serves version 1 under /v1 and, for clients written
+// before versioning, at the root with deprecation headers pointing them to
+// /v1. sunset, if set, is when the unversioned paths go away.
+func (a apiRoutes) registerVersions(router *gin.Engine, sunset time.Time) {
+	a.registerV1(router.Group("/v1"))
+	a.registerV1(router.Group("/", deprecated(sunset), successorVersion("/v1")))
+}
+
+// successorVersion returns middleware that links a deprecated response to
+// the same path under prefix.
+func successorVersion(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Link", "<"+prefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateRouteIsReported(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer func() {
+		msg := fmt.Sprint(recover())
+		if !strings.Contains(msg, "already registered") || !strings.Contains(msg, "/v1/albums/:id") {
+			t.Errorf("registering GET /v1/albums/:id again: %q, want a conflict naming the path", msg)
+		}
+	}()
+	ts.router.GET("/v1/albums/:id", getHealthz)
+	t.Error("duplicate route registered silently")
+}
+
+func TestDistinctMethodsShareAPath(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.router.Handle(http.MethodPatch, "/v1/albums/:id", getHealthz)
+	wantStatus(t, ts.do(http.MethodPatch, "/v1/albums/1", ""), http.StatusOK)
+}
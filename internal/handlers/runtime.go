@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/config"
+)
+
+// runtimeAdmin serves the /admin endpoints operators diagnose a running
+// instance with: its log level, whether profiles are served and the
+// settings in effect, and with which they take it in and out of
+// maintenance.
+type runtimeAdmin struct {
+	live *reloader
+	// debug is whether /debug/pprof serves profiles.
+	debug       *atomic.Bool
+	maintenance *maintenance
+}
+
+// getConfig responds with the settings in effect, secrets redacted.
+func (a *runtimeAdmin) getConfig(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, a.live.config().Redacted())
+}
+
+// getLogLevel responds with the lowest level logged.
+func (a *runtimeAdmin) getLogLevel(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"level": strings.ToLower(logLevel.Level().String())})
+}
+
+// putLogLevel sets the lowest level logged until a reload changes
+// LOG_LEVEL.
+func (a *runtimeAdmin) putLogLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level" binding:"required,oneof=debug info warn error"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	level, _ := config.ParseLogLevel(req.Level) // checked by the binding
+	logLevel.Set(level)
+	slog.WarnContext(c.Request.Context(), "log level changed", "level", req.Level)
+	a.getLogLevel(c)
+}
+
+// getDebug responds with whether profiles are served.
+func (a *runtimeAdmin) getDebug(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"enabled": a.debug.Load()})
+}
+
+// putDebug turns serving profiles on or off until the process restarts.
+func (a *runtimeAdmin) putDebug(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	a.debug.Store(*req.Enabled)
+	slog.WarnContext(c.Request.Context(), "debug endpoints changed", "enabled", *req.Enabled)
+	a.getDebug(c)
+}
+
+// getMaintenance responds with whether maintenance mode is on.
+func (a *runtimeAdmin) getMaintenance(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"enabled": a.maintenance.on.Load()})
+}
+
+// putMaintenance turns maintenance mode on or off until a reload changes
+// MAINTENANCE.
+func (a *runtimeAdmin) putMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	a.maintenance.on.Store(*req.Enabled)
+	slog.WarnContext(c.Request.Context(), "maintenance mode changed", "enabled", *req.Enabled)
+	a.getMaintenance(c)
+}
+
+// debugRoute serves h on the router while enabled is set, and otherwise
+// answers as if it were not routed.
+func debugRoute(enabled *atomic.Bool, h http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled.Load() {
+			noRoute(c)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// debugOnly serves h while enabled is set and answers 404 otherwise.
+func debugOnly(enabled *atomic.Bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Policies for handling control characters in album text fields.
+const (
+	sanitizeOff    = ""
+	sanitizeReject = "reject"
+	sanitizeStrip  = "strip"
+)
+
+// textSanitizer guards free-text fields against control characters, which
+// would otherwise allow log injection and break downstream storage.
+type textSanitizer struct {
+	policy    string
+	normalize bool
+}
+
+// newTextSanitizer validates policy and returns a sanitizer for it.
+func newTextSanitizer(policy string, normalize bool) (textSanitizer, error) {
+	switch policy {
+	case sanitizeOff, sanitizeReject, sanitizeStrip:
+		return textSanitizer{policy: policy, normalize: normalize}, nil
+	}
+	return textSanitizer{}, fmt.Errorf("TEXT_SANITIZE %q must be %q or %q", policy, sanitizeReject, sanitizeStrip)
+}
+
+// clean applies the policy to v. It reports false when v must be rejected.
+func (s textSanitizer) clean(v string) (string, bool) {
+	if s.normalize {
+		v = norm.NFC.String(v)
+	}
+	if s.policy == sanitizeOff || strings.IndexFunc(v, unicode.IsControl) < 0 {
+		return v, true
+	}
+	if s.policy == sanitizeReject {
+		return v, false
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, v), true
+}
+
+// sanitizeAlbum cleans the text fields of a in place.
+func (s textSanitizer) sanitizeAlbum(a *album) error {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"title", &a.Title},
+		{"artist", &a.Artist},
+	}
+	for _, f := range fields {
+		v, ok := s.clean(*f.value)
+		if !ok {
+			return fmt.Errorf("%s must not contain control characters", f.name)
+		}
+		*f.value = v
+	}
+	return nil
+}
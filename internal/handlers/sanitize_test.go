@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// controlTitle is an album whose title holds a newline and a null byte.
+const controlTitle = `{"title": "Blue\nTrain\u0000", "artist": "John Coltrane", "price": 56.99}`
+
+func TestTextSanitizeReject(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"TEXT_SANITIZE": "reject"})
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", controlTitle), http.StatusUnprocessableEntity)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `[`+controlTitle+`]`), http.StatusUnprocessableEntity)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `{"title": "Blue Train", "artist": "John Coltrane", "price": 56.99}`), http.StatusCreated)
+}
+
+func TestTextSanitizeStrip(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"TEXT_SANITIZE": "strip"})
+	w := ts.do(http.MethodPost, "/v1/albums", controlTitle)
+	wantStatus(t, w, http.StatusCreated)
+	var got album
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "BlueTrain" {
+		t.Errorf("stored title %q, want the control characters stripped", got.Title)
+	}
+}
+
+func TestTextSanitizeOff(t *testing.T) {
+	ts := newTestServer(t, nil)
+	w := ts.do(http.MethodPost, "/v1/albums", controlTitle)
+	wantStatus(t, w, http.StatusCreated)
+	var got album
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if got.Title != "Blue\nTrain\x00" {
+		t.Errorf("stored title %q, want it as sent", got.Title)
+	}
+}
+
+func TestNewTextSanitizer(t *testing.T) {
+	if _, err := newTextSanitizer("escape", false); err == nil {
+		t.Error("newTextSanitizer accepted an unknown policy")
+	}
+	s, _ := newTextSanitizer(sanitizeOff, true)
+	if got, _ := s.clean("Cafe\u0301"); got != "Caf\u00e9" {
+		t.Errorf("normalized %q, want NFC", got)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/scheduler"
+	"pspFileAPI/internal/service"
+)
+
+// scheduleJobs adds to jobs the periodic jobs the configuration enables:
+// purging albums past DELETED_RETENTION, sweeping expired responses from a
+// local idempotency store and replaying failed webhook deliveries. The
+// store and dispatcher are nil when disabled.
+func scheduleJobs(jobs *scheduler.Scheduler, cfg config.Config, albums *service.Albums, idempotency cache.Cache, webhooks *webhookDispatcher) error {
+	if cfg.DeletedRetention > 0 {
+		spec := cfg.PurgeSchedule
+		if spec == "" {
+			spec = "@every " + cfg.PurgeInterval.String()
+		}
+		err := jobs.Add("purge_deleted", spec, func(ctx context.Context) error {
+			return albums.PurgeDeleted(ctx, cfg.DeletedRetention)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Redis expires entries itself and needs no sweeping.
+	if sweeper, ok := idempotency.(cache.Sweeper); ok && cfg.IdempotencySweepSchedule != "" {
+		err := jobs.Add("idempotency_sweep", cfg.IdempotencySweepSchedule, func(ctx context.Context) error {
+			n, err := sweeper.Sweep(ctx)
+			if n > 0 {
+				slog.DebugContext(ctx, "swept expired idempotency keys", "count", n)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if webhooks != nil && cfg.WebhookRetrySchedule != "" {
+		if err := jobs.Add("webhook_retry", cfg.WebhookRetrySchedule, webhooks.retryFailed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schemaHeader names the album body schema a request is written in.
+// Requests without it are in currentSchema.
+const schemaHeader = "X-Schema-Version"
+
+// currentSchema is the album body schema handlers work with:
+//   - 1: id, title, artist and price. A replacement overwrites the album
+//     whatever has changed since the client read it.
+//   - 2: adds version. A replacement must send the version it is based
+//     on, or its ETag in If-Match, and fails if the album has moved on.
+const currentSchema = 2
+
+// albumMigrations[v-1] upgrades an album body of schema v to v+1. id is
+// the album being replaced, or "" for a creation. A migration that fails
+// has responded and returns false.
+var albumMigrations = []func(c *gin.Context, h *albumHandler, id string, a *album) bool{
+	upgradeSchema1,
+}
+
+// requestSchema returns the schema the request's album bodies are in,
+// answering 400 and returning false for one not served.
+func requestSchema(c *gin.Context) (int, bool) {
+	v := c.GetHeader(schemaHeader)
+	if v == "" {
+		return currentSchema, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > currentSchema {
+		writeProblem(c, http.StatusBadRequest, tr(c, "%s must be a schema version from 1 to %d", schemaHeader, currentSchema))
+		return 0, false
+	}
+	return n, true
+}
+
+// bindAlbums is bindBody for dst, an album or albumList, upgrading the
+// albums to currentSchema between decoding and validating them, so
+// handlers only see the current shape. id is the album being replaced,
+// or "" for creations.
+func (h *albumHandler) bindAlbums(c *gin.Context, id string, dst any) bool {
+	schema, ok := requestSchema(c)
+	if !ok || !readBody(c, dst) {
+		return false
+	}
+	var albums []album
+	switch v := dst.(type) {
+	case *album:
+		albums = []album{*v}
+	case *albumList:
+		albums = *v
+	}
+	for i := range albums {
+		for from := schema; from < currentSchema; from++ {
+			if !albumMigrations[from-1](c, h, id, &albums[i]) {
+				return false
+			}
+		}
+	}
+	if a, ok := dst.(*album); ok {
+		*a = albums[0]
+	}
+	return checkBody(c, dst)
+}
+
+// upgradeSchema1 gives a schema 1 album the version schema 2 adds. A
+// replacement gets the album's current version, keeping schema 1's
+// overwrite; creations need none.
+func upgradeSchema1(c *gin.Context, h *albumHandler, id string, a *album) bool {
+	if a.Version != 0 {
+		writeProblem(c, http.StatusBadRequest, "invalid body", fieldError{Field: "version", Message: "is not a known field"})
+		return false
+	}
+	if id == "" || c.GetHeader("If-Match") != "" {
+		return true
+	}
+	current, err := h.albums.Get(c.Request.Context(), id)
+	if err != nil {
+		respondStoreError(c, err)
+		return false
+	}
+	a.Version = current.Version
+	return true
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSchema1ReplacementIsUpgraded(t *testing.T) {
+	ts := newTestServer(t, nil)
+	v1 := `{"title": "Blue Train (Remastered)", "artist": "John Coltrane", "price": 19.99}`
+
+	// The current schema needs a version or If-Match.
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", v1), http.StatusPreconditionRequired)
+
+	// Schema 1 had neither, and replaced the album whatever its version.
+	for want := 2; want <= 3; want++ {
+		w := ts.do(http.MethodPut, "/v1/albums/1", v1, schemaHeader, "1")
+		wantStatus(t, w, http.StatusOK)
+		var got album
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Blue Train (Remastered)" || got.Version != want {
+			t.Errorf("schema 1 replacement = %+v, want the new title at version %d", got, want)
+		}
+	}
+
+	// If-Match still guards a schema 1 replacement that sends it.
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/1", v1, schemaHeader, "1", "If-Match", `"v1"`), http.StatusPreconditionFailed)
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/99", v1, schemaHeader, "1"), http.StatusNotFound)
+}
+
+func TestSchema1Bodies(t *testing.T) {
+	ts := newTestServer(t, nil)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `{"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 9.99}`, schemaHeader, "1"), http.StatusCreated)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `[{"title": "Giant Steps", "artist": "John Coltrane", "price": 11.5}]`, schemaHeader, "1"), http.StatusCreated)
+
+	// Schema 1 has no version.
+	w := ts.do(http.MethodPut, "/v1/albums/2", `{"title": "Jeru", "artist": "Gerry Mulligan", "price": 1, "version": 1}`, schemaHeader, "1")
+	wantStatus(t, w, http.StatusBadRequest)
+	var p struct{ Errors []fieldError }
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if len(p.Errors) != 1 || p.Errors[0].Field != "version" {
+		t.Errorf("schema 1 body with a version: errors %+v, want one for version", p.Errors)
+	}
+
+	// Migrated bodies are still validated.
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/2", `{"title": "", "artist": "Gerry Mulligan", "price": 1}`, schemaHeader, "1"), http.StatusBadRequest)
+
+	for _, v := range []string{"0", "3", "two"} {
+		wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `{"title": "T", "artist": "A", "price": 1}`, schemaHeader, v), http.StatusBadRequest)
+	}
+	wantStatus(t, ts.do(http.MethodPut, "/v1/albums/2", `{"title": "Jeru", "artist": "Gerry Mulligan", "price": 1, "version": 1}`, schemaHeader, "2"), http.StatusOK)
+}
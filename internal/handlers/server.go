@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"pspFileAPI/internal/config"
+)
+
+// newServer returns a server for handler on cfg's address with its timeouts
+// and header limit, so slow clients cannot hold connections open
+// indefinitely. Its requests' contexts tell, through stopRequested, when
+// it starts shutting down.
+func newServer(cfg config.Config, handler http.Handler) *http.Server {
+	stop := make(chan struct{})
+	base := context.WithValue(context.Background(), stoppingKey{}, (<-chan struct{})(stop))
+	srv := &http.Server{
+		Addr:              net.JoinHostPort(cfg.Host, cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	var once sync.Once
+	srv.RegisterOnShutdown(func() { once.Do(func() { close(stop) }) })
+	return srv
+}
+
+// stoppingKey is the context key of the channel newServer closes when its
+// server starts shutting down.
+type stoppingKey struct{}
+
+// stopRequested reports whether the server handling the request ctx
+// belongs to has started shutting down. Streaming handlers check it
+// between whole records and end the response there, so the drain sees it
+// finish cleanly instead of cutting it off when SHUTDOWN_TIMEOUT runs out.
+// Unlike cancelling the request's context, it leaves ordinary requests in
+// flight to complete.
+func stopRequested(ctx context.Context) bool {
+	select {
+	case <-stopping(ctx):
+		return true
+	default:
+		return false
+	}
+}
+
+// stopping returns a channel closed when the server handling the request
+// ctx belongs to starts shutting down, or nil outside a request.
+func stopping(ctx context.Context) <-chan struct{} {
+	stop, _ := ctx.Value(stoppingKey{}).(<-chan struct{})
+	return stop
+}
+
+// listener is a server together with how it accepts connections on a
+// listening socket and shuts down gracefully. start returns
+// http.ErrServerClosed, or nil, once shut down.
+type listener struct {
+	name, addr string
+	start      func(lis net.Listener) error
+	shutdown   func(ctx context.Context) error
+}
+
+// httpListener returns a listener running srv with start.
+func httpListener(name string, srv *http.Server, start func(lis net.Listener) error) listener {
+	return listener{name: name, addr: srv.Addr, start: start, shutdown: srv.Shutdown}
+}
+
+// plain returns a listener serving srv over plain HTTP.
+func plain(name string, srv *http.Server) listener {
+	return httpListener(name, srv, srv.Serve)
+}
+
+// serve runs every listener until one fails or the process receives SIGINT
+// or SIGTERM. It then fails /readyz for delay while still serving, and
+// gives in-flight requests up to drain to finish before returning. On
+// SIGUSR2 it hands its sockets to a new process, waiting up to
+// upgradeTimeout for it to listen, and then drains without the delay.
+func serve(delay, drain, upgradeTimeout time.Duration, listeners ...listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	upgrades := make(chan os.Signal, 1)
+	signal.Notify(upgrades, syscall.SIGUSR2)
+	defer signal.Stop(upgrades)
+
+	socks, err := listenAll(listeners)
+	if err != nil {
+		return err
+	}
+	if err := notifyParent(); err != nil {
+		slog.Warn("telling the previous process we are listening failed", "err", err)
+	}
+
+	errc := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(l listener, lis net.Listener) {
+			slog.Info("listening", "server", l.name, "addr", lis.Addr().String())
+			err := l.start(lis)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+				errc <- fmt.Errorf("%s server: %w", l.name, err)
+				return
+			}
+			errc <- nil
+		}(l, socks[i])
+	}
+
+	running, upgraded := len(listeners), false
+wait:
+	for {
+		select {
+		case err = <-errc:
+			running--
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-upgrades:
+			if uerr := upgrade(listeners, socks, upgradeTimeout); uerr != nil {
+				slog.Error("upgrade failed; still serving", "err", uerr)
+				continue
+			}
+			upgraded = true
+			break wait
+		}
+	}
+	stop()
+
+	// Fail readiness first and keep serving for delay, so load balancers
+	// stop sending requests before the listeners close. After an upgrade
+	// the new process answers on the same sockets, so there is nothing to
+	// wait for.
+	shuttingDown.Store(true)
+	if delay > 0 && err == nil && !upgraded {
+		slog.Info("draining from load balancers", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	if upgraded {
+		handOver(socks)
+	}
+
+	slog.Info("shutting down", "drain", drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	for _, l := range listeners {
+		if serr := l.shutdown(shutdownCtx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	for ; running > 0; running-- {
+		if serr := <-errc; serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err == nil {
+		slog.Info("server stopped")
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/fake"
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+// stallingRepository holds up every List after the first until the server
+// handling the request starts shutting down.
+type stallingRepository struct {
+	*fake.Repository
+}
+
+func (r stallingRepository) List(ctx context.Context, q repository.ListQuery) ([]repository.Album, int, error) {
+	if q.Offset > 0 {
+		for !stopRequested(ctx) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return r.Repository.List(ctx, q)
+}
+
+func TestShutdownEndsStreamCleanly(t *testing.T) {
+	seed := make([]repository.Album, 3*streamBatchSize)
+	for i := range seed {
+		seed[i] = repository.Album{ID: strconv.Itoa(i + 1), Title: "Title", Artist: "Artist", Price: 1}
+	}
+	events := service.NewEvents()
+	h := &albumHandler{albums: service.NewAlbums(stallingRepository{fake.NewRepository(seed...)}, events), events: events}
+	router := gin.New()
+	router.GET("/albums/stream", h.getAlbumStream)
+
+	srv := newServer(config.Config{}, router)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+
+	resp, err := http.Get("http://" + lis.Addr().String() + "/albums/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() {
+		t.Fatalf("no first album: %v", lines.Err())
+	}
+
+	// The first batch is out and the second is held up; shut down.
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	n := 1
+	for lines.Scan() {
+		var a repository.Album
+		if err := json.Unmarshal(lines.Bytes(), &a); err != nil {
+			t.Fatalf("line %d: %v", n+1, err)
+		}
+		n++
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatalf("stream ended uncleanly after %d albums: %v", n, err)
+	}
+	if n != 2*streamBatchSize {
+		t.Errorf("stream sent %d albums, want the %d of the batches begun before shutdown", n, 2*streamBatchSize)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestEventStreamOutlivesWriteTimeout(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"WRITE_TIMEOUT": "1s"})
+	srv := newServer(ts.cfg, ts.router)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+
+	resp, err := http.Get("http://" + lis.Addr().String() + "/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+
+	// Past WRITE_TIMEOUT, through every middleware NewServer installs.
+	time.Sleep(1500 * time.Millisecond)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", `{"title": "Kind of Blue", "artist": "Miles Davis", "price": 9.99}`), http.StatusCreated)
+
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if lines.Text() == "event: album.created" {
+			return
+		}
+	}
+	t.Fatalf("stream ended before the event: %v", lines.Err())
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/cache"
+)
+
+// sessionCookie carries the session ID of browser clients.
+const sessionCookie = "session"
+
+// csrfHeader carries a session's CSRF token on requests that change
+// something.
+const csrfHeader = "X-CSRF-Token"
+
+// errCSRF rejects a state-changing request authenticated by session cookie
+// without the session's CSRF token. It is answered with 403 rather than
+// 401, as logging in again would not help.
+var errCSRF = errors.New("missing or wrong " + csrfHeader)
+
+// sessionTouchInterval is how stale a session's last use may get before a
+// request writes it back to extend its expiry, so busy sessions are not
+// rewritten on every request.
+const sessionTouchInterval = time.Minute
+
+// session is a logged-in browser, stored under its hashed ID.
+type session struct {
+	Subject string `json:"subject"`
+	Role    role   `json:"role"`
+	Tenant  string `json:"tenant"`
+	// Generation is the subject's session generation at login. Bumping it
+	// ends every session the subject has.
+	Generation int64 `json:"generation"`
+	// CSRFToken must be sent in X-CSRF-Token with every POST, PUT and
+	// DELETE made with the session.
+	CSRFToken string    `json:"csrf_token"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// sessionStore keeps sessions for clients that cannot hold bearer tokens.
+// A session ends once unused for idle, or max after login, whichever is
+// first.
+type sessionStore struct {
+	store  cache.Cache
+	idle   time.Duration
+	max    time.Duration
+	secure bool
+}
+
+// newSessionStore returns a sessionStore keeping sessions in store.
+func newSessionStore(store cache.Cache, idle, max time.Duration, secure bool) *sessionStore {
+	return &sessionStore{store: store, idle: idle, max: max, secure: secure}
+}
+
+// sessionKey is where the session with ID id is stored. IDs are hashed so
+// the store's contents cannot be used as cookies.
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "session:" + hex.EncodeToString(sum[:])
+}
+
+// sessionGenerationKey is the counter bumped to end subject's sessions.
+func sessionGenerationKey(subject string) string {
+	return "session-generation:" + subject
+}
+
+// create starts a session for subject of tenant with role r, sets its
+// cookie and returns it.
+func (s *sessionStore) create(c *gin.Context, subject string, r role, tenant string) (session, error) {
+	ctx := c.Request.Context()
+	gen, err := s.store.Counter(ctx, sessionGenerationKey(subject))
+	if err != nil {
+		return session{}, err
+	}
+	id, csrf := randomToken(), randomToken()
+	now := time.Now().UTC()
+	sess := session{Subject: subject, Role: r, Tenant: tenant, Generation: gen, CSRFToken: csrf, CreatedAt: now, LastSeen: now}
+	if err := s.save(ctx, id, sess); err != nil {
+		return session{}, err
+	}
+	s.setCookie(c, id, int(s.max/time.Second))
+	return sess, nil
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// save stores sess under id until it would expire.
+func (s *sessionStore) save(ctx context.Context, id string, sess session) error {
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ctx, sessionKey(id), b, s.expiry(sess).Sub(sess.LastSeen))
+}
+
+// expiry is when sess ends unless it is used again.
+func (s *sessionStore) expiry(sess session) time.Time {
+	end := sess.LastSeen.Add(s.idle)
+	if limit := sess.CreatedAt.Add(s.max); limit.Before(end) {
+		return limit
+	}
+	return end
+}
+
+func (s *sessionStore) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// load returns the ID and live session of r's session cookie, extending
+// the session's expiry, or errNoCredentials if r has no cookie.
+func (s *sessionStore) load(r *http.Request) (string, session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return "", session{}, errNoCredentials
+	}
+	ctx := r.Context()
+	b, ok, err := s.store.Get(ctx, sessionKey(cookie.Value))
+	if err != nil {
+		slog.WarnContext(ctx, "session lookup failed", "err", err)
+		return "", session{}, errors.New("session store unavailable")
+	}
+	var sess session
+	if !ok || json.Unmarshal(b, &sess) != nil {
+		return "", session{}, errors.New("session expired or not found")
+	}
+	now := time.Now().UTC()
+	if !now.Before(s.expiry(sess)) {
+		return "", session{}, errors.New("session expired or not found")
+	}
+	if gen, err := s.store.Counter(ctx, sessionGenerationKey(sess.Subject)); err != nil || gen != sess.Generation {
+		return "", session{}, errors.New("session expired or not found")
+	}
+
+	if now.Sub(sess.LastSeen) >= sessionTouchInterval {
+		sess.LastSeen = now
+		if err := s.save(ctx, cookie.Value, sess); err != nil {
+			slog.WarnContext(ctx, "session refresh failed", "err", err)
+		}
+	}
+	return cookie.Value, sess, nil
+}
+
+// authenticate returns the caller whose session cookie r carries. Unless
+// r's method is safe, it must also carry the session's CSRF token.
+func (s *sessionStore) authenticate(r *http.Request) (*identity, error) {
+	_, sess, err := s.load(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCSRF(r, sess); err != nil {
+		return nil, err
+	}
+	return &identity{subject: sess.Subject, method: "session", role: sess.Role, tenant: sess.Tenant}, nil
+}
+
+// checkCSRF returns errCSRF if r changes something without sess's CSRF
+// token.
+func checkCSRF(r *http.Request, sess session) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(sess.CSRFToken)) != 1 {
+		return errCSRF
+	}
+	return nil
+}
+
+// challenge is empty: browsers log in again rather than answer one.
+func (s *sessionStore) challenge() string { return "" }
+
+// getSession responds with the current session and its CSRF token, so a
+// page loaded after login can pick the token up.
+func (s *sessionStore) getSession(c *gin.Context) {
+	_, sess, err := s.load(c.Request)
+	if err != nil {
+		writeProblem(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"subject":    sess.Subject,
+		"role":       sess.Role,
+		"tenant":     sess.Tenant,
+		"csrf_token": sess.CSRFToken,
+		"expires_at": s.expiry(sess),
+	})
+}
+
+// logout ends the session the request's cookie names, if any, and clears
+// the cookie. A live session must be ended with its CSRF token.
+func (s *sessionStore) logout(c *gin.Context) {
+	id, sess, err := s.load(c.Request)
+	if err == nil {
+		if err := checkCSRF(c.Request, sess); err != nil {
+			writeProblem(c, http.StatusForbidden, err.Error())
+			return
+		}
+		if err := s.store.Delete(c.Request.Context(), sessionKey(id)); err != nil {
+			slog.WarnContext(c.Request.Context(), "session delete failed", "err", err)
+			writeProblem(c, http.StatusServiceUnavailable, "could not end the session")
+			return
+		}
+	}
+	s.setCookie(c, "", -1)
+	c.Status(http.StatusNoContent)
+}
+
+// deleteSessions ends every session of the subject parameter.
+func (s *sessionStore) deleteSessions(c *gin.Context) {
+	if err := s.store.Incr(c.Request.Context(), sessionGenerationKey(c.Param("subject"))); err != nil {
+		slog.WarnContext(c.Request.Context(), "session invalidation failed", "err", err)
+		writeProblem(c, http.StatusServiceUnavailable, "could not end the sessions")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newSessionServer returns a test server with sessions, where ann may log
+// in with her password and change albums.
+func newSessionServer(t *testing.T) *testServer {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("ann-password"), bcrypt.MinCost)
+	return newTestServer(t, map[string]string{
+		"JWT_SECRET":            "session-test-secret",
+		"AUTH_USERS":            "ann:" + string(hash),
+		"AUTH_ROLES":            "ann:user",
+		"SESSIONS_ENABLED":      "true",
+		"SESSION_COOKIE_SECURE": "false",
+	})
+}
+
+// sessionLogin logs ann in and returns her session cookie, CSRF token and
+// bearer token.
+func sessionLogin(t *testing.T, ts *testServer) (cookie, csrf, token string) {
+	t.Helper()
+	w := ts.do(http.MethodPost, "/v1/login", `{"username": "ann", "password": "ann-password"}`)
+	wantStatus(t, w, http.StatusOK)
+	var resp struct {
+		Token     string `json:"token"`
+		CSRFToken string `json:"csrf_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+	if cookie == "" || resp.CSRFToken == "" {
+		t.Fatalf("login cookie %q and csrf_token %q, want both", cookie, resp.CSRFToken)
+	}
+	return cookie, resp.CSRFToken, resp.Token
+}
+
+func TestSessionWritesNeedTheCSRFToken(t *testing.T) {
+	ts := newSessionServer(t)
+	cookie, csrf, token := sessionLogin(t, ts)
+	body := `{"title": "Kind of Blue", "artist": "Miles Davis", "price": 9.99}`
+
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", body, "Cookie", cookie), http.StatusForbidden)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", body, "Cookie", cookie, csrfHeader, "wrong"), http.StatusForbidden)
+	wantStatus(t, ts.do(http.MethodDelete, "/v1/albums/1", "", "Cookie", cookie), http.StatusForbidden)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", body, "Cookie", cookie, csrfHeader, csrf), http.StatusCreated)
+
+	// Reads need no token, and neither do requests with a bearer token.
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums/1", "", "Cookie", cookie), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/albums", body, "Authorization", "Bearer "+token), http.StatusCreated)
+}
+
+func TestLogoutNeedsTheCSRFToken(t *testing.T) {
+	ts := newSessionServer(t)
+	cookie, csrf, _ := sessionLogin(t, ts)
+
+	wantStatus(t, ts.do(http.MethodPost, "/v1/logout", "", "Cookie", cookie), http.StatusForbidden)
+	wantStatus(t, ts.do(http.MethodPost, "/v1/logout", "", "Cookie", cookie, csrfHeader, csrf), http.StatusNoContent)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/session", "", "Cookie", cookie), http.StatusUnauthorized)
+}
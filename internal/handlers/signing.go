@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signatureHeader carries the HMAC of the response body.
+const signatureHeader = "X-Response-Signature"
+
+// bufferedWriter holds back the response body so middleware can inspect it
+// before anything is sent to the client.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// signingWriter holds back the body to sign it, until the handler flushes:
+// a streamed response is sent as it is written, unsigned.
+type signingWriter struct {
+	bufferedWriter
+	streaming bool
+}
+
+func (w *signingWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *signingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *signingWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// signResponses returns middleware that sets an HMAC-SHA256 of the response
+// body, keyed with the current secret, in the X-Response-Signature
+// header. It must run before any middleware that encodes the body, so
+// clients verify the signature against the decoded payload. Responses the
+// handler flushes, such as streams and exports, go out unsigned.
+func signResponses(secret *secretValue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &signingWriter{bufferedWriter: bufferedWriter{ResponseWriter: c.Writer}}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.streaming {
+			return
+		}
+
+		mac := hmac.New(sha256.New, secret.load())
+		mac.Write(w.body.Bytes())
+		c.Header(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		c.Writer.Write(w.body.Bytes())
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// wantSignature fails t unless signature is the HMAC of body with secret.
+func wantSignature(t *testing.T, secret, signature string, body []byte) {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("%s %q, want %q", signatureHeader, signature, want)
+	}
+}
+
+func TestResponsesAreSigned(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"RESPONSE_SIGNING_SECRET": "s3cret"})
+	w := ts.do(http.MethodGet, "/v1/albums/1", "")
+	wantStatus(t, w, http.StatusOK)
+	wantSignature(t, "s3cret", w.Header().Get(signatureHeader), w.Body.Bytes())
+
+	// Signing is opt-in.
+	ts = newTestServer(t, nil)
+	if got := ts.do(http.MethodGet, "/v1/albums/1", "").Header().Get(signatureHeader); got != "" {
+		t.Errorf("%s %q without a secret, want none", signatureHeader, got)
+	}
+}
+
+func TestSignatureCoversTheUncompressedBody(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"RESPONSE_SIGNING_SECRET": "s3cret", "COMPRESS_MIN_SIZE": "1"})
+	w := ts.do(http.MethodGet, "/v1/albums", "", "Accept-Encoding", "gzip")
+	wantStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSignature(t, "s3cret", w.Header().Get(signatureHeader), body)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+)
+
+// sniffLimit bounds how much of a body is buffered while looking at how it
+// starts.
+const sniffLimit = 512
+
+// readCloser pairs a buffered reader with the original body's Close.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sniffJSON returns the first non-whitespace byte of body, or 0 if none is
+// found within sniffLimit bytes, together with a replacement body that still
+// yields every byte of the original.
+func sniffJSON(body io.ReadCloser) (byte, io.ReadCloser) {
+	br := bufio.NewReaderSize(body, sniffLimit)
+	rc := readCloser{Reader: br, Closer: body}
+
+	for n := 1; n <= sniffLimit; n++ {
+		peek, err := br.Peek(n)
+		if err != nil {
+			break
+		}
+		switch b := peek[n-1]; b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b, rc
+		}
+	}
+	return 0, rc
+}
+
+// sniffFirst returns the first byte of body, or 0 if it is empty, together
+// with a replacement body that still yields every byte of the original.
+func sniffFirst(body io.ReadCloser) (byte, io.ReadCloser) {
+	peek, rc := peekBody(body)
+	if len(peek) == 0 {
+		return 0, rc
+	}
+	return peek[0], rc
+}
+
+// peekBody returns up to sniffLimit leading bytes of body together with a
+// replacement body that still yields every byte of the original.
+func peekBody(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	br := bufio.NewReaderSize(body, sniffLimit)
+	peek, _ := br.Peek(sniffLimit)
+	return peek, readCloser{Reader: br, Closer: body}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// includeDeletedAuth returns middleware that lets only admins list with
+// include_deleted=true, as deleted albums are meant to be gone for
+// everyone else.
+func (a apiRoutes) includeDeletedAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if include, _ := strconv.ParseBool(c.Query("include_deleted")); !include {
+			c.Next()
+			return
+		}
+		if a.adminAuth == nil {
+			writeProblem(c, http.StatusForbidden, "include_deleted needs the admin role")
+			return
+		}
+		a.adminAuth(c)
+	}
+}
+
+// postAlbumRestore undeletes the album whose ID matches the id parameter
+// and responds with it.
+func (h *albumHandler) postAlbumRestore(c *gin.Context) {
+	restored, err := h.albums.Restore(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.Header("ETag", albumETag(restored))
+	respond(c, http.StatusOK, restored)
+}
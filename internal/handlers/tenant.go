@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// tenantHeader names the tenant when TENANT_SOURCE is header.
+const tenantHeader = "X-Tenant-ID"
+
+// errWrongTenant rejects credentials that belong to a tenant other than
+// the one the request is for.
+var errWrongTenant = errors.New("credentials are for another tenant")
+
+// tenantAssignments gives users and API keys, by subject, the tenant they
+// belong to. Subjects without one belong to tenant.Default.
+type tenantAssignments map[string]string
+
+// parseTenantAssignments reads spec, a comma-separated list of
+// "name:tenant" entries, where API keys are named "key:name".
+func parseTenantAssignments(spec string) (tenantAssignments, error) {
+	t := make(tenantAssignments)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, id, ok := cutLast(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("AUTH_TENANTS entry %q must look like \"name:tenant\"", entry)
+		}
+		if !tenant.Valid(id) {
+			return nil, fmt.Errorf("AUTH_TENANTS entry for %q: tenant %q must be lower-case letters, digits and dashes", name, id)
+		}
+		t[name] = id
+	}
+	return t, nil
+}
+
+// cutLast slices s around the last instance of sep, as strings.Cut does
+// around the first.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// tenants returns the tenants assigned to anyone.
+func (t tenantAssignments) tenants() []string {
+	ids := make([]string, 0, len(t))
+	for _, id := range t {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// of returns subject's tenant.
+func (t tenantAssignments) of(subject string) string {
+	if id, ok := t[subject]; ok {
+		return id
+	}
+	return tenant.Default
+}
+
+// tenantResolver works out which tenant a request is for, from the source
+// TENANT_SOURCE names:
+//   - header: the X-Tenant-ID header;
+//   - subdomain: the host's label in front of domain, as in
+//     acme.albums.example.com;
+//   - token: the tenant of the caller's credentials.
+//
+// Requests that name no tenant act for tenant.Default. Tenants other than
+// those in known are not served. Since a header or host is the client's
+// word, requests for any other tenant, reads included, also need
+// credentials of that tenant, except on publicRoutes.
+type tenantResolver struct {
+	source string
+	domain string
+	auths  []authenticator
+	known  map[string]bool
+	// adminToken, if set, may act for any tenant.
+	adminToken *secretValue
+}
+
+// publicRoutes hold no tenant's data, or issue the credentials other
+// routes need, so they are served to anyone for any tenant.
+var publicRoutes = map[string]bool{
+	"/favicon.ico": true, "/healthz": true, "/readyz": true, "/metrics": true,
+	"/openapi.json": true, "/docs": true, "/graphiql": true, "/": true, "/assets/*filepath": true,
+	"/login": true, "/v1/login": true, "/auth/login": true, "/v1/auth/login": true,
+	"/auth/callback": true, "/v1/auth/callback": true,
+}
+
+// newTenantResolver returns a tenantResolver serving tenant.Default and
+// the tenants in known, reading each request's tenant from source.
+func newTenantResolver(source, domain string, auths []authenticator, known ...[]string) (tenantResolver, error) {
+	t := tenantResolver{source: source, domain: domain, auths: auths, known: map[string]bool{tenant.Default: true}}
+	for _, ids := range known {
+		for _, id := range ids {
+			if !tenant.Valid(id) {
+				return tenantResolver{}, fmt.Errorf("TENANTS entry %q must be lower-case letters, digits and dashes", id)
+			}
+			t.known[id] = true
+		}
+	}
+	return t, nil
+}
+
+// resolve returns r's tenant.
+func (t tenantResolver) resolve(r *http.Request) (string, error) {
+	var name string
+	switch t.source {
+	case "header":
+		name = r.Header.Get(tenantHeader)
+	case "subdomain":
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(t.domain)); ok {
+			name = sub
+		}
+	case "token":
+		if id, err := identify(r, t.auths); err == nil {
+			name = id.tenant
+		}
+	}
+	if name == "" {
+		return tenant.Default, nil
+	}
+	if !tenant.Valid(name) {
+		return "", fmt.Errorf("tenant %q must be lower-case letters, digits and dashes", name)
+	}
+	return name, nil
+}
+
+// admit checks that r's credentials may act for tenant name. Any caller
+// may act for tenant.Default, unless its credentials are of another
+// tenant; other tenants need credentials of their own or the admin token.
+func (t tenantResolver) admit(r *http.Request, name string) error {
+	if t.adminToken != nil {
+		if sent := r.Header.Get("X-Admin-Token"); sent != "" && subtle.ConstantTimeCompare([]byte(sent), t.adminToken.load()) == 1 {
+			return nil
+		}
+	}
+	id, err := identify(r, t.auths)
+	switch {
+	case err == nil:
+		if id.tenant != "" && id.tenant != name {
+			return errWrongTenant
+		}
+		return nil
+	case name == tenant.Default:
+		// Rejected credentials are left to the route's own checks.
+		return nil
+	default:
+		return err
+	}
+}
+
+// middleware returns middleware that puts the request's tenant in its
+// context, answering 400 for a malformed one, 404 for one not served, and
+// 401 or 403 when the caller may not act for it.
+func (t tenantResolver) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, err := t.resolve(c.Request)
+		if err != nil {
+			writeProblem(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !t.known[name] {
+			writeProblem(c, http.StatusNotFound, tr(c, "tenant %s is not served here", name))
+			return
+		}
+		if !publicRoutes[c.FullPath()] && c.FullPath() != "" {
+			if err := t.admit(c.Request, name); err != nil {
+				rejectAuth(c, t.auths, err)
+				return
+			}
+		}
+		ctx := tenant.With(c.Request.Context(), name)
+		c.Request = c.Request.WithContext(ctx)
+		addLogField(ctx, "tenant", name)
+		c.Next()
+	}
+}
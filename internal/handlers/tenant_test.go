@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTenantServer returns a test server taking tenants from the header,
+// with an API key for each of the tenants acme and beta.
+func newTenantServer(t *testing.T, env map[string]string) *testServer {
+	t.Helper()
+	vars := map[string]string{
+		"TENANT_SOURCE":  "header",
+		"API_KEY_HASHES": "acme-ci:" + hashAPIKey("acme-key") + ",beta-ci:" + hashAPIKey("beta-key"),
+		"AUTH_TENANTS":   "key:acme-ci:acme,key:beta-ci:beta",
+		"ADMIN_TOKEN":    "admin-token",
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	return newTestServer(t, vars)
+}
+
+func TestTenantReadsNeedCredentialsOfTheTenant(t *testing.T) {
+	ts := newTenantServer(t, nil)
+	for _, path := range []string{"/v1/albums", "/v1/albums/1", "/v1/albums/export", "/v1/events", "/v1/files/x"} {
+		w := ts.do(http.MethodGet, path, "", tenantHeader, "beta")
+		wantStatus(t, w, http.StatusUnauthorized)
+		w = ts.do(http.MethodGet, path, "", tenantHeader, "beta", apiKeyHeader, "acme-key")
+		wantStatus(t, w, http.StatusForbidden)
+	}
+
+	// Credentials of one tenant do not read the default tenant either.
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", apiKeyHeader, "acme-key"), http.StatusForbidden)
+
+	w := ts.do(http.MethodGet, "/v1/albums", "", tenantHeader, "beta", apiKeyHeader, "beta-key")
+	wantStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"data": []`) {
+		t.Errorf("new tenant's albums %s, want an empty data list", w.Body)
+	}
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", tenantHeader, "beta", "X-Admin-Token", "admin-token"), http.StatusOK)
+}
+
+func TestTenantDefaultAndPublicRoutes(t *testing.T) {
+	ts := newTenantServer(t, nil)
+	w := ts.do(http.MethodGet, "/v1/albums", "")
+	wantStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "Blue Train") {
+		t.Errorf("anonymous default read %s, want the seed albums", w.Body)
+	}
+	wantStatus(t, ts.do(http.MethodGet, "/healthz", "", tenantHeader, "beta"), http.StatusOK)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", tenantHeader, "gamma"), http.StatusNotFound)
+	wantStatus(t, ts.do(http.MethodGet, "/v1/albums", "", tenantHeader, "Not A Tenant"), http.StatusBadRequest)
+}
+
+func TestTenantSubdomainReadsNeedCredentials(t *testing.T) {
+	ts := newTenantServer(t, map[string]string{"TENANT_SOURCE": "subdomain", "TENANT_DOMAIN": "albums.example.com"})
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://acme.albums.example.com/v1/albums", nil)
+		if key != "" {
+			req.Header.Set(apiKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		ts.router.ServeHTTP(w, req)
+		return w
+	}
+	wantStatus(t, get(""), http.StatusUnauthorized)
+	wantStatus(t, get("beta-key"), http.StatusForbidden)
+	wantStatus(t, get("acme-key"), http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseRouteTimeouts parses ROUTE_TIMEOUTS: comma-separated entries
+// "METHOD /route=duration", or "/route=duration" for every method, where
+// route is a registered route such as /v1/albums/:id. A duration of 0
+// lifts the deadline.
+func parseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	routes := make(map[string]time.Duration)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !ok || route == "" {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q must look like \"GET /v1/albums=2s\" or \"/readyz=1s\"", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("ROUTE_TIMEOUTS entry %q: duration must be non-negative, such as 2s", entry)
+		}
+		if method, path, ok := strings.Cut(route, " "); ok {
+			route = strings.ToUpper(method) + " " + strings.TrimSpace(path)
+		}
+		routes[route] = d
+	}
+	return routes, nil
+}
+
+// requestTimeouts returns middleware that gives each request a deadline:
+// the one routes lists for its method and route, else for its route, else
+// def. Zero means none. The deadline is on the request context, so the
+// store calls and upstream requests handlers make are cancelled with it;
+// a request still unanswered when it passes gets 504.
+func requestTimeouts(def time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d, ok := routes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			if d, ok = routes[c.FullPath()]; !ok {
+				d = def
+			}
+		}
+		if d <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			addLogField(ctx, "timed_out", true)
+			if !c.Writer.Written() {
+				slog.WarnContext(ctx, "request deadline exceeded", "route", c.FullPath(), "timeout", d)
+				writeProblem(c, http.StatusGatewayTimeout, tr(c, "request did not complete within %s", d))
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"pspFileAPI/internal/config"
+)
+
+// configureTLS decides how srv is served. With AUTOCERT_DOMAIN set,
+// certificates for those domains are obtained from Let's Encrypt and a
+// second listener on AUTOCERT_HTTP_ADDR answers ACME challenges and
+// redirects plain HTTP to HTTPS. With TLS_CERT_FILE and TLS_KEY_FILE set,
+// that certificate is used. Otherwise srv speaks plain HTTP.
+func configureTLS(srv *http.Server, cfg config.Config) (listener, *listener, error) {
+	certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+
+	switch {
+	case len(cfg.AutocertDomains) > 0:
+		if certFile != "" || keyFile != "" {
+			return listener{}, nil, errors.New("set either AUTOCERT_DOMAIN or TLS_CERT_FILE and TLS_KEY_FILE, not both")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		redirect := plain("redirect", &http.Server{
+			Addr:              cfg.AutocertHTTPAddr,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 5 * time.Second,
+		})
+		api := httpListener("api", srv, func(lis net.Listener) error { return srv.ServeTLS(lis, "", "") })
+		return api, &redirect, nil
+
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return listener{}, nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return httpListener("api", srv, func(lis net.Listener) error { return srv.ServeTLS(lis, certFile, keyFile) }), nil, nil
+	}
+	return plain("api", srv), nil, nil
+}
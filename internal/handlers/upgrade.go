@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The environment a process started by upgrade finds its sockets in.
+// inheritedEnv names the listeners whose sockets are passed, in order,
+// from file descriptor 3; readyEnv is the descriptor of the pipe it writes
+// a byte to once it is listening.
+const (
+	inheritedEnv = "ALBUMS_INHERITED_LISTENERS"
+	readyEnv     = "ALBUMS_READY_FD"
+)
+
+// handoverGrace is how long connections this process accepted just before
+// an upgrade get to send their first request before it shuts down.
+const handoverGrace = 500 * time.Millisecond
+
+// listenAll opens a socket for every listener, in order, taking over the
+// ones a previous process handed down and listening afresh on the rest.
+func listenAll(listeners []listener) ([]net.Listener, error) {
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	socks := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		lis, ok := inherited[l.name]
+		delete(inherited, l.name)
+		if !ok {
+			if lis, err = net.Listen("tcp", l.addr); err != nil {
+				err = fmt.Errorf("%s server: %w", l.name, err)
+				break
+			}
+		}
+		socks = append(socks, lis)
+	}
+	// Sockets for servers this configuration no longer runs are not needed.
+	for _, lis := range inherited {
+		lis.Close()
+	}
+	if err != nil {
+		for _, lis := range socks {
+			lis.Close()
+		}
+		return nil, err
+	}
+	return socks, nil
+}
+
+// inheritedListeners returns the sockets handed down by the process that
+// started this one, keyed by listener name.
+func inheritedListeners() (map[string]net.Listener, error) {
+	names, ok := os.LookupEnv(inheritedEnv)
+	os.Unsetenv(inheritedEnv)
+	if !ok || names == "" {
+		return nil, nil
+	}
+	socks := make(map[string]net.Listener)
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		lis, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, lis := range socks {
+				lis.Close()
+			}
+			return nil, fmt.Errorf("inherited %s socket: %w", name, err)
+		}
+		socks[name] = lis
+	}
+	return socks, nil
+}
+
+// notifyParent tells the process that started this one, if any, that it
+// is listening and can stop.
+func notifyParent() error {
+	fd, ok := os.LookupEnv(readyEnv)
+	os.Unsetenv(readyEnv)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", readyEnv, fd, err)
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// upgrade starts the executable again with the same arguments, handing it
+// socks, the sockets of listeners, and returns once it is listening on
+// them. If it has not done so within timeout it is killed and this
+// process carries on serving.
+func upgrade(listeners []listener, socks []net.Listener, timeout time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(listeners))
+	files := make([]*os.File, 0, len(socks)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, lis := range socks {
+		fl, ok := lis.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s socket cannot be handed over", listeners[i].name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		names[i] = listeners[i].name
+		files = append(files, f)
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, w)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		inheritedEnv+"="+strings.Join(names, ","),
+		readyEnv+"="+strconv.Itoa(3+len(socks)),
+	)
+	slog.Info("upgrading", "executable", exe)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Only the new process may hold the write end, so the read below also
+	// ends if it exits.
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready.SetReadDeadline(time.Now().Add(timeout))
+	n, err := ready.Read(make([]byte, 1))
+	if n == 1 {
+		slog.Info("new process is listening; draining", "pid", cmd.Process.Pid)
+		return cmd.Process.Release()
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("new process did not start listening within %s", timeout)
+	}
+	return fmt.Errorf("new process exited before listening: %s", cmd.ProcessState)
+}
+
+// handOver stops this process accepting connections on socks, leaving them
+// all to the new process, and waits handoverGrace: net/http drops a
+// connection whose first request arrives once shutdown has begun.
+func handOver(socks []net.Listener) {
+	for _, lis := range socks {
+		lis.Close()
+	}
+	time.Sleep(handoverGrace)
+}
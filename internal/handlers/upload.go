@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadHandler serves POST /upload and GET /files/:id.
+type uploadHandler struct {
+	store fileStore
+	// maxBytes bounds the size of an upload request.
+	maxBytes int64
+	// allowed lists the media types files may have, judged from their
+	// contents rather than what the client claims.
+	allowed []string
+}
+
+// postUpload stores the "file" part of a multipart/form-data request.
+func (u *uploadHandler) postUpload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, u.maxBytes)
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		writeProblem(c, http.StatusUnsupportedMediaType, "uploads must be multipart/form-data")
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			writeProblem(c, http.StatusBadRequest, "missing file", fieldError{Field: "file", Message: "is required"})
+			return
+		}
+		if err != nil {
+			u.rejectBody(c, err)
+			return
+		}
+		if part.FormName() == "file" {
+			u.save(c, part.FileName(), part)
+			return
+		}
+	}
+}
+
+// save checks the type of the upload in r and stores it under name.
+func (u *uploadHandler) save(c *gin.Context, name string, r io.Reader) {
+	br := bufio.NewReaderSize(r, sniffLimit)
+	peek, err := br.Peek(sniffLimit)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		u.rejectBody(c, err)
+		return
+	}
+	contentType := http.DetectContentType(peek)
+	if mt, _, _ := mime.ParseMediaType(contentType); !slices.Contains(u.allowed, mt) {
+		writeProblem(c, http.StatusUnsupportedMediaType, tr(c, "files of type %s are not accepted", mt))
+		return
+	}
+
+	info, err := u.store.save(c.Request.Context(), fileInfo{Name: name, ContentType: contentType}, br)
+	if err != nil {
+		u.rejectBody(c, err)
+		return
+	}
+	addLogField(c.Request.Context(), "file_id", info.ID)
+	c.Header("Location", strings.TrimSuffix(c.FullPath(), "upload")+"files/"+info.ID)
+	c.IndentedJSON(http.StatusCreated, info)
+}
+
+// rejectBody responds to a failure reading or storing an upload.
+func (u *uploadHandler) rejectBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(c, http.StatusRequestEntityTooLarge, tr(c, "uploads are limited to %d bytes", u.maxBytes))
+		return
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		writeProblem(c, http.StatusBadRequest, tr(c, "invalid multipart body: %v", err))
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), "upload failed", "err", err)
+	writeProblem(c, http.StatusInternalServerError, "internal error")
+}
+
+// getFile responds with a stored file, honoring Range and conditional
+// headers.
+func (u *uploadHandler) getFile(c *gin.Context) {
+	info, f, err := u.store.open(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errFileNotFound) {
+		writeProblem(c, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "file store failed", "err", err)
+		writeProblem(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer f.Close()
+
+	skipETag(c)
+	h := c.Writer.Header()
+	h.Set("Content-Type", info.ContentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("ETag", `"`+info.SHA256+`"`)
+	http.ServeContent(c.Writer, c.Request, "", info.CreatedAt, f)
+}
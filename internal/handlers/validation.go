@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// albumIDPattern is what the albumid validation tag accepts.
+var albumIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// fieldError describes why one field of a request body is invalid.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// args, if any, fill in Message, a format, once it is translated.
+	args []any
+}
+
+// setupValidation makes the binding validator report JSON field names and
+// registers the custom validation tags used by request types.
+func setupValidation() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected binding validator")
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v.RegisterValidation("albumid", func(fl validator.FieldLevel) bool {
+		return albumIDPattern.MatchString(fl.Field().String())
+	})
+}
+
+// bindBody decodes the request body into dst, a pointer to a struct or
+// slice of structs, and checks it against the binding tags. On failure it
+// responds with 415 for an unsupported Content-Type, 413 for a body over
+// MAX_BODY_BYTES or 400 and the offending fields, and returns false.
+func bindBody(c *gin.Context, dst any) bool {
+	return readBody(c, dst) && checkBody(c, dst)
+}
+
+// readBody is the decoding half of bindBody.
+func readBody(c *gin.Context, dst any) bool {
+	if err := decodeBody(c, dst); err != nil {
+		var be *bodyError
+		errors.As(err, &be)
+		writeProblem(c, be.status, tr(c, be.detail, be.args...), be.fields...)
+		return false
+	}
+	return true
+}
+
+// checkBody is the validating half of bindBody.
+func checkBody(c *gin.Context, dst any) bool {
+	if errs := validate(dst); len(errs) > 0 {
+		writeProblem(c, http.StatusBadRequest, "validation failed", errs...)
+		return false
+	}
+	return true
+}
+
+// validate checks v, a pointer to a struct or slice of structs, and
+// describes every invalid field. Fields of slice elements are prefixed
+// with their index.
+func validate(v any) []fieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice {
+		return fieldErrors("", binding.Validator.ValidateStruct(v))
+	}
+	var errs []fieldError
+	for i := 0; i < rv.Len(); i++ {
+		prefix := fmt.Sprintf("[%d].", i)
+		errs = append(errs, fieldErrors(prefix, binding.Validator.ValidateStruct(rv.Index(i).Addr().Interface()))...)
+	}
+	return errs
+}
+
+// fieldErrors converts a validator error into fieldErrors.
+func fieldErrors(prefix string, err error) []fieldError {
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return []fieldError{{Field: strings.TrimSuffix(prefix, "."), Message: err.Error()}}
+	}
+
+	errs := make([]fieldError, len(verrs))
+	for i, fe := range verrs {
+		// Drop the struct name the namespace starts with; anonymous
+		// structs have none.
+		field := fe.Namespace()
+		if _, rest, ok := strings.Cut(field, "."); ok {
+			field = rest
+		}
+		msg, args := validationMessage(fe)
+		errs[i] = fieldError{Field: prefix + field, Message: msg, args: args}
+	}
+	return errs
+}
+
+// validationMessage explains a failed validation tag, as a message to
+// translate and its arguments.
+func validationMessage(fe validator.FieldError) (string, []any) {
+	text := fe.Kind() == reflect.String
+	switch fe.Tag() {
+	case "required":
+		return "is required", nil
+	case "max", "lte":
+		if text {
+			return "must be at most %s characters", []any{fe.Param()}
+		}
+		return "must be at most %s", []any{fe.Param()}
+	case "min", "gte":
+		if text {
+			return "must be at least %s characters", []any{fe.Param()}
+		}
+		return "must be at least %s", []any{fe.Param()}
+	case "oneof":
+		return "must be one of %s", []any{strings.ReplaceAll(fe.Param(), " ", ", ")}
+	case "albumid":
+		return "may only contain letters, digits, '-' and '_'", nil
+	}
+	return "failed the %q check", []any{fe.Tag()}
+}
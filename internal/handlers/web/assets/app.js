@@ -0,0 +1,75 @@
+// Lists albums from /v1/albums, adds and deletes them, and refreshes the
+// list whenever /v1/events reports a change. Writes use the API key if one
+// is entered, else the session cookie, if any, with its CSRF token.
+const api = "/v1";
+const list = document.getElementById("albums");
+const error = document.getElementById("error");
+let csrfToken = "";
+
+function headers() {
+  const h = { "Content-Type": "application/json", "Accept": "application/json" };
+  const key = document.getElementById("key").value;
+  if (key) h["X-API-Key"] = key;
+  else if (csrfToken) h["X-CSRF-Token"] = csrfToken;
+  return h;
+}
+
+async function loadSession() {
+  const res = await fetch(api + "/session", { headers: { "Accept": "application/json" } });
+  if (res.ok) csrfToken = (await res.json()).csrf_token;
+}
+
+async function check(res) {
+  if (res.ok) return res;
+  const problem = await res.json().catch(() => ({}));
+  throw new Error(problem.detail || res.statusText);
+}
+
+function show(err) {
+  error.textContent = err ? err.message : "";
+}
+
+async function load() {
+  const res = await check(await fetch(api + "/albums?limit=100", { headers: { "Accept": "application/json" } }));
+  const page = await res.json();
+  list.replaceChildren(...page.data.map(row));
+}
+
+function row(a) {
+  const tr = document.createElement("tr");
+  for (const [value, cls] of [[a.id], [a.title], [a.artist], [a.price.toFixed(2), "price"]]) {
+    const td = document.createElement("td");
+    td.textContent = value;
+    if (cls) td.className = cls;
+    tr.append(td);
+  }
+  const del = document.createElement("button");
+  del.textContent = "Delete";
+  del.onclick = () => fetch(api + "/albums/" + encodeURIComponent(a.id), { method: "DELETE", headers: headers() })
+    .then(check).then(() => show(), show);
+  const td = document.createElement("td");
+  td.append(del);
+  tr.append(td);
+  return tr;
+}
+
+document.getElementById("add").onsubmit = async (e) => {
+  e.preventDefault();
+  const form = new FormData(e.target);
+  const album = { title: form.get("title"), artist: form.get("artist"), price: Number(form.get("price") || 0) };
+  try {
+    await check(await fetch(api + "/albums", { method: "POST", headers: headers(), body: JSON.stringify(album) }));
+    e.target.reset();
+    show();
+  } catch (err) {
+    show(err);
+  }
+};
+
+const events = new EventSource(api + "/events");
+for (const type of ["album.created", "album.updated", "album.deleted"]) {
+  events.addEventListener(type, () => load().catch(show));
+}
+
+loadSession().catch(() => {});
+load().catch(show);
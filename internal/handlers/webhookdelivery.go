@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"pspFileAPI/internal/httpclient"
+	"pspFileAPI/internal/notify"
+	"pspFileAPI/internal/service"
+)
+
+// Delivery states.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+const (
+	// webhookWorkers is how many deliveries are attempted at once.
+	webhookWorkers = 4
+	// webhookQueue is how many deliveries may wait for a worker.
+	webhookQueue = 256
+	// retryBase and retryMax bound the exponential backoff between
+	// attempts.
+	retryBase = time.Second
+	retryMax  = 5 * time.Minute
+	// maxScheduledReplays is how many times the webhook_retry job replays
+	// a delivery.
+	maxScheduledReplays = 3
+)
+
+// Headers sent with every delivery.
+const (
+	webhookIDHeader        = "X-Webhook-ID"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// errPrivateAddress rejects webhook URLs that resolve to this host or its
+// private networks.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// delivery is one event sent to one webhook.
+type delivery struct {
+	ID             string     `json:"id"`
+	WebhookID      string     `json:"webhook_id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+
+	body []byte
+	// retryable is set when the last attempt failed in a way a later one
+	// might not, so the webhook_retry job replays the delivery.
+	retryable bool
+	// replays counts the job's replays of the delivery.
+	replays int
+}
+
+// newWebhookDispatcher returns a dispatcher of the album events of events
+// that tries each delivery up to maxAttempts times, each bounded by
+// timeout. Unless allowPrivate is set, it refuses to connect to loopback,
+// private and link-local addresses, so webhooks cannot reach services
+// behind the firewall.
+func newWebhookDispatcher(events *service.Events, maxAttempts int, timeout time.Duration, allowPrivate bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	d := &webhookDispatcher{
+		// The dispatcher retries failed deliveries itself, recording each
+		// attempt, so the client makes one.
+		client: httpclient.New(httpclient.Options{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+			// A redirect could point anywhere; treat it as a failure.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}),
+		maxAttempts: maxAttempts,
+		queue:       make(chan *delivery, webhookQueue),
+		done:        make(chan struct{}),
+		hooks:       make(map[string]*webhook),
+		deliveries:  make(map[string]*delivery),
+	}
+	d.wg.Add(webhookWorkers + 1)
+	for i := 0; i < webhookWorkers; i++ {
+		go d.work()
+	}
+	go func() {
+		defer d.wg.Done()
+		events.Follow(d.done, d.notifyAlbum)
+	}()
+	return d
+}
+
+// notifyAlbum delivers an album event. Deletions carry only the album's
+// ID, as on /events.
+func (d *webhookDispatcher) notifyAlbum(e service.Event) {
+	var data any = e.Album
+	if e.Type == service.EventAlbumDeleted {
+		data = map[string]string{"id": e.Album.ID}
+	}
+	d.notify(e.Tenant, e.Type, data)
+}
+
+// jobFinished delivers the outcome of a background job.
+func (d *webhookDispatcher) jobFinished(j service.Job) {
+	typ := eventJobSucceeded
+	if j.Status == service.JobFailed {
+		typ = eventJobFailed
+	}
+	d.notify(j.Tenant, typ, j)
+}
+
+// notify delivers an event of type typ with data to every webhook of
+// tenant that subscribes to it.
+func (d *webhookDispatcher) notify(tenant, typ string, data any) {
+	now := time.Now().UTC()
+	body, err := json.Marshal(map[string]any{"type": typ, "created_at": now, "data": data})
+	if err != nil {
+		slog.Error("encoding webhook event", "event", typ, "err", err)
+		return
+	}
+
+	d.mu.Lock()
+	var queued []*delivery
+	for _, w := range d.hooks {
+		if w.tenant != tenant || !w.wants(typ) {
+			continue
+		}
+		dl := &delivery{ID: randomHex(8), WebhookID: w.ID, Event: typ, Status: deliveryPending, CreatedAt: now, UpdatedAt: now, body: body}
+		d.deliveries[dl.ID] = dl
+		d.order = append(d.order, dl.ID)
+		queued = append(queued, dl)
+	}
+	for len(d.order) > deliveryHistory {
+		delete(d.deliveries, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.mu.Unlock()
+
+	for _, dl := range queued {
+		d.enqueue(dl)
+	}
+}
+
+// enqueue hands dl to a worker. When the queue is full the delivery fails
+// at once and can be replayed later.
+func (d *webhookDispatcher) enqueue(dl *delivery) {
+	select {
+	case d.queue <- dl:
+	case <-d.done:
+	default:
+		d.finish(dl, deliveryFailed, 0, errors.New("delivery queue is full"))
+		d.mu.Lock()
+		dl.retryable = true
+		d.mu.Unlock()
+	}
+}
+
+// work attempts queued deliveries until the dispatcher closes.
+func (d *webhookDispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case dl := <-d.queue:
+			d.attempt(dl)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// attempt sends dl once and records the outcome, scheduling a retry with
+// exponential backoff after network errors, 408, 429 and 5xx responses.
+// Addresses refused as private are not retried.
+func (d *webhookDispatcher) attempt(dl *delivery) {
+	d.mu.Lock()
+	w, ok := d.hooks[dl.WebhookID]
+	dl.Attempts++
+	dl.NextAttemptAt = nil
+	attempts := dl.Attempts
+	d.mu.Unlock()
+	if !ok {
+		d.finish(dl, deliveryFailed, 0, errors.New("webhook was deleted"))
+		return
+	}
+
+	status, err := d.send(w, dl)
+	if err == nil {
+		d.finish(dl, deliverySucceeded, status, nil)
+		return
+	}
+	retryable := status == 0 && !errors.Is(err, errPrivateAddress) || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+	if !retryable || attempts >= d.maxAttempts {
+		d.finish(dl, deliveryFailed, status, err)
+		d.mu.Lock()
+		dl.retryable = retryable
+		d.mu.Unlock()
+		slog.Warn("webhook delivery failed", "webhook_id", w.ID, "delivery_id", dl.ID, "attempts", attempts, "err", err)
+		if d.notifier != nil {
+			d.notifier.Notify(notify.EventWebhookFailed, notify.WebhookFailed{
+				Tenant: w.tenant, WebhookID: w.ID, URL: w.URL, DeliveryID: dl.ID,
+				Event: dl.Event, Attempts: attempts, Error: err.Error(),
+			})
+		}
+		return
+	}
+
+	backoff := min(retryBase<<(attempts-1), retryMax)
+	backoff += time.Duration(rand.Int63n(int64(backoff) / 5))
+	next := time.Now().UTC().Add(backoff)
+	d.mu.Lock()
+	dl.ResponseStatus, dl.Error, dl.UpdatedAt, dl.NextAttemptAt = status, err.Error(), time.Now().UTC(), &next
+	d.mu.Unlock()
+	time.AfterFunc(backoff, func() { d.enqueue(dl) })
+}
+
+// send posts dl to w, signed with w's secret, and returns the response
+// status. Any status other than 2xx is an error.
+func (d *webhookDispatcher) send(w *webhook, dl *delivery) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", service.ServiceName+"-webhooks")
+	req.Header.Set(webhookIDHeader, dl.ID)
+	req.Header.Set(webhookEventHeader, dl.Event)
+	req.Header.Set(webhookTimestampHeader, ts)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.secret, ts, dl.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with secret. Signing the timestamp lets receivers reject replays.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// finish records the final outcome of dl.
+func (d *webhookDispatcher) finish(dl *delivery, status string, code int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.Status, dl.ResponseStatus, dl.UpdatedAt, dl.NextAttemptAt = status, code, time.Now().UTC(), nil
+	dl.Error = ""
+	if err != nil {
+		dl.Error = err.Error()
+	}
+}
+
+// retryFailed replays the failed deliveries whose last attempt failed with
+// a network error, 408, 429 or 5xx response, or a full queue, each up to
+// maxScheduledReplays times. The webhook_retry job runs it.
+func (d *webhookDispatcher) retryFailed(context.Context) error {
+	now := time.Now().UTC()
+	d.mu.Lock()
+	var due []*delivery
+	for _, id := range d.order {
+		dl := d.deliveries[id]
+		if dl.Status != deliveryFailed || !dl.retryable || dl.replays >= maxScheduledReplays {
+			continue
+		}
+		dl.replays++
+		dl.Status, dl.Attempts, dl.Error, dl.ResponseStatus, dl.retryable = deliveryPending, 0, "", 0, false
+		dl.UpdatedAt = now
+		due = append(due, dl)
+	}
+	d.mu.Unlock()
+
+	if len(due) > 0 {
+		slog.Info("retrying failed webhook deliveries", "count", len(due))
+	}
+	for _, dl := range due {
+		d.enqueue(dl)
+	}
+	return nil
+}
+
+// close stops delivering. Deliveries still pending are lost.
+func (d *webhookDispatcher) close() {
+	d.closeOnce.Do(func() { close(d.done) })
+	d.wg.Wait()
+}
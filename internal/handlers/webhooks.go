@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pspFileAPI/internal/notify"
+	"pspFileAPI/internal/tenant"
+)
+
+// Job event types, sent to webhooks when a background job finishes.
+const (
+	eventJobSucceeded = "job.succeeded"
+	eventJobFailed    = "job.failed"
+)
+
+// deliveryHistory is how many deliveries are kept for inspection and replay.
+const deliveryHistory = 1000
+
+// webhook is a registered callback URL.
+type webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events the webhook receives; empty means all of them.
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	secret string
+	// tenant is the tenant that registered the webhook. It only receives
+	// that tenant's events and only that tenant can see it.
+	tenant string
+}
+
+// wants reports whether w subscribes to events of type typ.
+func (w *webhook) wants(typ string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDispatcher keeps the registered webhooks and delivers events to
+// them. Webhooks and deliveries live in memory only.
+type webhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	queue       chan *delivery
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+	// notifier, when set, is told of each delivery given up on.
+	notifier *notify.Notifier
+
+	mu         sync.Mutex
+	hooks      map[string]*webhook
+	deliveries map[string]*delivery
+	// order lists delivery IDs oldest first, to drop the oldest.
+	order []string
+}
+
+// registerWebhook adds the webhook described by the JSON request body and
+// responds with it, including its signing secret, which is not shown
+// again.
+func (d *webhookDispatcher) registerWebhook(c *gin.Context) {
+	var req struct {
+		URL    string   `json:"url" binding:"required,http_url,max=2000"`
+		Events []string `json:"events" binding:"max=6,dive,oneof=album.created album.updated album.deleted album.restored job.succeeded job.failed"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	w := &webhook{ID: randomHex(8), URL: req.URL, Events: req.Events, CreatedAt: time.Now().UTC(), secret: randomHex(32), tenant: tenant.From(c.Request.Context())}
+	d.mu.Lock()
+	d.hooks[w.ID] = w
+	d.mu.Unlock()
+
+	addLogField(c.Request.Context(), "webhook_id", w.ID)
+	c.Header("Location", c.FullPath()+"/"+w.ID)
+	c.IndentedJSON(http.StatusCreated, struct {
+		webhook
+		Secret string `json:"secret"`
+	}{*w, w.secret})
+}
+
+// getWebhooks responds with the tenant's webhooks, without their secrets.
+func (d *webhookDispatcher) getWebhooks(c *gin.Context) {
+	name := tenant.From(c.Request.Context())
+	d.mu.Lock()
+	hooks := make([]webhook, 0, len(d.hooks))
+	for _, w := range d.hooks {
+		if w.tenant == name {
+			hooks = append(hooks, *w)
+		}
+	}
+	d.mu.Unlock()
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	c.IndentedJSON(http.StatusOK, hooks)
+}
+
+// deleteWebhook removes the tenant's webhook whose ID matches the id
+// parameter.
+// Deliveries still pending for it fail.
+func (d *webhookDispatcher) deleteWebhook(c *gin.Context) {
+	d.mu.Lock()
+	ok := d.hookOf(c, c.Param("id")) != nil
+	if ok {
+		delete(d.hooks, c.Param("id"))
+	}
+	d.mu.Unlock()
+	if !ok {
+		writeProblem(c, http.StatusNotFound, "webhook not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getDeliveries responds with the webhook's kept deliveries, newest
+// first, optionally only those with ?status=.
+func (d *webhookDispatcher) getDeliveries(c *gin.Context) {
+	id, status := c.Param("id"), c.Query("status")
+	switch status {
+	case "", deliveryPending, deliverySucceeded, deliveryFailed:
+	default:
+		writeProblem(c, http.StatusBadRequest, "invalid query parameters", fieldError{Field: "status", Message: "must be pending, succeeded or failed"})
+		return
+	}
+
+	d.mu.Lock()
+	ok := d.hookOf(c, id) != nil
+	list := []delivery{}
+	for i := len(d.order) - 1; i >= 0; i-- {
+		if dl := d.deliveries[d.order[i]]; dl.WebhookID == id && (status == "" || dl.Status == status) {
+			list = append(list, *dl)
+		}
+	}
+	d.mu.Unlock()
+	if !ok {
+		writeProblem(c, http.StatusNotFound, "webhook not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, list)
+}
+
+// replayDelivery sends a failed delivery again, with a fresh set of
+// attempts, and responds 202 with it.
+func (d *webhookDispatcher) replayDelivery(c *gin.Context) {
+	d.mu.Lock()
+	dl, ok := d.deliveries[c.Param("delivery")]
+	if !ok || dl.WebhookID != c.Param("id") || d.hookOf(c, dl.WebhookID) == nil {
+		d.mu.Unlock()
+		writeProblem(c, http.StatusNotFound, "delivery not found")
+		return
+	}
+	if dl.Status != deliveryFailed {
+		d.mu.Unlock()
+		writeProblem(c, http.StatusConflict, "only failed deliveries can be replayed")
+		return
+	}
+	dl.Status, dl.Attempts, dl.Error, dl.ResponseStatus = deliveryPending, 0, "", 0
+	dl.UpdatedAt = time.Now().UTC()
+	snapshot := *dl
+	d.mu.Unlock()
+
+	d.enqueue(dl)
+	c.IndentedJSON(http.StatusAccepted, snapshot)
+}
+
+// hookOf returns the webhook with id if it belongs to the request's
+// tenant, else nil. The caller must hold d.mu.
+func (d *webhookDispatcher) hookOf(c *gin.Context, id string) *webhook {
+	if w, ok := d.hooks[id]; ok && w.tenant == tenant.From(c.Request.Context()) {
+		return w
+	}
+	return nil
+}
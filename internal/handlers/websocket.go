@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/net/websocket"
+	"golang.org/x/text/language"
+
+	"pspFileAPI/internal/i18n"
+	"pspFileAPI/internal/service"
+	"pspFileAPI/internal/tenant"
+)
+
+// Keepalive of /ws connections. The server pings every socketPingInterval
+// and drops a connection it has read nothing from, not even a pong, for
+// socketIdleTimeout.
+const (
+	socketPingInterval = 30 * time.Second
+	socketIdleTimeout  = 75 * time.Second
+	// socketWriteTimeout is how long a client may take to take in each
+	// message.
+	socketWriteTimeout = 10 * time.Second
+)
+
+// socketOps are the operations a /ws message may ask for.
+var socketOps = []string{"ping", "list", "get", "create", "update", "delete", "subscribe"}
+
+// socketHandler serves /ws: album operations and events over a WebSocket,
+// one JSON message per request and reply.
+type socketHandler struct {
+	albums *albumHandler
+	events *service.Events
+	// auths identify the caller at the handshake; writes need one of them
+	// to succeed. With none, writes are open as over REST.
+	auths []authenticator
+	// origins are the CORS_ALLOWED_ORIGINS, which browsers on other
+	// origins than the API's own must be on to connect.
+	origins []string
+	// open counts the connections being served, for shutdown to wait on.
+	open sync.WaitGroup
+}
+
+// socketMessage is a message from the client. Ref is echoed in the reply
+// so the client can match replies to requests; id, album and query are
+// read by the operations they apply to.
+type socketMessage struct {
+	Ref   string            `json:"ref"`
+	Op    string            `json:"op"`
+	ID    string            `json:"id"`
+	Album *album            `json:"album"`
+	Query map[string]string `json:"query"`
+	// LastEventID makes subscribe replay the kept events after it first,
+	// as Last-Event-ID does on /events.
+	LastEventID uint64 `json:"last_event_id"`
+}
+
+// socketReply is a message from the server: the reply to a request, with
+// the status the request would have got over REST, or an event.
+type socketReply struct {
+	Ref    string       `json:"ref,omitempty"`
+	Op     string       `json:"op"`
+	Status int          `json:"status,omitempty"`
+	Album  *album       `json:"album,omitempty"`
+	Page   *socketPage  `json:"page,omitempty"`
+	Event  *socketEvent `json:"event,omitempty"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+	// args format Detail once it is translated.
+	args []any
+}
+
+// socketPage is the reply to a list.
+type socketPage struct {
+	Albums []album `json:"albums"`
+	Total  int     `json:"total"`
+	Page   int     `json:"page"`
+	Limit  int     `json:"limit"`
+}
+
+// socketEvent is an album event, as /events sends it.
+type socketEvent struct {
+	ID    uint64 `json:"id"`
+	Type  string `json:"type"`
+	Album any    `json:"album"`
+}
+
+// getSocket upgrades the request to a WebSocket and runs the operations the
+// client sends until either side closes it or the server shuts down.
+// Credentials, when sent, are checked at the handshake like over GraphQL.
+func (h *socketHandler) getSocket(c *gin.Context) {
+	if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") || c.Request.ProtoMajor != 1 {
+		c.Header("Upgrade", "websocket")
+		c.Header("Connection", "Upgrade")
+		writeProblem(c, http.StatusUpgradeRequired, "this endpoint only accepts WebSocket connections")
+		return
+	}
+	if !h.allowOrigin(c.Request) {
+		writeProblem(c, http.StatusForbidden, "origin not allowed")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(h.auths) > 0 {
+		id, err := authenticateAny(c.Request, h.auths)
+		switch {
+		case err == nil:
+			ctx = context.WithValue(ctx, identityKey{}, id)
+			addLogField(ctx, "user", id.subject)
+		case !errors.Is(err, errNoCredentials):
+			rejectAuth(c, h.auths, err)
+			return
+		}
+	}
+
+	limit := defaultMaxBodyBytes
+	if v, ok := c.Get(maxBodyBytesKey); ok {
+		limit = v.(int64)
+	}
+	h.open.Add(1)
+	defer h.open.Done()
+	skipETag(c)
+	// Logged and counted as the 101 the handshake answers.
+	c.Status(http.StatusSwitchingProtocols)
+	srv := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = int(limit)
+		h.serve(ctx, ws)
+	}}
+	srv.ServeHTTP(idleTimeoutWriter{c.Writer, socketIdleTimeout}, c.Request)
+}
+
+// allowOrigin reports whether r may open a socket: one from a client that
+// is not a browser, from a page on the API's own host or from an origin
+// CORS_ALLOWED_ORIGINS names. A wildcard does not count, as browsers send
+// cookies with WebSocket handshakes whatever the origin.
+func (h *socketHandler) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range h.origins {
+		if o != "*" && strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait waits for every socket to close, or for ctx to be done. Shutting an
+// http.Server down does not wait for the connections it handed over.
+func (h *socketHandler) wait(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		h.open.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// socketSession is one open socket.
+type socketSession struct {
+	h    *socketHandler
+	ctx  context.Context
+	lang language.Tag
+	ws   *websocket.Conn
+	// done is closed when the session ends, stopping its pings and events.
+	done chan struct{}
+
+	// mu serializes writes to ws.
+	mu         sync.Mutex
+	closed     bool
+	subscribed bool
+}
+
+// serve answers ws's messages in order until it closes.
+func (h *socketHandler) serve(ctx context.Context, ws *websocket.Conn) {
+	s := &socketSession{h: h, ctx: ctx, lang: i18n.FromContext(ctx), ws: ws, done: make(chan struct{})}
+	defer close(s.done)
+	defer s.close()
+	go s.keepAlive()
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		s.send(s.handle(msg))
+	}
+}
+
+// keepAlive pings the client every socketPingInterval, and closes the
+// socket with a last message when the server shuts down.
+func (s *socketSession) keepAlive() {
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ping.C:
+			if s.ping() != nil {
+				s.close()
+				return
+			}
+		case <-stopping(s.ctx):
+			s.send(socketReply{Op: "close", Status: http.StatusServiceUnavailable, Detail: "shutting down"})
+			s.close()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handle runs the operation msg asks for and returns the reply.
+func (s *socketSession) handle(msg []byte) socketReply {
+	var m socketMessage
+	if err := decodeJSON(msg, &m); err != nil {
+		var be *bodyError
+		if !errors.As(err, &be) {
+			be = &bodyError{status: http.StatusBadRequest, detail: "invalid body: %v", args: []any{err}}
+		}
+		return socketReply{Op: "error", Status: be.status, Detail: be.detail, args: be.args, Errors: be.fields}
+	}
+	reply := s.run(m)
+	reply.Ref = m.Ref
+	if reply.Op == "" {
+		reply.Op = m.Op
+	}
+	return reply
+}
+
+// run runs m's operation.
+func (s *socketSession) run(m socketMessage) socketReply {
+	ctx := s.ctx
+	switch m.Op {
+	case "ping":
+		return socketReply{Op: "pong"}
+	case "list":
+		v := make(url.Values, len(m.Query))
+		for k, val := range m.Query {
+			v.Set(k, val)
+		}
+		q, page, errs := parseListQuery(v)
+		if len(errs) > 0 {
+			return socketReply{Status: http.StatusBadRequest, Detail: "invalid query parameters", Errors: errs}
+		}
+		if q.IncludeDeleted {
+			if id := identityFrom(ctx); id == nil || !id.role.atLeast(roleAdmin) {
+				return socketReply{Status: http.StatusForbidden, Detail: "include_deleted needs the admin role"}
+			}
+		}
+		list, total, err := s.h.albums.albums.List(ctx, q)
+		if err != nil {
+			return socketStoreError(ctx, err)
+		}
+		return socketReply{Status: http.StatusOK, Page: &socketPage{Albums: list, Total: total, Page: page, Limit: q.Limit}}
+	case "get":
+		if m.ID == "" {
+			return socketReply{Status: http.StatusBadRequest, Detail: "validation failed", Errors: []fieldError{{Field: "id", Message: "is required"}}}
+		}
+		a, err := s.h.albums.albums.Get(ctx, m.ID)
+		if err != nil {
+			return socketStoreError(ctx, err)
+		}
+		return socketReply{Status: http.StatusOK, Album: &a}
+	case "create", "update", "delete":
+		if reply, ok := s.authorize(); !ok {
+			return reply
+		}
+		op := batchOperation{Op: m.Op, ID: m.ID, Album: m.Album}
+		if errs := fieldErrors("", binding.Validator.ValidateStruct(&op)); len(errs) > 0 {
+			return socketReply{Status: http.StatusBadRequest, Detail: "validation failed", Errors: errs}
+		}
+		res := s.h.albums.runBatchOperation(ctx, op)
+		return socketReply{Status: res.Status, Album: res.Album, Detail: res.Detail, Errors: res.Errors}
+	case "subscribe":
+		return s.subscribe(m.LastEventID)
+	}
+	return socketReply{Op: "error", Status: http.StatusBadRequest, Detail: "validation failed",
+		Errors: []fieldError{{Field: "op", Message: "must be one of %s", args: []any{strings.Join(socketOps, ", ")}}}}
+}
+
+// authorize refuses a write from an unidentified caller, or one whose role
+// may not change albums, when credentials are configured.
+func (s *socketSession) authorize() (socketReply, bool) {
+	if len(s.h.auths) == 0 {
+		return socketReply{}, true
+	}
+	id := identityFrom(s.ctx)
+	if id == nil {
+		return socketReply{Status: http.StatusUnauthorized, Detail: errNoCredentials.Error()}, false
+	}
+	if !id.role.atLeast(roleUser) {
+		return socketReply{Status: http.StatusForbidden, Detail: "this request needs the %s role", args: []any{roleUser}}, false
+	}
+	return socketReply{}, true
+}
+
+// socketStoreError is the reply to an operation the store refused.
+func socketStoreError(ctx context.Context, err error) socketReply {
+	status, detail := storeErrorStatus(ctx, err)
+	return socketReply{Status: status, Detail: detail}
+}
+
+// subscribe starts sending the tenant's album events, after the kept ones
+// following lastID. A client that falls too far behind is disconnected,
+// and reconnects with the last event id it saw.
+func (s *socketSession) subscribe(lastID uint64) socketReply {
+	s.mu.Lock()
+	already := s.subscribed
+	s.subscribed = true
+	s.mu.Unlock()
+	if already {
+		return socketReply{Status: http.StatusConflict, Detail: "already subscribed to events"}
+	}
+
+	replay, ch := s.h.events.Subscribe(tenant.From(s.ctx), lastID)
+	go func() {
+		defer s.h.events.Unsubscribe(ch)
+		for _, e := range replay {
+			if s.sendEvent(e) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok || s.sendEvent(e) != nil {
+					s.close()
+					return
+				}
+			case <-s.done:
+				return
+			case <-s.h.events.Done():
+				return
+			}
+		}
+	}()
+	return socketReply{Status: http.StatusOK}
+}
+
+// sendEvent sends e. Deletions carry only the album's ID.
+func (s *socketSession) sendEvent(e service.Event) error {
+	var payload any = e.Album
+	if e.Type == service.EventAlbumDeleted {
+		payload = gin.H{"id": e.Album.ID}
+	}
+	return s.send(socketReply{Op: "event", Event: &socketEvent{ID: e.ID, Type: e.Type, Album: payload}})
+}
+
+// send writes r, translated into the session's language.
+func (s *socketSession) send(r socketReply) error {
+	r.Detail = translate(s.lang, r.Detail, r.args...)
+	r.Errors = translateFields(s.lang, r.Errors)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.ws.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return websocket.JSON.Send(s.ws, r)
+}
+
+// ping writes a ping frame, which the client answers with a pong.
+func (s *socketSession) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.ws.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	w, err := s.ws.NewFrameWriter(websocket.PingFrame)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(nil); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// close sends a close frame, once, and closes the connection.
+func (s *socketSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.ws.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		s.ws.Close()
+	}
+}
+
+// idleTimeoutWriter hands the WebSocket server a connection on which every
+// read waits at most idle for data, so a client that sends nothing, not
+// even a pong, for that long is dropped.
+type idleTimeoutWriter struct {
+	gin.ResponseWriter
+	idle time.Duration
+}
+
+func (w idleTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	ic := idleConn{conn, w.idle}
+	// Read what the server buffered before the connection was handed over
+	// first.
+	buffered, _ := rw.Reader.Peek(rw.Reader.Buffered())
+	rw.Reader = bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), ic))
+	return ic, rw, nil
+}
+
+// idleConn is a connection whose reads time out after idle.
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.idle))
+	return c.Conn.Read(b)
+}
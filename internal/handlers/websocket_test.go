@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/service"
+)
+
+// serveSockets serves ts on a local port until t ends, returning the server
+// and its address.
+func serveSockets(t *testing.T, ts *testServer) (*http.Server, string) {
+	t.Helper()
+	srv := newServer(config.Config{}, ts.router)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+	return srv, lis.Addr().String()
+}
+
+// dialSocket opens /v1/ws on addr from a page on origin.
+func dialSocket(t *testing.T, addr, origin string) *websocket.Conn {
+	t.Helper()
+	ws, err := websocket.Dial("ws://"+addr+"/v1/ws", "", origin)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// exchange sends msg and returns the next message from the server.
+func exchange(t *testing.T, ws *websocket.Conn, msg string) socketReply {
+	t.Helper()
+	if err := websocket.Message.Send(ws, msg); err != nil {
+		t.Fatalf("send %s: %v", msg, err)
+	}
+	return receive(t, ws)
+}
+
+// receive returns the next message from the server.
+func receive(t *testing.T, ws *websocket.Conn) socketReply {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var r socketReply
+	if err := websocket.JSON.Receive(ws, &r); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return r
+}
+
+func TestSocketOperations(t *testing.T) {
+	ts := newTestServer(t, nil)
+	_, addr := serveSockets(t, ts)
+	ws := dialSocket(t, addr, "http://"+addr)
+
+	if r := exchange(t, ws, `{"ref": "1", "op": "ping"}`); r.Ref != "1" || r.Op != "pong" {
+		t.Errorf("ping answered %+v, want pong to ref 1", r)
+	}
+
+	r := exchange(t, ws, `{"ref": "2", "op": "get", "id": "2"}`)
+	if r.Status != http.StatusOK || r.Album == nil || r.Album.Title != "Jeru" {
+		t.Errorf("get answered %+v, want album 2", r)
+	}
+	if r := exchange(t, ws, `{"ref": "3", "op": "get", "id": "99"}`); r.Status != http.StatusNotFound {
+		t.Errorf("get of a missing album answered %d, want 404", r.Status)
+	}
+
+	if r := exchange(t, ws, `{"ref": "4", "op": "subscribe"}`); r.Status != http.StatusOK {
+		t.Fatalf("subscribe answered %+v", r)
+	}
+	r = exchange(t, ws, `{"ref": "5", "op": "create", "album": {"id": "4", "title": "Kind of Blue", "artist": "Miles Davis", "price": 9.99}}`)
+	if r.Op == "event" {
+		// The event may overtake the reply.
+		r = receive(t, ws)
+	} else if e := receive(t, ws); e.Event == nil || e.Event.Type != service.EventAlbumCreated {
+		t.Errorf("after create got %+v, want an album.created event", e)
+	}
+	if r.Ref != "5" || r.Status != http.StatusCreated {
+		t.Errorf("create answered %+v, want 201", r)
+	}
+
+	r = exchange(t, ws, `{"ref": "6", "op": "list", "query": {"sort": "price", "limit": "2"}}`)
+	if r.Status != http.StatusOK || r.Page == nil || r.Page.Total != 4 || len(r.Page.Albums) != 2 || r.Page.Albums[0].ID != "4" {
+		t.Errorf("list answered %+v, want the 2 cheapest of 4 albums", r)
+	}
+	if r := exchange(t, ws, `{"ref": "7", "op": "list", "query": {"limit": "0"}}`); r.Status != http.StatusBadRequest || len(r.Errors) == 0 {
+		t.Errorf("list with limit 0 answered %+v, want 400 with field errors", r)
+	}
+
+	if r := exchange(t, ws, `{"ref": "8", "op": "dance"}`); r.Status != http.StatusBadRequest || len(r.Errors) != 1 || r.Errors[0].Field != "op" {
+		t.Errorf("unknown op answered %+v, want 400 naming op", r)
+	}
+	if r := exchange(t, ws, `{"ref": "9", "op": `); r.Op != "error" || r.Status != http.StatusBadRequest {
+		t.Errorf("malformed message answered %+v, want a 400 error", r)
+	}
+}
+
+func TestSocketWritesNeedCredentials(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"ADMIN_TOKEN": "secret-token"})
+	_, addr := serveSockets(t, ts)
+	ws := dialSocket(t, addr, "http://"+addr)
+
+	if r := exchange(t, ws, `{"op": "delete", "id": "1"}`); r.Status != http.StatusUnauthorized {
+		t.Errorf("anonymous delete answered %+v, want 401", r)
+	}
+	if r := exchange(t, ws, `{"op": "get", "id": "1"}`); r.Status != http.StatusOK {
+		t.Errorf("anonymous get answered %+v, want 200", r)
+	}
+}
+
+func TestSocketHandshake(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example.com"})
+	wantStatus(t, ts.do(http.MethodGet, "/v1/ws", ""), http.StatusUpgradeRequired)
+
+	_, addr := serveSockets(t, ts)
+	dialSocket(t, addr, "https://app.example.com")
+	if _, err := websocket.Dial("ws://"+addr+"/v1/ws", "", "https://evil.example.com"); err == nil {
+		t.Error("a page on another origin opened a socket")
+	}
+}
+
+func TestSocketClosesOnShutdown(t *testing.T) {
+	ts := newTestServer(t, nil)
+	srv, addr := serveSockets(t, ts)
+	ws := dialSocket(t, addr, "http://"+addr)
+	if r := exchange(t, ws, `{"op": "ping"}`); r.Op != "pong" {
+		t.Fatalf("ping answered %+v", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if r := receive(t, ws); r.Op != "close" || r.Status != http.StatusServiceUnavailable {
+		t.Errorf("on shutdown got %+v, want a 503 close", r)
+	}
+	var msg []byte
+	if err := websocket.Message.Receive(ws, &msg); !errors.Is(err, io.EOF) {
+		t.Errorf("socket not closed cleanly after shutdown: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package health collects the checks of the dependencies an instance needs,
+// so readiness can report on each of them.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Overall and per-check statuses.
+const (
+	StatusReady       = "ready"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusPass        = "pass"
+	StatusFail        = "fail"
+)
+
+// Checker reports whether a dependency is usable, returning nil if so. It
+// must give up once ctx is done.
+type Checker func(ctx context.Context) error
+
+// Registry holds named checks and runs them on demand. It is safe for
+// concurrent use.
+type Registry struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]check
+}
+
+// check is a registered Checker and whether the instance is unusable
+// without its dependency.
+type check struct {
+	fn       Checker
+	critical bool
+}
+
+// NewRegistry returns an empty registry that gives each check timeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout, checks: make(map[string]check)}
+}
+
+// Register adds fn under name, replacing any check of that name. A failing
+// critical check makes the instance unavailable; any other failing check
+// only degrades it.
+func (r *Registry) Register(name string, critical bool, fn Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check{fn: fn, critical: critical}
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Latency  float64 `json:"latency_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check, by name.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Check runs every check at once, each bounded by the registry's timeout,
+// and reports on them.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.Lock()
+	checks := make(map[string]check, len(r.checks))
+	for name, c := range r.checks {
+		checks[name] = c
+	}
+	r.mu.Unlock()
+
+	results := make([]Result, 0, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c check) {
+			defer wg.Done()
+			res := r.run(ctx, c)
+			res.Name = name
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	report := Report{Status: StatusReady, Checks: results}
+	for _, res := range results {
+		switch {
+		case res.Status == StatusPass:
+		case res.Critical:
+			report.Status = StatusUnavailable
+		case report.Status == StatusReady:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run runs c within the timeout. A check that ignores its context is
+// abandoned, and reported failed, once the timeout passes.
+func (r *Registry) run(ctx context.Context, c check) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.fn(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{Status: StatusPass, Critical: c.critical, Latency: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status, res.Error = StatusFail, err.Error()
+	}
+	return res
+}
+
+// DiskSpace returns a check that fails when the file system holding dir
+// has less than minFree bytes free for unprivileged use.
+func DiskSpace(dir string, minFree uint64) Checker {
+	return func(context.Context) error {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return err
+		}
+		if free := st.Bavail * uint64(st.Bsize); free < minFree {
+			return fmt.Errorf("%d bytes free, below %d", free, minFree)
+		}
+		return nil
+	}
+}
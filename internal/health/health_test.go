@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSlowCheckTimesOutWithoutBlockingOthers(t *testing.T) {
+	r := NewRegistry(50 * time.Millisecond)
+	r.Register("hung", false, func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	r.Register("db", true, func(context.Context) error { return nil })
+	r.Register("redis", true, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	report := r.Check(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Check took %v, want about the 50ms timeout", elapsed)
+	}
+	if len(report.Checks) != 3 {
+		t.Fatalf("report %+v, want 3 checks", report)
+	}
+	for _, res := range report.Checks {
+		want := StatusFail
+		if res.Name == "db" {
+			want = StatusPass
+		}
+		if res.Status != want {
+			t.Errorf("check %s: %s, want %s", res.Name, res.Status, want)
+		}
+		if want == StatusFail && res.Error != context.DeadlineExceeded.Error() {
+			t.Errorf("check %s: error %q, want a timeout", res.Name, res.Error)
+		}
+	}
+	if report.Status != StatusUnavailable {
+		t.Errorf("status %s with a critical check timed out, want %s", report.Status, StatusUnavailable)
+	}
+}
+
+func TestOptionalCheckFailureDegrades(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register("db", true, func(context.Context) error { return nil })
+	if got := r.Check(context.Background()).Status; got != StatusReady {
+		t.Errorf("status %s with every check passing, want %s", got, StatusReady)
+	}
+
+	r.Register("upload_dir", false, func(context.Context) error { return errors.New("disk full") })
+	report := r.Check(context.Background())
+	if report.Status != StatusDegraded {
+		t.Errorf("status %s with an optional check failing, want %s", report.Status, StatusDegraded)
+	}
+	if res := report.Checks[1]; res.Name != "upload_dir" || res.Error != "disk full" {
+		t.Errorf("result %+v, want upload_dir failing with its error", res)
+	}
+}
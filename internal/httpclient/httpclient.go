@@ -0,0 +1,221 @@
+// Package httpclient builds the HTTP clients the service calls other
+// services with. Each attempt gets its own timeout, and failed attempts of
+// requests that are safe to repeat are retried with jittered exponential
+// backoff, within a budget so retries cannot multiply an outage.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configures a client. The zero value makes single attempts with
+// no timeout over http.DefaultTransport.
+type Options struct {
+	// Timeout bounds each attempt, from sending the request to reading the
+	// whole response body. Zero means no limit.
+	Timeout time.Duration
+	// MaxAttempts is how many times a request is tried; values below one
+	// mean once.
+	MaxAttempts int
+	// BaseDelay is the longest wait before the first retry, doubled for
+	// each one after it up to MaxDelay. The actual wait is a random share
+	// of it, so clients that failed together do not retry together.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget, if set, limits how many retries clients sharing it make.
+	Budget *Budget
+	// Transport makes each attempt; nil means http.DefaultTransport.
+	Transport http.RoundTripper
+	// CheckRedirect is the client's redirect policy; nil follows up to ten.
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+}
+
+// New returns a client that retries as opts say.
+func New(opts Options) *http.Client {
+	return &http.Client{Transport: NewTransport(opts), CheckRedirect: opts.CheckRedirect}
+}
+
+// NewTransport returns a round tripper that retries as opts say, for use
+// where an *http.Client is not taken.
+func NewTransport(opts Options) http.RoundTripper {
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &transport{opts: opts}
+}
+
+// transport is a round tripper making up to opts.MaxAttempts attempts.
+type transport struct {
+	opts Options
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.opts.Budget.deposit()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+		if attempt >= t.opts.MaxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+		wait, ok := t.backoff(attempt, resp)
+		if !ok || !t.opts.Budget.withdraw() {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain a little so the connection can be reused.
+			io.CopyN(io.Discard, resp.Body, 4<<10)
+			resp.Body.Close()
+		}
+		body, gerr := rewind(req)
+		if gerr != nil {
+			return nil, gerr
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+}
+
+// attempt makes one attempt within the per-attempt timeout. The timeout
+// keeps running while the caller reads the body, and is released when it
+// is closed.
+func (t *transport) attempt(req *http.Request) (*http.Response, error) {
+	if t.opts.Timeout <= 0 {
+		return t.opts.Transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.Timeout)
+	resp, err := t.opts.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns how long to wait before retrying after attempt: a
+// random duration up to the exponential delay for attempt, or the
+// response's Retry-After. It reports false if Retry-After asks for longer
+// than MaxDelay.
+func (t *transport) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			d := time.Duration(s) * time.Second
+			return d, t.opts.MaxDelay <= 0 || d <= t.opts.MaxDelay
+		}
+	}
+	d := t.opts.BaseDelay << (attempt - 1)
+	if d <= 0 || (t.opts.MaxDelay > 0 && d > t.opts.MaxDelay) {
+		d = t.opts.MaxDelay
+	}
+	if d <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1), true
+}
+
+// shouldRetry reports whether req may be tried again after it got resp or
+// err. Only requests that are idempotent by method, or carry an
+// Idempotency-Key, are repeated, and only after failures another attempt
+// may fix: connection errors, timeouts of the attempt, and 429, 502, 503
+// and 504 responses.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil || !idempotent(req) {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// idempotent reports whether sending req twice has the effect of sending
+// it once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// rewind returns a fresh copy of req's body for another attempt.
+func rewind(req *http.Request) (io.ReadCloser, error) {
+	if req.GetBody == nil {
+		return req.Body, nil
+	}
+	return req.GetBody()
+}
+
+// cancelOnClose releases an attempt's timeout once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Budget caps retries at a share of the requests made, shared by every
+// client it is given to. Each request earns ratio of a retry, up to max
+// retries saved up; each retry spends one.
+type Budget struct {
+	ratio, max float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// NewBudget returns a budget allowing retries for ratio of requests, with
+// up to max retries saved for bursts, starting full.
+func NewBudget(ratio, max float64) *Budget {
+	return &Budget{ratio: ratio, max: max, tokens: max}
+}
+
+// deposit earns the budget its share of a retry for a request.
+func (b *Budget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
+
+// withdraw takes one retry from the budget, reporting whether there was
+// one. A nil budget always has one.
+func (b *Budget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,110 @@
+// Package i18n translates the messages the API answers with into the
+// language a request asks for. Messages are written in English and are
+// their own keys: a catalog maps each to its translation, and messages a
+// catalog lacks stay in English.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// English is the language messages are written in, and the one answered
+// with when none of the requested ones is supported.
+var English = language.English
+
+var (
+	// catalogs holds the translations into each supported language but
+	// English, by language.
+	catalogs = make(map[language.Tag]map[string]string)
+	// matcher picks the supported language closest to those requested.
+	matcher language.Matcher
+	// supported lists English and then each catalog's language.
+	supported = []language.Tag{English}
+)
+
+func init() {
+	files, err := catalogFiles.ReadDir("catalogs")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), path.Ext(f.Name()))
+		tag, err := language.Parse(name)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", f.Name(), err))
+		}
+		data, err := catalogFiles.ReadFile(path.Join("catalogs", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		msgs := make(map[string]string)
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", f.Name(), err))
+		}
+		if tag != English {
+			catalogs[tag] = msgs
+			supported = append(supported, tag)
+		}
+	}
+	matcher = language.NewMatcher(supported)
+}
+
+// Supported returns the languages there are catalogs for, English first.
+func Supported() []language.Tag {
+	return append([]language.Tag(nil), supported...)
+}
+
+// Match returns the supported language that best fits an Accept-Language
+// value, falling back from regional variants to their language and then
+// to English.
+func Match(acceptLanguage string) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, conf := matcher.Match(tags...)
+	if conf == language.No {
+		return English
+	}
+	return supported[i]
+}
+
+// T returns msg in lang, or msg itself if lang has no translation for it.
+func T(lang language.Tag, msg string) string {
+	for ; lang != language.Und; lang = lang.Parent() {
+		if s, ok := catalogs[lang][msg]; ok {
+			return s
+		}
+	}
+	return msg
+}
+
+// Sprintf formats args with format translated into lang. Translations may
+// reorder the arguments with explicit indexes such as %[2]s.
+func Sprintf(lang language.Tag, format string, args ...any) string {
+	return fmt.Sprintf(T(lang, format), args...)
+}
+
+// key is the context key for the language a request is answered in.
+type key struct{}
+
+// NewContext returns ctx answering in lang.
+func NewContext(ctx context.Context, lang language.Tag) context.Context {
+	return context.WithValue(ctx, key{}, lang)
+}
+
+// FromContext returns the language the request carrying ctx is answered
+// in, English if none was chosen.
+func FromContext(ctx context.Context) language.Tag {
+	if lang, ok := ctx.Value(key{}).(language.Tag); ok {
+		return lang
+	}
+	return English
+}
@@ -0,0 +1,316 @@
+// Package notify emails operators when something needs their attention,
+// such as an album priced above a threshold or a webhook that could not be
+// delivered. Messages are rendered from templates and sent in the
+// background, with retries, through a pluggable Sender.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/service"
+)
+
+// Events notifications are sent for.
+const (
+	// EventPriceAbove is sent for each album added or replaced with a
+	// price above NOTIFY_PRICE_THRESHOLD, with PriceAbove as its data.
+	EventPriceAbove = "album.price_above"
+	// EventWebhookFailed is sent for each webhook delivery given up on,
+	// with WebhookFailed as its data.
+	EventWebhookFailed = "webhook.failed"
+)
+
+// defaultEvents lists the events there are default templates for.
+var defaultEvents = []string{EventPriceAbove, EventWebhookFailed}
+
+const (
+	// notifyWorkers is how many messages are sent at once.
+	notifyWorkers = 2
+	// notifyQueue is how many messages may wait for a worker.
+	notifyQueue = 256
+	// retryBase and retryMax bound the exponential backoff between
+	// attempts.
+	retryBase = 5 * time.Second
+	retryMax  = 10 * time.Minute
+)
+
+//go:embed templates/*.txt
+var templateFiles embed.FS
+
+// PriceAbove is the data of album.price_above templates.
+type PriceAbove struct {
+	Tenant    string
+	Album     repository.Album
+	Threshold float64
+}
+
+// WebhookFailed is the data of webhook.failed templates.
+type WebhookFailed struct {
+	Tenant     string
+	WebhookID  string
+	URL        string
+	DeliveryID string
+	Event      string
+	Attempts   int
+	Error      string
+}
+
+// Message is an email to send.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages. Send must give up once ctx is done. An error
+// that is Permanent is not retried.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// permanentError marks errors retrying would not fix.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks err as one retrying would not fix.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// isPermanent reports whether err was marked Permanent or is an SMTP 5xx
+// reply.
+func isPermanent(err error) bool {
+	var p permanentError
+	var reply *textproto.Error
+	return errors.As(err, &p) || errors.As(err, &reply) && reply.Code >= 500
+}
+
+// openSender returns the Sender NOTIFY_PROVIDER selects.
+func openSender(cfg config.Config) (Sender, error) {
+	switch cfg.NotifyProvider {
+	case "smtp":
+		return newSMTPSender(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	case "log":
+		return logSender{}, nil
+	}
+	return nil, fmt.Errorf("NOTIFY_PROVIDER %q must be \"smtp\" or \"log\"", cfg.NotifyProvider)
+}
+
+// outgoing is a rendered message and how often it has been tried.
+type outgoing struct {
+	Message
+	event    string
+	attempts int
+}
+
+// Notifier renders the events NOTIFY_EVENTS names into messages to
+// NOTIFY_TO and sends them in the background. It is safe for concurrent
+// use.
+type Notifier struct {
+	sender      Sender
+	from        string
+	to          []string
+	events      map[string]bool
+	templates   *template.Template
+	timeout     time.Duration
+	maxAttempts int
+
+	queue     chan *outgoing
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Open starts a Notifier sending through the provider NOTIFY_PROVIDER
+// selects and, with NOTIFY_PRICE_THRESHOLD set, watching the album events
+// of events for prices above it.
+func Open(cfg config.Config, events *service.Events) (*Notifier, error) {
+	sender, err := openSender(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return New(sender, cfg, events)
+}
+
+// New is Open with messages sent through sender instead.
+func New(sender Sender, cfg config.Config, events *service.Events) (*Notifier, error) {
+	tmpl, err := loadTemplates(cfg.NotifyTemplateDir)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{
+		sender:      sender,
+		from:        cfg.NotifyFrom,
+		to:          cfg.NotifyTo,
+		events:      make(map[string]bool),
+		templates:   tmpl,
+		timeout:     cfg.SMTPTimeout,
+		maxAttempts: cfg.NotifyMaxAttempts,
+		queue:       make(chan *outgoing, notifyQueue),
+		done:        make(chan struct{}),
+	}
+	for _, e := range cfg.NotifyEvents {
+		if tmpl.Lookup(e+".txt") == nil {
+			return nil, fmt.Errorf("NOTIFY_EVENTS entry %q must be one of %s or have a template in NOTIFY_TEMPLATE_DIR", e, strings.Join(defaultEvents, ", "))
+		}
+		n.events[e] = true
+	}
+
+	n.wg.Add(notifyWorkers)
+	for i := 0; i < notifyWorkers; i++ {
+		go n.work()
+	}
+	if threshold := cfg.NotifyPriceThreshold; threshold > 0 && n.events[EventPriceAbove] {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			events.Follow(n.done, func(e service.Event) { n.checkPrice(e, threshold) })
+		}()
+	}
+	return n, nil
+}
+
+// loadTemplates parses the default templates and then those in dir, if
+// given, which replace defaults of the same name.
+func loadTemplates(dir string) (*template.Template, error) {
+	tmpl, err := template.New("").ParseFS(templateFiles, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return tmpl, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil || len(files) == 0 {
+		return tmpl, err
+	}
+	if tmpl, err = tmpl.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("NOTIFY_TEMPLATE_DIR: %w", err)
+	}
+	return tmpl, nil
+}
+
+// checkPrice notifies of albums added or replaced with a price above
+// threshold.
+func (n *Notifier) checkPrice(e service.Event, threshold float64) {
+	if (e.Type == service.EventAlbumCreated || e.Type == service.EventAlbumUpdated) && e.Album.Price > threshold {
+		n.Notify(EventPriceAbove, PriceAbove{Tenant: e.Tenant, Album: e.Album, Threshold: threshold})
+	}
+}
+
+// Notify renders the template of event with data and queues the message,
+// unless NOTIFY_EVENTS leaves event out. It does not wait for the message
+// to be sent; when the queue is full the message is dropped.
+func (n *Notifier) Notify(event string, data any) {
+	if !n.events[event] {
+		return
+	}
+	var buf bytes.Buffer
+	if err := n.templates.ExecuteTemplate(&buf, event+".txt", data); err != nil {
+		slog.Error("rendering notification", "event", event, "err", err)
+		return
+	}
+	// The first line is the subject and the rest the body.
+	subject, body, _ := strings.Cut(buf.String(), "\n")
+	m := &outgoing{
+		Message: Message{From: n.from, To: n.to, Subject: strings.TrimSpace(subject), Body: strings.TrimLeft(body, "\n")},
+		event:   event,
+	}
+	n.enqueue(m)
+}
+
+// enqueue hands m to a worker, dropping it when the queue is full or the
+// Notifier is closing.
+func (n *Notifier) enqueue(m *outgoing) {
+	select {
+	case <-n.done:
+		slog.Warn("notification dropped; shutting down", "event", m.event, "subject", m.Subject)
+		return
+	default:
+	}
+	select {
+	case n.queue <- m:
+	default:
+		slog.Warn("notification dropped; queue is full", "event", m.event, "subject", m.Subject)
+	}
+}
+
+// work sends queued messages until the Notifier closes, and then those
+// still queued.
+func (n *Notifier) work() {
+	defer n.wg.Done()
+	for {
+		select {
+		case m := <-n.queue:
+			n.attempt(m)
+		case <-n.done:
+			for {
+				select {
+				case m := <-n.queue:
+					n.attempt(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// attempt sends m once, and schedules a retry with exponential backoff if
+// that fails and m has attempts left and the error is not permanent.
+func (n *Notifier) attempt(m *outgoing) {
+	m.attempts++
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	err := n.sender.Send(ctx, m.Message)
+	cancel()
+	if err == nil {
+		slog.Debug("notification sent", "event", m.event, "to", m.To, "attempts", m.attempts)
+		return
+	}
+	if isPermanent(err) || m.attempts >= n.maxAttempts {
+		slog.Error("notification not sent", "event", m.event, "to", m.To, "attempts", m.attempts, "err", err)
+		return
+	}
+	backoff := min(retryBase<<(m.attempts-1), retryMax)
+	slog.Warn("notification failed; retrying", "event", m.event, "attempts", m.attempts, "retry_in", backoff, "err", err)
+	time.AfterFunc(backoff, func() { n.enqueue(m) })
+}
+
+// Close stops following album events and waits up to timeout for the
+// queued messages to be sent. Retries not yet due are dropped.
+func (n *Notifier) Close(timeout time.Duration) {
+	n.closeOnce.Do(func() { close(n.done) })
+	stopped := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		slog.Error("notifications not sent", "count", len(n.queue), "err", "timed out")
+	}
+}
+
+// logSender logs messages instead of sending them, for development.
+type logSender struct{}
+
+func (logSender) Send(ctx context.Context, m Message) error {
+	slog.InfoContext(ctx, "notification", "from", m.From, "to", m.To, "subject", m.Subject, "body", m.Body)
+	return nil
+}
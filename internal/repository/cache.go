@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/tenant"
+)
+
+// cachedAlbumStore serves gets and lists through a cache and invalidates
+// it on writes. Albums are cached by tenant and ID; lists are cached under
+// the tenant's current list generation, which every write bumps, so one
+// increment drops every cached page. Lists that include deleted albums are
+// not cached, so purges need not invalidate anything.
+type cachedAlbumStore struct {
+	Repository
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// listGenerationKey is the counter naming tenant's current cached lists.
+func listGenerationKey(tenant string) string { return "albums:" + tenant + ":list:generation" }
+
+// albumKey is the cache key of tenant's album with id.
+func albumKey(tenant, id string) string { return "albums:" + tenant + ":id:" + id }
+
+// cachedPage is a list result as cached.
+type cachedPage struct {
+	Albums []Album `json:"albums"`
+	Total  int     `json:"total"`
+}
+
+func (s *cachedAlbumStore) Get(ctx context.Context, id string) (Album, error) {
+	return cache.Cached(ctx, s.cache, albumKey(tenant.From(ctx), id), s.ttl, func() (Album, error) {
+		return s.Repository.Get(ctx, id)
+	})
+}
+
+func (s *cachedAlbumStore) List(ctx context.Context, q ListQuery) ([]Album, int, error) {
+	if q.IncludeDeleted {
+		return s.Repository.List(ctx, q)
+	}
+	name := tenant.From(ctx)
+	gen, err := s.cache.Counter(ctx, listGenerationKey(name))
+	if err != nil {
+		// Without the generation a cached page may be stale.
+		slog.WarnContext(ctx, "cache get failed", "key", listGenerationKey(name), "err", err)
+		cache.Record(ctx, false)
+		return s.Repository.List(ctx, q)
+	}
+	key := "albums:" + name + ":list:" + strconv.FormatInt(gen, 10) + ":" + q.cacheKey()
+	p, err := cache.Cached(ctx, s.cache, key, s.ttl, func() (cachedPage, error) {
+		list, total, err := s.Repository.List(ctx, q)
+		return cachedPage{list, total}, err
+	})
+	return p.Albums, p.Total, err
+}
+
+func (s *cachedAlbumStore) Create(ctx context.Context, albums ...Album) ([]Album, error) {
+	created, err := s.Repository.Create(ctx, albums...)
+	if err == nil {
+		s.invalidate(ctx)
+	}
+	return created, err
+}
+
+func (s *cachedAlbumStore) Update(ctx context.Context, id string, a Album) (Album, error) {
+	updated, err := s.Repository.Update(ctx, id, a)
+	if err == nil {
+		s.invalidate(ctx, id)
+	}
+	return updated, err
+}
+
+func (s *cachedAlbumStore) Delete(ctx context.Context, id string) error {
+	err := s.Repository.Delete(ctx, id)
+	if err == nil {
+		s.invalidate(ctx, id)
+	}
+	return err
+}
+
+func (s *cachedAlbumStore) Restore(ctx context.Context, id string) (Album, error) {
+	restored, err := s.Repository.Restore(ctx, id)
+	if err == nil {
+		s.invalidate(ctx, id)
+	}
+	return restored, err
+}
+
+func (s *cachedAlbumStore) Close() error {
+	return errors.Join(s.Repository.Close(), s.cache.Close())
+}
+
+// invalidate drops the cached albums with ids and every cached list of
+// ctx's tenant.
+func (s *cachedAlbumStore) invalidate(ctx context.Context, ids ...string) {
+	name := tenant.From(ctx)
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = albumKey(name, id)
+	}
+	if len(keys) > 0 {
+		if err := s.cache.Delete(ctx, keys...); err != nil {
+			slog.WarnContext(ctx, "cache invalidation failed", "keys", keys, "err", err)
+		}
+	}
+	if err := s.cache.Incr(ctx, listGenerationKey(name)); err != nil {
+		slog.WarnContext(ctx, "cache invalidation failed", "key", listGenerationKey(name), "err", err)
+	}
+}
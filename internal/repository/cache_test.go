@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/config"
+)
+
+// newCachedMemory returns a memory repository seeded with seed behind a
+// cachedAlbumStore.
+func newCachedMemory(t *testing.T, seed ...Album) Repository {
+	t.Helper()
+	c, err := cache.Open(context.Background(), config.Config{CacheSize: 100}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &cachedAlbumStore{Repository: NewMemory(seed), cache: c, ttl: time.Minute}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCachedReadsRecordStatus(t *testing.T) {
+	repo := newCachedMemory(t, Album{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99})
+	reads := map[string]func(ctx context.Context) error{
+		"Get": func(ctx context.Context) error {
+			_, err := repo.Get(ctx, "1")
+			return err
+		},
+		"List": func(ctx context.Context) error {
+			_, _, err := repo.List(ctx, ListQuery{Limit: 10})
+			return err
+		},
+	}
+	for name, read := range reads {
+		for _, want := range []string{"MISS", "HIT"} {
+			ctx, status := cache.WithStatus(context.Background())
+			if err := read(ctx); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got := status.String(); got != want {
+				t.Errorf("%s: status %q, want %q", name, got, want)
+			}
+		}
+	}
+
+	// A write invalidates the cached list.
+	if _, err := repo.Update(context.Background(), "1", Album{Title: "Blue Train", Artist: "John Coltrane", Price: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, status := cache.WithStatus(context.Background())
+	if err := reads["List"](ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := status.String(); got != "MISS" {
+		t.Errorf("List after Update: status %q, want MISS", got)
+	}
+}
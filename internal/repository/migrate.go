@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations holds one directory of versioned SQL files per dialect, named
+// like 0001_create_albums.sql, each with a 0001_create_albums.down.sql
+// undoing it.
+//
+//go:embed migrations
+var migrations embed.FS
+
+// downSuffix ends the names of the files undoing migrations.
+const downSuffix = ".down.sql"
+
+// migration is one schema change.
+type migration struct {
+	version int
+	name    string
+	sql     string
+	// down undoes sql; empty if the migration cannot be undone.
+	down string
+}
+
+// loadMigrations returns the migrations for d ordered by version.
+func loadMigrations(d Dialect) ([]migration, error) {
+	dir := path.Join("migrations", d.name)
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migration
+	downs := make(map[int]string)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", e.Name())
+		}
+		b, err := fs.ReadFile(migrations, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(e.Name(), downSuffix) {
+			downs[version] = string(b)
+			continue
+		}
+		list = append(list, migration{version: version, name: e.Name(), sql: string(b)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	for i := range list {
+		if i > 0 && list[i].version == list[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", list[i-1].name, list[i].name, list[i].version)
+		}
+		list[i].down = downs[list[i].version]
+	}
+	return list, nil
+}
+
+// appliedMigrations returns the versions schema_migrations records,
+// creating the table if need be.
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// applyMigrations brings the schema of db up to date, running each pending
+// migration in its own transaction.
+func applyMigrations(ctx context.Context, db *sql.DB, d Dialect) error {
+	list, err := loadMigrations(d)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range list {
+		if applied[m.version] {
+			continue
+		}
+		if err := runMigration(ctx, db, m.sql, d.recordMigration, m.version); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		slog.Info("applied migration", "name", m.name)
+	}
+	return nil
+}
+
+// revertMigrations undoes the steps most recently applied migrations of
+// db, newest first, each in its own transaction.
+func revertMigrations(ctx context.Context, db *sql.DB, d Dialect, steps int) error {
+	list, err := loadMigrations(d)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(list) - 1; i >= 0 && steps > 0; i-- {
+		m := list[i]
+		if !applied[m.version] {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("migration %s cannot be undone: there is no %s", m.name, strings.TrimSuffix(m.name, ".sql")+downSuffix)
+		}
+		if err := runMigration(ctx, db, m.down, d.removeMigration, m.version); err != nil {
+			return fmt.Errorf("undoing migration %s: %w", m.name, err)
+		}
+		slog.Info("undid migration", "name", m.name)
+		steps--
+	}
+	return nil
+}
+
+// runMigration runs script and then record with version in one
+// transaction.
+func runMigration(ctx context.Context, db *sql.DB, script, record string, version int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS albums;
+DROP SEQUENCE IF EXISTS album_id_seq;
@@ -0,0 +1,9 @@
+CREATE SEQUENCE IF NOT EXISTS album_id_seq;
+
+CREATE TABLE IF NOT EXISTS albums (
+	id     TEXT PRIMARY KEY,
+	title  TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	price  DOUBLE PRECISION NOT NULL,
+	pos    BIGSERIAL
+);
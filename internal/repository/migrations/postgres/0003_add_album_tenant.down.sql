@@ -0,0 +1,4 @@
+-- Fails if two tenants have albums with the same ID.
+ALTER TABLE albums DROP CONSTRAINT IF EXISTS albums_pkey;
+ALTER TABLE albums ADD PRIMARY KEY (id);
+ALTER TABLE albums DROP COLUMN IF EXISTS tenant;
@@ -0,0 +1,3 @@
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE albums DROP CONSTRAINT IF EXISTS albums_pkey;
+ALTER TABLE albums ADD PRIMARY KEY (tenant, id);
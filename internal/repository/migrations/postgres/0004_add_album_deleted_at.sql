@@ -0,0 +1,3 @@
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS albums_deleted_at ON albums (deleted_at) WHERE deleted_at IS NOT NULL;
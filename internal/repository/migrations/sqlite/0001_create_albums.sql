@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS albums (
+	pos    INTEGER PRIMARY KEY AUTOINCREMENT,
+	id     TEXT NOT NULL UNIQUE,
+	title  TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	price  REAL NOT NULL
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	time           TEXT NOT NULL,
+	request_id     TEXT NOT NULL,
+	actor          TEXT NOT NULL,
+	auth_method    TEXT NOT NULL,
+	client_ip      TEXT NOT NULL,
+	method         TEXT NOT NULL,
+	route          TEXT NOT NULL,
+	path           TEXT NOT NULL,
+	status         INTEGER NOT NULL,
+	payload_digest TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log (time);
@@ -0,0 +1,11 @@
+-- Fails if two tenants have albums with the same ID.
+CREATE TABLE albums_without_tenant (
+	pos    INTEGER PRIMARY KEY AUTOINCREMENT,
+	id     TEXT NOT NULL UNIQUE,
+	title  TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	price  REAL NOT NULL
+);
+INSERT INTO albums_without_tenant (pos, id, title, artist, price) SELECT pos, id, title, artist, price FROM albums;
+DROP TABLE albums;
+ALTER TABLE albums_without_tenant RENAME TO albums;
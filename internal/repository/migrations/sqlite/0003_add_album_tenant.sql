@@ -0,0 +1,12 @@
+CREATE TABLE albums_with_tenant (
+	pos    INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant TEXT NOT NULL DEFAULT 'default',
+	id     TEXT NOT NULL,
+	title  TEXT NOT NULL,
+	artist TEXT NOT NULL,
+	price  REAL NOT NULL,
+	UNIQUE (tenant, id)
+);
+INSERT INTO albums_with_tenant (pos, id, title, artist, price) SELECT pos, id, title, artist, price FROM albums;
+DROP TABLE albums;
+ALTER TABLE albums_with_tenant RENAME TO albums;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS albums_deleted_at;
+
+ALTER TABLE albums DROP COLUMN deleted_at;
@@ -0,0 +1 @@
+ALTER TABLE albums ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
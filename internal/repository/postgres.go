@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"strconv"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresDialect stores albums in PostgreSQL. Generated IDs come from
+// album_id_seq.
+var postgresDialect = Dialect{
+	name:   "postgres",
+	driver: "pgx",
+
+	placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+
+	list: `SELECT id, title, artist, price, version, deleted_at FROM albums`,
+	get:  `SELECT id, title, artist, price, version FROM albums WHERE tenant = $1 AND id = $2 AND deleted_at IS NULL`,
+	insert: `INSERT INTO albums (tenant, id, title, artist, price) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant, id) DO NOTHING RETURNING id`,
+	insertGenerated: `INSERT INTO albums (tenant, id, title, artist, price) VALUES ($1, nextval('album_id_seq')::text, $2, $3, $4)
+		ON CONFLICT (tenant, id) DO NOTHING RETURNING id`,
+	update: `UPDATE albums SET title = $3, artist = $4, price = $5, version = version + 1
+		WHERE tenant = $1 AND id = $2 AND deleted_at IS NULL AND ($6 = 0 OR version = $6) RETURNING version`,
+	delete: `UPDATE albums SET deleted_at = $3 WHERE tenant = $1 AND id = $2 AND deleted_at IS NULL`,
+	restore: `UPDATE albums SET deleted_at = NULL, version = version + 1 WHERE tenant = $1 AND id = $2 AND deleted_at IS NOT NULL
+		RETURNING id, title, artist, price, version`,
+	purge: `DELETE FROM albums WHERE deleted_at < $1`,
+
+	recordMigration: `INSERT INTO schema_migrations (version) VALUES ($1)`,
+	removeMigration: `DELETE FROM schema_migrations WHERE version = $1`,
+}
@@ -0,0 +1,224 @@
+// Package repository stores albums in memory or a SQL database, scoping
+// every read and write to the tenant of the context it is given.
+package repository
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pspFileAPI/internal/cache"
+	"pspFileAPI/internal/config"
+)
+
+// Album represents data about a record album.
+type Album struct {
+	ID     string  `json:"id" xml:"id" binding:"omitempty,max=64,albumid"`
+	Title  string  `json:"title" xml:"title" binding:"required,max=200"`
+	Artist string  `json:"artist" xml:"artist" binding:"required,max=200"`
+	Price  float64 `json:"price" xml:"price" binding:"gte=0"`
+	// Version is 1 when the album is created and goes up with every
+	// change. Replacements name the version they were based on, so one
+	// writer cannot silently undo another's change.
+	Version int `json:"version" xml:"version" binding:"gte=0"`
+	// DeletedAt is when the album was deleted, nil while it is live. Only
+	// admins listing with include_deleted=true see deleted albums.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+}
+
+// SeedAlbums is the record album data a new memory store starts with.
+var SeedAlbums = []Album{
+	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
+}
+
+// Errors returned by every Repository.
+var (
+	ErrAlbumNotFound   = errors.New("album not found")
+	ErrAlbumExists     = errors.New("album already exists")
+	ErrVersionConflict = errors.New("album has changed since it was read")
+)
+
+// Repository stores albums. Implementations must be safe for
+// concurrent use and return ErrAlbumNotFound, ErrAlbumExists and
+// ErrVersionConflict so handlers can respond the same way whatever the
+// backend.
+type Repository interface {
+	// List returns the page of albums q selects and how many albums match
+	// q's filters in total.
+	List(ctx context.Context, q ListQuery) ([]Album, int, error)
+	// Get returns the album with the given id.
+	Get(ctx context.Context, id string) (Album, error)
+	// Create adds albums at version 1, assigning an ID to any that lack
+	// one. Either every album is added or none is.
+	Create(ctx context.Context, albums ...Album) ([]Album, error)
+	// Update replaces the album with the given id and bumps its version.
+	// Unless a.Version is zero, it must be the stored version, checked in
+	// the same step as the write, or ErrVersionConflict is returned.
+	Update(ctx context.Context, id string, a Album) (Album, error)
+	// Delete marks the album with the given id deleted. Deleted albums
+	// are left out of list, get and update until restored.
+	Delete(ctx context.Context, id string) error
+	// Restore clears the deleted mark of the album with the given id,
+	// returning ErrAlbumNotFound unless it is deleted.
+	Restore(ctx context.Context, id string) (Album, error)
+	// Purge removes the albums of every tenant deleted before cutoff and
+	// returns how many there were.
+	Purge(ctx context.Context, cutoff time.Time) (int, error)
+	// Ping checks that the backend can be reached.
+	Ping(ctx context.Context) error
+	// Close releases the repository's resources.
+	Close() error
+}
+
+// ListQuery selects albums for Repository.List. Empty filters match
+// every album.
+type ListQuery struct {
+	Artist, Title      string
+	MinPrice, MaxPrice *float64
+
+	// Sort is a key of SortFields; empty keeps insertion order.
+	Sort string
+	Desc bool
+
+	// Offset albums are skipped and at most Limit returned.
+	Offset, Limit int
+
+	// IncludeDeleted also selects deleted albums.
+	IncludeDeleted bool
+}
+
+// matches reports whether a passes q's filters.
+func (q ListQuery) matches(a Album) bool {
+	return (q.IncludeDeleted || a.DeletedAt == nil) &&
+		(q.Artist == "" || a.Artist == q.Artist) &&
+		(q.Title == "" || a.Title == q.Title) &&
+		(q.MinPrice == nil || a.Price >= *q.MinPrice) &&
+		(q.MaxPrice == nil || a.Price <= *q.MaxPrice)
+}
+
+// cacheKey identifies q among cached lists.
+func (q ListQuery) cacheKey() string {
+	price := func(p *float64) string {
+		if p == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%q %q %s %s %s %t %d %d",
+		q.Artist, q.Title, price(q.MinPrice), price(q.MaxPrice), q.Sort, q.Desc, q.Offset, q.Limit))
+	return hex.EncodeToString(sum[:16])
+}
+
+// SortFields compares albums by each field lists can be sorted on.
+// The keys are also the fields' column names.
+var SortFields = map[string]func(a, b Album) int{
+	"id":     func(a, b Album) int { return strings.Compare(a.ID, b.ID) },
+	"title":  func(a, b Album) int { return strings.Compare(a.Title, b.Title) },
+	"artist": func(a, b Album) int { return strings.Compare(a.Artist, b.Artist) },
+	"price":  func(a, b Album) int { return cmp.Compare(a.Price, b.Price) },
+}
+
+// Dialects are the SQL backends ALBUM_STORE can select.
+var Dialects = map[string]Dialect{
+	postgresDialect.name: postgresDialect,
+	sqliteDialect.name:   sqliteDialect,
+}
+
+// Open returns the Repository cfg.AlbumStore selects. SQL stores apply
+// pending migrations first unless DB_AUTO_MIGRATE is false, and their
+// reads are cached for CACHE_TTL.
+func Open(ctx context.Context, cfg config.Config) (Repository, error) {
+	if cfg.AlbumStore == "memory" {
+		return NewMemory(SeedAlbums), nil
+	}
+	d, dsn, pool, err := sqlConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openSQLAlbumStore(ctx, d, dsn, pool, cfg.DBAutoMigrate)
+	if err != nil || cfg.CacheTTL == 0 {
+		return store, err
+	}
+	c, err := cache.Open(ctx, cfg, cfg.CacheTTL)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &cachedAlbumStore{Repository: store, cache: c, ttl: cfg.CacheTTL}, nil
+}
+
+// Migrate applies pending migrations to the database selected by
+// ALBUM_STORE and DATABASE_URL.
+func Migrate(ctx context.Context, cfg config.Config) error {
+	if cfg.AlbumStore == "memory" {
+		return errors.New("migrate needs ALBUM_STORE set to a SQL backend")
+	}
+	db, d, err := OpenDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return applyMigrations(ctx, db, d)
+}
+
+// MigrateDown undoes the steps most recently applied migrations of the
+// database selected by ALBUM_STORE and DATABASE_URL.
+func MigrateDown(ctx context.Context, cfg config.Config, steps int) error {
+	if cfg.AlbumStore == "memory" {
+		return errors.New("migrate needs ALBUM_STORE set to a SQL backend")
+	}
+	db, d, err := OpenDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return revertMigrations(ctx, db, d, steps)
+}
+
+// OpenDB connects to the SQL database cfg selects, for other tables kept
+// next to the albums. It does not migrate the schema.
+func OpenDB(ctx context.Context, cfg config.Config) (*sql.DB, Dialect, error) {
+	d, dsn, pool, err := sqlConfig(cfg)
+	if err != nil {
+		return nil, d, err
+	}
+	db, err := openDB(ctx, d, dsn, pool)
+	return db, d, err
+}
+
+// sqlConfig returns how to connect to the SQL backend cfg selects.
+func sqlConfig(cfg config.Config) (Dialect, string, poolConfig, error) {
+	d, ok := Dialects[cfg.AlbumStore]
+	if !ok {
+		return d, "", poolConfig{}, fmt.Errorf("ALBUM_STORE %q must be \"memory\", \"postgres\" or \"sqlite\"", cfg.AlbumStore)
+	}
+	pool := poolConfig{maxOpen: cfg.DBMaxOpenConns, maxIdle: cfg.DBMaxIdleConns, maxLifetime: cfg.DBConnMaxLifetime}
+
+	dsn := cfg.DatabaseURL
+	if d.name == sqliteDialect.name {
+		if dsn == "" {
+			dsn = "albums.db"
+		}
+		// SQLite allows a single writer, and every connection to
+		// :memory: is a separate database; one long-lived connection
+		// avoids both SQLITE_BUSY errors and lost data.
+		pool = poolConfig{maxOpen: 1, maxIdle: 1}
+	}
+	return d, dsn, pool, nil
+}
+
+// poolConfig sizes a database connection pool.
+type poolConfig struct {
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+}
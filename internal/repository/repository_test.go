@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pspFileAPI/internal/config"
+	"pspFileAPI/internal/tenant"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// backends open each kind of Repository that can run without a server,
+// holding the SeedAlbums.
+var backends = map[string]func(t *testing.T) Repository{
+	"memory": func(t *testing.T) Repository { return NewMemory(SeedAlbums) },
+	"sqlite": func(t *testing.T) Repository {
+		repo, err := Open(context.Background(), sqliteConfig(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		if _, err := repo.Create(context.Background(), SeedAlbums...); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	},
+}
+
+// sqliteConfig selects a new SQLite database file, migrated on open and
+// not cached.
+func sqliteConfig(t *testing.T) config.Config {
+	return config.Config{AlbumStore: "sqlite", DatabaseURL: filepath.Join(t.TempDir(), "albums.db"), DBAutoMigrate: true}
+}
+
+// forEachBackend runs test against every backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, repo Repository)) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) { test(t, open(t)) })
+	}
+}
+
+// ids returns the IDs of albums, in order.
+func ids(albums []Album) []string {
+	out := make([]string, len(albums))
+	for i, a := range albums {
+		out[i] = a.ID
+	}
+	return out
+}
+
+func wantIDs(t *testing.T, got []Album, want ...string) {
+	t.Helper()
+	if g := ids(got); len(g) != len(want) || (len(want) > 0 && !equal(g, want)) {
+		t.Errorf("albums %v, want %v", g, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+func TestCreate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		created, err := repo.Create(ctx, Album{Title: "Kind of Blue", Artist: "Miles Davis", Price: 9.99}, Album{ID: "x1", Title: "Giant Steps", Artist: "John Coltrane"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created[0].ID != "4" || created[1].ID != "x1" {
+			t.Errorf("created IDs %v, want [4 x1]", ids(created))
+		}
+		for _, a := range created {
+			if a.Version != 1 {
+				t.Errorf("album %s created at version %d, want 1", a.ID, a.Version)
+			}
+		}
+		got, err := repo.Get(ctx, "4")
+		if err != nil || got.Title != "Kind of Blue" || got.Version != 1 {
+			t.Errorf("Get(4) = %+v, %v", got, err)
+		}
+
+		// A taken ID fails the whole batch.
+		if _, err := repo.Create(ctx, Album{ID: "y1", Title: "T", Artist: "A"}, Album{ID: "1", Title: "T", Artist: "A"}); !errors.Is(err, ErrAlbumExists) {
+			t.Errorf("Create with a taken ID: %v, want ErrAlbumExists", err)
+		}
+		if _, err := repo.Get(ctx, "y1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("album y1 of a failed batch was added: %v", err)
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		list, total, err := repo.List(ctx, ListQuery{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantIDs(t, list, "1", "2", "3")
+		if total != 3 {
+			t.Errorf("total %d, want 3", total)
+		}
+
+		list, total, _ = repo.List(ctx, ListQuery{Sort: "price", Desc: true, Offset: 1, Limit: 1})
+		wantIDs(t, list, "3")
+		if total != 3 {
+			t.Errorf("paged total %d, want 3", total)
+		}
+
+		low, high := 20.0, 60.0
+		list, total, _ = repo.List(ctx, ListQuery{MinPrice: &low, MaxPrice: &high, Sort: "title", Limit: 10})
+		wantIDs(t, list, "1", "3")
+		if total != 2 {
+			t.Errorf("filtered total %d, want 2", total)
+		}
+		list, _, _ = repo.List(ctx, ListQuery{Artist: "Gerry Mulligan", Limit: 10})
+		wantIDs(t, list, "2")
+
+		list, total, _ = repo.List(ctx, ListQuery{Offset: 5, Limit: 10})
+		wantIDs(t, list)
+		if total != 3 {
+			t.Errorf("total past the end %d, want 3", total)
+		}
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		updated, err := repo.Update(ctx, "2", Album{Title: "Jeru", Artist: "Gerry Mulligan", Price: 19.99, Version: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.ID != "2" || updated.Version != 2 || updated.Price != 19.99 {
+			t.Errorf("Update = %+v, want album 2 at version 2", updated)
+		}
+		if _, err := repo.Update(ctx, "2", Album{Title: "Jeru", Artist: "Gerry Mulligan", Version: 1}); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Update from a stale version: %v, want ErrVersionConflict", err)
+		}
+		if a, err := repo.Update(ctx, "2", Album{Title: "Jeru", Artist: "Gerry Mulligan"}); err != nil || a.Version != 3 {
+			t.Errorf("Update without a version = %+v, %v; want version 3", a, err)
+		}
+		if _, err := repo.Update(ctx, "99", Album{Title: "T", Artist: "A"}); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Update of a missing album: %v, want ErrAlbumNotFound", err)
+		}
+	})
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		if err := repo.Delete(ctx, "1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Get(ctx, "1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Get of a deleted album: %v, want ErrAlbumNotFound", err)
+		}
+		if _, err := repo.Update(ctx, "1", Album{Title: "T", Artist: "A"}); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Update of a deleted album: %v, want ErrAlbumNotFound", err)
+		}
+		if err := repo.Delete(ctx, "1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("second Delete: %v, want ErrAlbumNotFound", err)
+		}
+		if _, err := repo.Create(ctx, Album{ID: "1", Title: "T", Artist: "A"}); !errors.Is(err, ErrAlbumExists) {
+			t.Errorf("Create with a deleted album's ID: %v, want ErrAlbumExists", err)
+		}
+		list, _, _ := repo.List(ctx, ListQuery{Limit: 10})
+		wantIDs(t, list, "2", "3")
+		list, total, _ := repo.List(ctx, ListQuery{IncludeDeleted: true, Limit: 10})
+		wantIDs(t, list, "1", "2", "3")
+		if total != 3 || list[0].DeletedAt == nil {
+			t.Errorf("include deleted: total %d, deleted_at %v", total, list[0].DeletedAt)
+		}
+
+		restored, err := repo.Restore(ctx, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.DeletedAt != nil || restored.Version != 2 {
+			t.Errorf("Restore = %+v, want a live album at version 2", restored)
+		}
+		if _, err := repo.Restore(ctx, "1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Restore of a live album: %v, want ErrAlbumNotFound", err)
+		}
+	})
+}
+
+func TestPurge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		ctx := context.Background()
+		other := tenant.With(ctx, "other")
+		if _, err := repo.Create(other, Album{ID: "1", Title: "T", Artist: "A"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []context.Context{ctx, other} {
+			if err := repo.Delete(c, "1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n, err := repo.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Errorf("Purge before the deletions = %d, %v; want 0", n, err)
+		}
+		if n, err := repo.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 2 {
+			t.Errorf("Purge after the deletions = %d, %v; want 2, from both tenants", n, err)
+		}
+		if _, err := repo.Restore(ctx, "1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Restore of a purged album: %v, want ErrAlbumNotFound", err)
+		}
+		if _, err := repo.Create(ctx, Album{ID: "1", Title: "T", Artist: "A"}); err != nil {
+			t.Errorf("Create with a purged album's ID: %v", err)
+		}
+	})
+}
+
+func TestTenantsAreSeparate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo Repository) {
+		other := tenant.With(context.Background(), "other")
+		list, total, err := repo.List(other, ListQuery{Limit: 10})
+		if err != nil || total != 0 || len(list) != 0 {
+			t.Errorf("new tenant lists %v, %d, %v; want nothing", ids(list), total, err)
+		}
+		if list == nil {
+			t.Error("new tenant lists nil, want an empty list so responses carry []")
+		}
+		if _, err := repo.Get(other, "1"); !errors.Is(err, ErrAlbumNotFound) {
+			t.Errorf("Get of another tenant's album: %v, want ErrAlbumNotFound", err)
+		}
+		created, err := repo.Create(other, Album{Title: "T", Artist: "A"})
+		if err != nil || created[0].ID != "1" {
+			t.Errorf("first album of a tenant = %v, %v; want ID 1", ids(created), err)
+		}
+		if a, _ := repo.Get(context.Background(), "1"); a.Title != "Blue Train" {
+			t.Errorf("another tenant's Create changed album 1: %+v", a)
+		}
+	})
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t)
+	if err := Migrate(ctx, cfg); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	cfg.DBAutoMigrate = false
+	repo, err := Open(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(ctx, Album{Title: "T", Artist: "A"}); err != nil {
+		t.Errorf("Create after Migrate: %v", err)
+	}
+	repo.Close()
+
+	migrations, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(ctx, cfg, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if err := Migrate(ctx, cfg); err != nil {
+		t.Fatalf("Migrate after MigrateDown: %v", err)
+	}
+
+	if err := Migrate(ctx, config.Config{AlbumStore: "memory"}); err == nil {
+		t.Error("Migrate of the memory store did not fail")
+	}
+}
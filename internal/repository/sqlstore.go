@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// TimeFormat is how times are passed to and stored by text columns:
+// fixed-width UTC, so they sort as text.
+const TimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// ScanTime converts a time column, which Postgres returns as a
+// time.Time and SQLite as text.
+func ScanTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case string:
+		return time.Parse(TimeFormat, t)
+	case []byte:
+		return time.Parse(TimeFormat, string(t))
+	}
+	return time.Time{}, fmt.Errorf("time column has type %T", v)
+}
+
+// Dialect holds what differs between the SQL databases albums can be
+// stored in.
+type Dialect struct {
+	name   string
+	driver string
+
+	// placeholder returns the nth (from 1) query parameter.
+	placeholder func(n int) string
+	// Queries, in the database's placeholder syntax. Each but list and
+	// purge takes the tenant first; list is completed with the tenant,
+	// filters, order and page a ListQuery asks for. update takes the
+	// version it expects last, 0 for any, and returns the new version;
+	// delete marks an album deleted at the time it is passed; purge
+	// removes albums of every tenant deleted before the time it is passed.
+	list, get, insert, insertGenerated, update, delete, restore, purge string
+	// recordMigration inserts a version into schema_migrations and
+	// removeMigration deletes one.
+	recordMigration, removeMigration string
+}
+
+// Name returns the ALBUM_STORE value selecting d.
+func (d Dialect) Name() string { return d.name }
+
+// Placeholder returns the nth (from 1) query parameter in d's syntax.
+func (d Dialect) Placeholder(n int) string { return d.placeholder(n) }
+
+// sqlAlbumStore is a Repository backed by a SQL database.
+type sqlAlbumStore struct {
+	db *sql.DB
+	d  Dialect
+
+	getStmt, insertStmt, insertGeneratedStmt, updateStmt, deleteStmt, restoreStmt *sql.Stmt
+}
+
+// openSQLAlbumStore connects to the database at dsn, applies pending
+// migrations when migrate is set and prepares the store's statements.
+func openSQLAlbumStore(ctx context.Context, d Dialect, dsn string, pool poolConfig, migrate bool) (*sqlAlbumStore, error) {
+	db, err := openDB(ctx, d, dsn, pool)
+	if err != nil {
+		return nil, err
+	}
+	if migrate {
+		if err := applyMigrations(ctx, db, d); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	s := &sqlAlbumStore{db: db, d: d}
+	for _, p := range []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&s.getStmt, d.get},
+		{&s.insertStmt, d.insert},
+		{&s.insertGeneratedStmt, d.insertGenerated},
+		{&s.updateStmt, d.update},
+		{&s.deleteStmt, d.delete},
+		{&s.restoreStmt, d.restore},
+	} {
+		if *p.dst, err = db.PrepareContext(ctx, p.query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("prepare %q: %w", p.query, err)
+		}
+	}
+	return s, nil
+}
+
+// openDB opens and pings a connection pool for d.
+func openDB(ctx context.Context, d Dialect, dsn string, pool poolConfig) (*sql.DB, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.maxOpen)
+	db.SetMaxIdleConns(pool.maxIdle)
+	db.SetConnMaxLifetime(pool.maxLifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s: %w", d.name, err)
+	}
+	return db, nil
+}
+
+func (s *sqlAlbumStore) List(ctx context.Context, q ListQuery) ([]Album, int, error) {
+	var conds []string
+	var args []any
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+s.d.placeholder(len(args)))
+	}
+	where("tenant =", tenant.From(ctx))
+	if !q.IncludeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if q.Artist != "" {
+		where("artist =", q.Artist)
+	}
+	if q.Title != "" {
+		where("title =", q.Title)
+	}
+	if q.MinPrice != nil {
+		where("price >=", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		where("price <=", *q.MaxPrice)
+	}
+	filter := " WHERE " + strings.Join(conds, " AND ")
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var total int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM albums"+filter, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// q.Sort is a key of SortFields, which are column names.
+	order := " ORDER BY pos"
+	if q.Sort != "" {
+		dir := " ASC"
+		if q.Desc {
+			dir = " DESC"
+		}
+		order = " ORDER BY " + q.Sort + dir + ", pos"
+	}
+	page := fmt.Sprintf(" LIMIT %s OFFSET %s", s.d.placeholder(len(args)+1), s.d.placeholder(len(args)+2))
+	rows, err := tx.QueryContext(ctx, s.d.list+filter+order+page, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	list := []Album{}
+	for rows.Next() {
+		var a Album
+		var deleted any
+		if err := rows.Scan(&a.ID, &a.Title, &a.Artist, &a.Price, &a.Version, &deleted); err != nil {
+			return nil, 0, err
+		}
+		if deleted != nil {
+			t, err := ScanTime(deleted)
+			if err != nil {
+				return nil, 0, err
+			}
+			a.DeletedAt = &t
+		}
+		list = append(list, a)
+	}
+	return list, total, rows.Err()
+}
+
+func (s *sqlAlbumStore) Get(ctx context.Context, id string) (Album, error) {
+	var a Album
+	err := s.getStmt.QueryRowContext(ctx, tenant.From(ctx), id).Scan(&a.ID, &a.Title, &a.Artist, &a.Price, &a.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Album{}, ErrAlbumNotFound
+	}
+	return a, err
+}
+
+func (s *sqlAlbumStore) Create(ctx context.Context, albums ...Album) ([]Album, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert := tx.StmtContext(ctx, s.insertStmt)
+	insertGenerated := tx.StmtContext(ctx, s.insertGeneratedStmt)
+
+	name := tenant.From(ctx)
+	created := make([]Album, len(albums))
+	for i, a := range albums {
+		if a.ID != "" {
+			err = insert.QueryRowContext(ctx, name, a.ID, a.Title, a.Artist, a.Price).Scan(&a.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrAlbumExists
+			}
+		} else {
+			// A generated ID can collide with one a client chose, so keep
+			// generating until the insert goes through.
+			for {
+				err = insertGenerated.QueryRowContext(ctx, name, a.Title, a.Artist, a.Price).Scan(&a.ID)
+				if !errors.Is(err, sql.ErrNoRows) {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.Version = 1
+		created[i] = a
+	}
+	return created, tx.Commit()
+}
+
+func (s *sqlAlbumStore) Update(ctx context.Context, id string, a Album) (Album, error) {
+	err := s.updateStmt.QueryRowContext(ctx, tenant.From(ctx), id, a.Title, a.Artist, a.Price, a.Version).Scan(&a.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either there is no such album or its version moved on.
+		if _, err := s.Get(ctx, id); err != nil {
+			return Album{}, err
+		}
+		return Album{}, ErrVersionConflict
+	}
+	if err != nil {
+		return Album{}, err
+	}
+	a.ID = id
+	return a, nil
+}
+
+func (s *sqlAlbumStore) Delete(ctx context.Context, id string) error {
+	res, err := s.deleteStmt.ExecContext(ctx, tenant.From(ctx), id, time.Now().UTC().Format(TimeFormat))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAlbumNotFound
+	}
+	return nil
+}
+
+func (s *sqlAlbumStore) Restore(ctx context.Context, id string) (Album, error) {
+	var a Album
+	err := s.restoreStmt.QueryRowContext(ctx, tenant.From(ctx), id).Scan(&a.ID, &a.Title, &a.Artist, &a.Price, &a.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Album{}, ErrAlbumNotFound
+	}
+	return a, err
+}
+
+func (s *sqlAlbumStore) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.d.purge, cutoff.UTC().Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Ping checks the database connection.
+func (s *sqlAlbumStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the prepared statements and the connection pool.
+func (s *sqlAlbumStore) Close() error {
+	for _, stmt := range []*sql.Stmt{s.getStmt, s.insertStmt, s.insertGeneratedStmt, s.updateStmt, s.deleteStmt, s.restoreStmt} {
+		stmt.Close()
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// memoryAlbumStore is a thread-safe in-memory collection of albums that keeps
+// insertion order.
+type memoryAlbumStore struct {
+	mu     sync.RWMutex
+	albums []Album
+	nextID int
+}
+
+// newMemoryAlbumStore returns a store seeded with albums.
+func newMemoryAlbumStore(seed []Album) *memoryAlbumStore {
+	s := &memoryAlbumStore{nextID: 1}
+	for _, a := range seed {
+		s.insert(a)
+	}
+	return s
+}
+
+// List returns a copy of the albums q selects.
+func (s *memoryAlbumStore) List(ctx context.Context, q ListQuery) ([]Album, int, error) {
+	s.mu.RLock()
+	matched := []Album{}
+	for _, a := range s.albums {
+		if q.matches(a) {
+			matched = append(matched, a)
+		}
+	}
+	s.mu.RUnlock()
+
+	if compare := SortFields[q.Sort]; compare != nil {
+		slices.SortStableFunc(matched, func(a, b Album) int {
+			if q.Desc {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+	}
+	page := matched[min(max(q.Offset, 0), len(matched)):]
+	return page[:min(q.Limit, len(page))], len(matched), nil
+}
+
+// Get returns the album with the given id.
+func (s *memoryAlbumStore) Get(ctx context.Context, id string) (Album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.live(id); i >= 0 {
+		return s.albums[i], nil
+	}
+	return Album{}, ErrAlbumNotFound
+}
+
+// Create adds albums, assigning an ID to any that lack one. Either every
+// album is added or, if any ID is already taken, none is. A deleted album
+// keeps its ID until purged.
+func (s *memoryAlbumStore) Create(ctx context.Context, albums ...Album) ([]Album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(albums))
+	for _, a := range albums {
+		if a.ID == "" {
+			continue
+		}
+		if seen[a.ID] || s.index(a.ID) >= 0 {
+			return nil, ErrAlbumExists
+		}
+		seen[a.ID] = true
+	}
+	for id := range seen {
+		s.reserve(id)
+	}
+
+	created := make([]Album, len(albums))
+	for i, a := range albums {
+		created[i] = s.insert(a)
+	}
+	return created, nil
+}
+
+// Update replaces the album with the given id.
+func (s *memoryAlbumStore) Update(ctx context.Context, id string, a Album) (Album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.live(id)
+	if i < 0 {
+		return Album{}, ErrAlbumNotFound
+	}
+	if a.Version != 0 && a.Version != s.albums[i].Version {
+		return Album{}, ErrVersionConflict
+	}
+	a.ID, a.DeletedAt, a.Version = id, nil, s.albums[i].Version+1
+	s.albums[i] = a
+	return a, nil
+}
+
+// Delete marks the album with the given id deleted.
+func (s *memoryAlbumStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.live(id)
+	if i < 0 {
+		return ErrAlbumNotFound
+	}
+	now := time.Now().UTC()
+	s.albums[i].DeletedAt = &now
+	return nil
+}
+
+// Restore clears the deleted mark of the album with the given id.
+func (s *memoryAlbumStore) Restore(ctx context.Context, id string) (Album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 || s.albums[i].DeletedAt == nil {
+		return Album{}, ErrAlbumNotFound
+	}
+	s.albums[i].DeletedAt = nil
+	s.albums[i].Version++
+	return s.albums[i], nil
+}
+
+// Purge removes the albums deleted before cutoff.
+func (s *memoryAlbumStore) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.albums)
+	s.albums = slices.DeleteFunc(s.albums, func(a Album) bool {
+		return a.DeletedAt != nil && a.DeletedAt.Before(cutoff)
+	})
+	return before - len(s.albums), nil
+}
+
+// insert appends a, generating its ID when empty. The caller must hold
+// s.mu.
+func (s *memoryAlbumStore) insert(a Album) Album {
+	if a.ID == "" {
+		a.ID = strconv.Itoa(s.nextID)
+	}
+	a.DeletedAt, a.Version = nil, 1
+	s.reserve(a.ID)
+	s.albums = append(s.albums, a)
+	return a
+}
+
+// reserve keeps generated IDs clear of id when it is numeric. The caller
+// must hold s.mu.
+func (s *memoryAlbumStore) reserve(id string) {
+	if n, err := strconv.Atoi(id); err == nil && n >= s.nextID {
+		s.nextID = n + 1
+	}
+}
+
+// index returns the position of the album with the given id, deleted or
+// not, or -1. The caller must hold s.mu.
+func (s *memoryAlbumStore) index(id string) int {
+	for i, a := range s.albums {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// live returns the position of the album with the given id unless it is
+// deleted, or -1. The caller must hold s.mu.
+func (s *memoryAlbumStore) live(id string) int {
+	if i := s.index(id); i >= 0 && s.albums[i].DeletedAt == nil {
+		return i
+	}
+	return -1
+}
+
+// Ping always succeeds; the store lives in memory.
+func (s *memoryAlbumStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing; the store lives in memory.
+func (s *memoryAlbumStore) Close() error {
+	return nil
+}
+
+// tenantMemoryStores is a Repository keeping each tenant's albums in
+// its own memoryAlbumStore, created by the tenant's first create. Only
+// tenant.Default starts with the seed albums.
+type tenantMemoryStores struct {
+	mu     sync.Mutex
+	stores map[string]*memoryAlbumStore
+}
+
+// NewMemory returns a Repository keeping albums in memory, whose default
+// tenant is seeded with seed.
+func NewMemory(seed []Album) Repository {
+	return &tenantMemoryStores{stores: map[string]*memoryAlbumStore{tenant.Default: newMemoryAlbumStore(seed)}}
+}
+
+// store returns the store of ctx's tenant, or nil if the tenant has never
+// created an album. Reads and changes of a tenant without a store find
+// nothing, rather than leaving an empty store behind for every name asked
+// about.
+func (t *tenantMemoryStores) store(ctx context.Context) *memoryAlbumStore {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stores[tenant.From(ctx)]
+}
+
+// create returns the store of ctx's tenant, creating it if need be.
+func (t *tenantMemoryStores) create(ctx context.Context) *memoryAlbumStore {
+	name := tenant.From(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stores[name]
+	if !ok {
+		s = newMemoryAlbumStore(nil)
+		t.stores[name] = s
+	}
+	return s
+}
+
+func (t *tenantMemoryStores) List(ctx context.Context, q ListQuery) ([]Album, int, error) {
+	s := t.store(ctx)
+	if s == nil {
+		return []Album{}, 0, nil
+	}
+	return s.List(ctx, q)
+}
+
+func (t *tenantMemoryStores) Get(ctx context.Context, id string) (Album, error) {
+	s := t.store(ctx)
+	if s == nil {
+		return Album{}, ErrAlbumNotFound
+	}
+	return s.Get(ctx, id)
+}
+
+func (t *tenantMemoryStores) Create(ctx context.Context, albums ...Album) ([]Album, error) {
+	return t.create(ctx).Create(ctx, albums...)
+}
+
+func (t *tenantMemoryStores) Update(ctx context.Context, id string, a Album) (Album, error) {
+	s := t.store(ctx)
+	if s == nil {
+		return Album{}, ErrAlbumNotFound
+	}
+	return s.Update(ctx, id, a)
+}
+
+func (t *tenantMemoryStores) Delete(ctx context.Context, id string) error {
+	s := t.store(ctx)
+	if s == nil {
+		return ErrAlbumNotFound
+	}
+	return s.Delete(ctx, id)
+}
+
+func (t *tenantMemoryStores) Restore(ctx context.Context, id string) (Album, error) {
+	s := t.store(ctx)
+	if s == nil {
+		return Album{}, ErrAlbumNotFound
+	}
+	return s.Restore(ctx, id)
+}
+
+// Purge purges every tenant's store.
+func (t *tenantMemoryStores) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	t.mu.Lock()
+	stores := make([]*memoryAlbumStore, 0, len(t.stores))
+	for _, s := range t.stores {
+		stores = append(stores, s)
+	}
+	t.mu.Unlock()
+
+	var total int
+	for _, s := range stores {
+		n, _ := s.Purge(ctx, cutoff)
+		total += n
+	}
+	return total, nil
+}
+
+func (t *tenantMemoryStores) Ping(ctx context.Context) error { return nil }
+
+func (t *tenantMemoryStores) Close() error { return nil }
@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a job runs next.
+type Schedule interface {
+	// Next returns the first time after t the job runs.
+	Next(t time.Time) time.Time
+}
+
+// every runs a job at a fixed interval.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// descriptors abbreviate common cron expressions.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is one of the five fields of a cron expression.
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minutes = field{name: "minute", min: 0, max: 59}
+	hours   = field{name: "hour", min: 0, max: 23}
+	days    = field{name: "day of month", min: 1, max: 31}
+	months  = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Sunday is 0, or 7 as in many crons.
+	weekdays = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Parse parses a schedule: a cron expression of five fields, minute, hour,
+// day of month, month and day of week, each a *, a value, a range such as
+// 1-5 or a comma-separated list of them, any of which may be stepped as in
+// */15; one of the descriptors @hourly, @daily, @weekly, @monthly and
+// @yearly; or "@every <duration>". Months and days of the week may be
+// named by their first three letters. As in cron, a job whose day of month
+// and day of week are both restricted runs on days matching either. Times
+// are in the local time zone.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("schedule %q: @every needs a positive duration such as 10m", expr)
+		}
+		return every(interval), nil
+	}
+	if s, ok := descriptors[expr]; ok {
+		expr = s
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("schedule %q must have five fields (minute hour day month weekday), or be @hourly, @daily, @weekly, @monthly, @yearly or @every <duration>", expr)
+	}
+	var c cron
+	var err error
+	for i, p := range []struct {
+		set *uint64
+		f   field
+	}{{&c.minute, minutes}, {&c.hour, hours}, {&c.day, days}, {&c.month, months}, {&c.weekday, weekdays}} {
+		if *p.set, err = p.f.parse(parts[i]); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+	}
+	if c.weekday&(1<<7) != 0 {
+		c.weekday |= 1
+	}
+	c.anyDay, c.anyWeekday = parts[2] == "*", parts[4] == "*"
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", expr)
+	}
+	return c, nil
+}
+
+// parse returns the values s selects as a bit set.
+func (f field) parse(s string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepText, stepped := strings.Cut(item, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("%s step %q must be a positive number", f.name, stepText)
+			}
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(to); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				// 5/15 means from 5 on, every 15.
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%s range %q ends before it starts", f.name, rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses one value of f, a number or a name.
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			// Months are named from 1, days of the week from 0.
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %q must be from %d to %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// cron is a parsed cron expression, each field a bit set of the values it
+// selects.
+type cron struct {
+	minute, hour, day, month, weekday uint64
+	anyDay, anyWeekday                bool
+}
+
+// maxSearch bounds how far ahead Next looks, past any date that exists.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+func (c cron) Next(t time.Time) time.Time {
+	// Step to the next whole minute, then past what does not match, a
+	// month, day, hour or minute at a time.
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	// Only expressions such as "0 0 31 2 *" never match; Parse refuses
+	// them.
+	return time.Time{}
+}
+
+// dayMatches reports whether c runs on t's day.
+func (c cron) dayMatches(t time.Time) bool {
+	day := c.day&(1<<uint(t.Day())) != 0
+	weekday := c.weekday&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
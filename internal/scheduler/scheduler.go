@@ -0,0 +1,152 @@
+// Package scheduler runs periodic jobs, such as purges and sweeps, on cron
+// schedules. A job is never run again while its last run is still going;
+// the run due meanwhile is skipped.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// job is a registered job.
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      func(ctx context.Context) error
+	// running is set while a run is in progress.
+	running atomic.Bool
+}
+
+// Scheduler runs registered jobs on their schedules. Register every job
+// with Add before calling Run.
+type Scheduler struct {
+	jobs []*job
+	wg   sync.WaitGroup
+
+	runs        *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+	skipped     *prometheus.CounterVec
+	inProgress  *prometheus.GaugeVec
+}
+
+// New returns a Scheduler with no jobs, registering its metrics on reg.
+func New(reg prometheus.Registerer) *Scheduler {
+	s := &Scheduler{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduled_job_runs_total",
+			Help: "Runs of scheduled jobs, by job and result: success or error.",
+		}, []string{"job", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "scheduled_job_duration_seconds",
+			Help:    "Time taken by runs of scheduled jobs.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"job"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scheduled_job_last_success_timestamp_seconds",
+			Help: "When each scheduled job last finished without error, as a Unix time.",
+		}, []string{"job"}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduled_job_skipped_total",
+			Help: "Runs of scheduled jobs skipped because the previous run had not finished.",
+		}, []string{"job"}),
+		inProgress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scheduled_job_running",
+			Help: "Whether each scheduled job is running.",
+		}, []string{"job"}),
+	}
+	reg.MustRegister(s.runs, s.duration, s.lastSuccess, s.skipped, s.inProgress)
+	return s
+}
+
+// Add registers run to be called on the schedule spec, as Parse reads it.
+// The context run is passed is canceled when the Scheduler stops.
+func (s *Scheduler) Add(name, spec string, run func(ctx context.Context) error) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	s.jobs = append(s.jobs, &job{name: name, spec: spec, schedule: schedule, run: run})
+	// Show every job on /metrics before it first runs.
+	s.inProgress.WithLabelValues(name).Set(0)
+	s.skipped.WithLabelValues(name)
+	return nil
+}
+
+// Len returns how many jobs are registered.
+func (s *Scheduler) Len() int {
+	return len(s.jobs)
+}
+
+// Run runs the jobs on their schedules until ctx is done, then waits for
+// the runs in progress to return.
+func (s *Scheduler) Run(ctx context.Context) {
+	var loops sync.WaitGroup
+	for _, j := range s.jobs {
+		slog.Info("scheduled job", "job", j.name, "schedule", j.spec, "next_run", j.schedule.Next(time.Now()))
+		loops.Add(1)
+		go func(j *job) {
+			defer loops.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	loops.Wait()
+	s.wg.Wait()
+}
+
+// loop starts the runs of j as they fall due until ctx is done.
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	for {
+		timer := time.NewTimer(time.Until(j.schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.running.CompareAndSwap(false, true) {
+			s.skipped.WithLabelValues(j.name).Inc()
+			slog.Warn("scheduled job skipped; the previous run has not finished", "job", j.name)
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer j.running.Store(false)
+			s.execute(ctx, j)
+		}()
+	}
+}
+
+// execute runs j once and records the outcome. A panicking job counts as
+// failed rather than taking the process down.
+func (s *Scheduler) execute(ctx context.Context, j *job) {
+	s.inProgress.WithLabelValues(j.name).Set(1)
+	defer s.inProgress.WithLabelValues(j.name).Set(0)
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return j.run(ctx)
+	}()
+	elapsed := time.Since(start)
+	s.duration.WithLabelValues(j.name).Observe(elapsed.Seconds())
+	if err != nil {
+		s.runs.WithLabelValues(j.name, "error").Inc()
+		slog.Error("scheduled job failed", "job", j.name, "duration", elapsed, "err", err)
+		return
+	}
+	s.runs.WithLabelValues(j.name, "success").Inc()
+	s.lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+	slog.Debug("scheduled job finished", "job", j.name, "duration", elapsed)
+}
@@ -0,0 +1,98 @@
+// Package service holds what the API does with albums, whichever of the
+// HTTP, GraphQL or gRPC front ends asked for it. Front ends validate input
+// first; the services store the change and announce it.
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"pspFileAPI/internal/repository"
+	"pspFileAPI/internal/tenant"
+)
+
+// ServiceName identifies the service in traces, broker messages and
+// webhook requests.
+const ServiceName = "albums"
+
+// Albums reads and changes albums in a repository, publishing an event to
+// events for every change.
+type Albums struct {
+	repo   repository.Repository
+	events *Events
+}
+
+// NewAlbums returns an Albums service storing albums in repo.
+func NewAlbums(repo repository.Repository, events *Events) *Albums {
+	return &Albums{repo: repo, events: events}
+}
+
+// List returns the page of albums q selects and how many match in total.
+func (s *Albums) List(ctx context.Context, q repository.ListQuery) ([]repository.Album, int, error) {
+	return s.repo.List(ctx, q)
+}
+
+// Get returns the album with id.
+func (s *Albums) Get(ctx context.Context, id string) (repository.Album, error) {
+	return s.repo.Get(ctx, id)
+}
+
+// Add adds list, all or none, and publishes an event for each.
+func (s *Albums) Add(ctx context.Context, list ...repository.Album) ([]repository.Album, error) {
+	created, err := s.repo.Create(ctx, list...)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range created {
+		s.events.Publish(tenant.From(ctx), EventAlbumCreated, a)
+	}
+	return created, nil
+}
+
+// Replace replaces the album with id by a and publishes the update.
+func (s *Albums) Replace(ctx context.Context, id string, a repository.Album) (repository.Album, error) {
+	updated, err := s.repo.Update(ctx, id, a)
+	if err != nil {
+		return repository.Album{}, err
+	}
+	s.events.Publish(tenant.From(ctx), EventAlbumUpdated, updated)
+	return updated, nil
+}
+
+// Remove deletes the album with id and publishes the deletion.
+func (s *Albums) Remove(ctx context.Context, id string) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.events.Publish(tenant.From(ctx), EventAlbumDeleted, repository.Album{ID: id})
+	return nil
+}
+
+// Restore undeletes the album with id and publishes its return.
+func (s *Albums) Restore(ctx context.Context, id string) (repository.Album, error) {
+	restored, err := s.repo.Restore(ctx, id)
+	if err != nil {
+		return repository.Album{}, err
+	}
+	s.events.Publish(tenant.From(ctx), EventAlbumRestored, restored)
+	return restored, nil
+}
+
+// Ping checks that the repository can be reached.
+func (s *Albums) Ping(ctx context.Context) error {
+	return s.repo.Ping(ctx)
+}
+
+// PurgeDeleted removes albums deleted more than retention ago. The purge
+// job runs it on PURGE_SCHEDULE.
+func (s *Albums) PurgeDeleted(ctx context.Context, retention time.Duration) error {
+	n, err := s.repo.Purge(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "purged deleted albums", "count", n)
+	}
+	return nil
+}
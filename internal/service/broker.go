@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+
+	"pspFileAPI/internal/config"
+)
+
+const (
+	// brokerTimeout bounds one publish attempt.
+	brokerTimeout = 5 * time.Second
+	// brokerRetryMax caps the backoff while the broker is unavailable.
+	brokerRetryMax = 30 * time.Second
+)
+
+// brokerMessage is an album event as published, in the CloudEvents JSON
+// format.
+type brokerMessage struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	// Tenant is a CloudEvents extension naming the album's tenant.
+	Tenant string `json:"tenant"`
+	Data   any    `json:"data"`
+}
+
+// messagePublisher sends messages to a broker.
+type messagePublisher interface {
+	publish(ctx context.Context, m outboxEntry) error
+	close() error
+}
+
+// openPublisher connects to the broker BROKER selects.
+func openPublisher(cfg config.Config) (messagePublisher, error) {
+	switch cfg.Broker {
+	case "nats":
+		return openNATSPublisher(cfg.BrokerURL, cfg.BrokerTopic)
+	case "kafka":
+		return newKafkaPublisher(cfg.BrokerURL, cfg.BrokerTopic), nil
+	}
+	return nil, fmt.Errorf("BROKER %q must be \"nats\" or \"kafka\"", cfg.Broker)
+}
+
+// natsPublisher publishes each event on the subject "<topic>.<type>",
+// such as albums.album.created.
+type natsPublisher struct {
+	conn  *nats.Conn
+	topic string
+}
+
+// openNATSPublisher connects to the NATS servers at url. The connection
+// keeps reconnecting in the background for as long as the server runs.
+func openNATSPublisher(url, topic string) (*natsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name(ServiceName), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, fmt.Errorf("BROKER_URL: %w", err)
+	}
+	return &natsPublisher{conn: conn, topic: topic}, nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, m outboxEntry) error {
+	if !p.conn.IsConnected() {
+		// Publishing would only fill the client's reconnect buffer; keep
+		// the message in the outbox instead.
+		return nats.ErrConnectionClosed
+	}
+	// JetStream drops duplicates by Nats-Msg-Id, should a retry follow a
+	// publish that did arrive.
+	msg := nats.NewMsg(p.topic + "." + m.typ)
+	msg.Header.Set("Nats-Msg-Id", m.id)
+	msg.Data = m.body
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) close() error {
+	return p.conn.Drain()
+}
+
+// kafkaPublisher writes every event to one topic, keyed by album ID so
+// each album's events stay in order.
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+// newKafkaPublisher returns a publisher to the comma-separated brokers.
+func newKafkaPublisher(brokers, topic string) *kafkaPublisher {
+	return &kafkaPublisher{w: &kafka.Writer{
+		Addr:                   kafka.TCP(config.SplitList(brokers)...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		WriteTimeout:           brokerTimeout,
+		// The outbox retries; fail fast so it sees the error.
+		MaxAttempts: 1,
+	}}
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, m outboxEntry) error {
+	return p.w.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(m.key),
+		Value: m.body,
+		Headers: []kafka.Header{
+			{Key: "ce_id", Value: []byte(m.id)},
+			{Key: "ce_type", Value: []byte(m.typ)},
+		},
+	})
+}
+
+func (p *kafkaPublisher) close() error {
+	return p.w.Close()
+}
+
+// outboxEntry is a message waiting to be published: an event with ID id
+// of type typ about the album with ID key.
+type outboxEntry struct {
+	seq          uint64
+	id, typ, key string
+	body         []byte
+}
+
+// Outbox buffers album events in memory and publishes them in order,
+// retrying with backoff while the broker is unavailable so a brief outage
+// loses nothing. When more than size events are waiting the oldest are
+// dropped.
+type Outbox struct {
+	pub  messagePublisher
+	size int
+
+	mu      sync.Mutex
+	pending []outboxEntry
+	// failing is why the last attempt to publish failed; nil once one
+	// succeeds.
+	failing error
+	wake    chan struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenOutbox connects to the broker BROKER selects and starts publishing
+// the album events of events to it, holding up to BROKER_BUFFER while the
+// broker is unavailable.
+func OpenOutbox(cfg config.Config, events *Events) (*Outbox, error) {
+	pub, err := openPublisher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newOutbox(pub, events, cfg.BrokerBuffer), nil
+}
+
+// newOutbox starts publishing the album events of events through pub.
+func newOutbox(pub messagePublisher, events *Events, size int) *Outbox {
+	o := &Outbox{pub: pub, size: size, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	o.wg.Add(2)
+	go func() {
+		defer o.wg.Done()
+		events.Follow(o.done, o.add)
+	}()
+	go o.run()
+	return o
+}
+
+// add queues e for publishing.
+func (o *Outbox) add(e Event) {
+	var data any = e.Album
+	if e.Type == EventAlbumDeleted {
+		data = map[string]string{"id": e.Album.ID}
+	}
+	id := uuid.NewString()
+	body, err := json.Marshal(brokerMessage{
+		SpecVersion: "1.0", ID: id, Source: ServiceName,
+		Type: e.Type, Subject: e.Album.ID, Time: time.Now().UTC(),
+		DataContentType: "application/json", Tenant: e.Tenant, Data: data,
+	})
+	if err != nil {
+		slog.Error("encoding broker message", "event", e.Type, "err", err)
+		return
+	}
+
+	o.mu.Lock()
+	o.pending = append(o.pending, outboxEntry{seq: e.ID, id: id, typ: e.Type, key: e.Album.ID, body: body})
+	if over := len(o.pending) - o.size; over > 0 {
+		o.pending = o.pending[over:]
+		slog.Warn("broker outbox full; dropped oldest events", "dropped", over)
+	}
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes pending events until the outbox closes.
+func (o *Outbox) run() {
+	defer o.wg.Done()
+	backoff := time.Duration(0)
+	for {
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-o.done:
+				return
+			}
+		}
+		err := o.flush()
+		o.mu.Lock()
+		o.failing = err
+		o.mu.Unlock()
+		switch {
+		case err == nil:
+			if backoff > 0 {
+				slog.Info("broker available again; buffered events published")
+			}
+			backoff = 0
+			select {
+			case <-o.wake:
+			case <-o.done:
+				return
+			}
+		case backoff == 0:
+			slog.Warn("broker unavailable; buffering events", "err", err)
+			backoff = time.Second
+		default:
+			backoff = min(2*backoff, brokerRetryMax)
+		}
+	}
+}
+
+// flush publishes pending events oldest first, stopping at the first
+// failure so order is kept.
+func (o *Outbox) flush() error {
+	for {
+		o.mu.Lock()
+		if len(o.pending) == 0 {
+			o.mu.Unlock()
+			return nil
+		}
+		e := o.pending[0]
+		o.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+		err := o.pub.publish(ctx, e)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		o.mu.Lock()
+		// add may have dropped e meanwhile.
+		if len(o.pending) > 0 && o.pending[0].seq == e.seq {
+			o.pending = o.pending[1:]
+		}
+		o.mu.Unlock()
+	}
+}
+
+// Ping reports the broker unavailable while events cannot be published to
+// it.
+func (o *Outbox) Ping(context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.failing != nil {
+		return fmt.Errorf("%w; %d events waiting", o.failing, len(o.pending))
+	}
+	return nil
+}
+
+// Close stops following events, makes one last attempt to publish what is
+// pending within timeout and disconnects. Events still unpublished are
+// logged as lost.
+func (o *Outbox) Close(timeout time.Duration) {
+	close(o.done)
+	o.wg.Wait()
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- o.flush() }()
+	var err error
+	select {
+	case err = <-flushed:
+	case <-time.After(timeout):
+		err = errors.New("timed out")
+	}
+	o.mu.Lock()
+	lost := len(o.pending)
+	o.mu.Unlock()
+	if err != nil && lost > 0 {
+		slog.Error("broker events not published", "count", lost, "err", err)
+	}
+	if err := o.pub.close(); err != nil {
+		slog.Warn("closing broker connection", "err", err)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"sync"
+
+	"pspFileAPI/internal/repository"
+)
+
+// Album event types.
+const (
+	EventAlbumCreated  = "album.created"
+	EventAlbumUpdated  = "album.updated"
+	EventAlbumDeleted  = "album.deleted"
+	EventAlbumRestored = "album.restored"
+)
+
+const (
+	// eventHistory is how many recent events are kept for clients that
+	// resume with Last-Event-ID.
+	eventHistory = 256
+	// eventBuffer is how many events a subscriber may fall behind by
+	// before it is disconnected to resume later.
+	eventBuffer = 64
+)
+
+// Event records a change to an album of Tenant.
+type Event struct {
+	ID     uint64
+	Type   string
+	Tenant string
+	Album  repository.Album
+}
+
+// Events fans album events out to subscribers and keeps the most recent
+// ones for replay. It only sees changes made through this process.
+type Events struct {
+	mu      sync.Mutex
+	nextID  uint64
+	history []Event
+	// subs maps each subscriber to the tenant it follows, "" for all.
+	subs map[chan Event]string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewEvents returns a hub with no events or subscribers.
+func NewEvents() *Events {
+	return &Events{nextID: 1, subs: make(map[chan Event]string), done: make(chan struct{})}
+}
+
+// Publish records an event of type typ for tenant's album a and sends it
+// to every subscriber. Subscribers too far behind are dropped.
+func (h *Events) Publish(tenant, typ string, a repository.Album) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e := Event{ID: h.nextID, Type: typ, Tenant: tenant, Album: a}
+	h.nextID++
+	h.history = append(h.history, e)
+	if len(h.history) > eventHistory {
+		h.history = h.history[len(h.history)-eventHistory:]
+	}
+	for ch, t := range h.subs {
+		if t != "" && t != tenant {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns tenant's kept events after lastID and a channel of
+// later ones; an empty tenant follows every tenant. A lastID the hub does
+// not know, such as one from before a restart, replays everything kept.
+func (h *Events) Subscribe(tenant string, lastID uint64) ([]Event, chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, eventBuffer)
+	h.subs[ch] = tenant
+	if lastID >= h.nextID {
+		lastID = 0
+	}
+	var replay []Event
+	for _, e := range h.history {
+		if e.ID > lastID && (tenant == "" || e.Tenant == tenant) {
+			replay = append(replay, e)
+		}
+	}
+	return replay, ch
+}
+
+// Unsubscribe stops sending events to ch.
+func (h *Events) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Follow calls fn with every tenant's events published from now on, in
+// order, until done is closed. A follower that falls behind is dropped
+// like any subscriber and subscribes again from the last event it saw.
+func (h *Events) Follow(done <-chan struct{}, fn func(Event)) {
+	h.mu.Lock()
+	last := h.nextID - 1
+	h.mu.Unlock()
+	for {
+		replay, ch := h.Subscribe("", last)
+		for _, e := range replay {
+			fn(e)
+			last = e.ID
+		}
+	events:
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					break events
+				}
+				fn(e)
+				last = e.ID
+			case <-done:
+				h.Unsubscribe(ch)
+				return
+			}
+		}
+	}
+}
+
+// Close ends every event stream so the server can shut down.
+func (h *Events) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Done is closed once the hub is.
+func (h *Events) Done() <-chan struct{} {
+	return h.done
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pspFileAPI/internal/tenant"
+)
+
+// Job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Errors returned by Jobs.Enqueue.
+var (
+	ErrJobQueueFull = errors.New("job queue is full")
+	ErrJobsStopped  = errors.New("server is shutting down")
+)
+
+// Job is work run in the background and the status clients poll.
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Result     any        `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+
+	// Tenant is the tenant that queued the job; only it can see the job.
+	Tenant string `json:"-"`
+	run    func(ctx context.Context) (any, error)
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Jobs runs jobs on a fixed pool of workers and remembers their
+// outcome for a while. Jobs live in memory, so each instance only knows
+// its own.
+type Jobs struct {
+	tasks     chan *Job
+	retention time.Duration
+	wg        sync.WaitGroup
+
+	// Finished, if set, is told about every job that finishes. It must be
+	// set before the first job is enqueued.
+	Finished func(Job)
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	stopped bool
+}
+
+// NewJobs starts workers that run up to size queued jobs. Finished jobs
+// are forgotten after retention.
+func NewJobs(workers, size int, retention time.Duration) *Jobs {
+	q := &Jobs{
+		tasks:     make(chan *Job, size),
+		retention: retention,
+		jobs:      make(map[string]*Job),
+	}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue queues run and returns the new job. run gets a context carrying
+// the values of ctx, such as the request ID, but not its cancellation, so
+// it outlives the request.
+func (q *Jobs) Enqueue(ctx context.Context, run func(ctx context.Context) (any, error)) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return Job{}, ErrJobsStopped
+	}
+
+	now := time.Now()
+	for id, j := range q.jobs {
+		if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > q.retention {
+			delete(q.jobs, id)
+		}
+	}
+
+	j := &Job{ID: uuid.NewString(), Status: JobQueued, CreatedAt: now, Tenant: tenant.From(ctx), run: run}
+	j.ctx, j.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	select {
+	case q.tasks <- j:
+	default:
+		j.cancel()
+		return Job{}, ErrJobQueueFull
+	}
+	q.jobs[j.ID] = j
+	return *j, nil
+}
+
+// Get returns a snapshot of the job with id.
+func (q *Jobs) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// work runs queued jobs until the queue is closed.
+func (q *Jobs) work() {
+	defer q.wg.Done()
+	for j := range q.tasks {
+		q.mu.Lock()
+		now := time.Now()
+		j.StartedAt = &now
+		j.Status = JobRunning
+		q.mu.Unlock()
+
+		var result any
+		err := j.ctx.Err()
+		if err == nil {
+			result, err = j.run(j.ctx)
+		}
+		j.cancel()
+
+		q.mu.Lock()
+		now = time.Now()
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status, j.Error = JobFailed, err.Error()
+			slog.WarnContext(j.ctx, "job failed", "job_id", j.ID, "err", err)
+		} else {
+			j.Status, j.Result = JobSucceeded, result
+		}
+		snapshot := *j
+		q.mu.Unlock()
+		if q.Finished != nil {
+			q.Finished(snapshot)
+		}
+	}
+}
+
+// Drain stops accepting jobs and waits up to timeout for queued and
+// running ones to finish, then cancels those left and waits for them.
+func (q *Jobs) Drain(timeout time.Duration) {
+	q.mu.Lock()
+	q.stopped = true
+	close(q.tasks)
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	q.mu.Lock()
+	var cancelled int
+	for _, j := range q.jobs {
+		if j.FinishedAt == nil {
+			j.cancel()
+			cancelled++
+		}
+	}
+	q.mu.Unlock()
+	slog.Warn("cancelling unfinished jobs", "count", cancelled)
+	<-done
+}
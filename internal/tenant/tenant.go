@@ -0,0 +1,38 @@
+// Package tenant carries the tenant a request acts for through its
+// context, so every layer can scope what it touches to that tenant.
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// Default owns everything when tenancy is off, and requests that name no
+// tenant act for it.
+const Default = "default"
+
+// validID matches tenant IDs: a DNS label, so any of them can also be a
+// subdomain, and safe to put in storage keys.
+var validID = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Valid reports whether id may name a tenant.
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// key is the context key for the tenant a request acts for.
+type key struct{}
+
+// From returns the tenant the request carrying ctx acts for, Default if
+// none was resolved.
+func From(ctx context.Context) string {
+	if t, ok := ctx.Value(key{}).(string); ok {
+		return t
+	}
+	return Default
+}
+
+// With returns ctx acting for tenant.
+func With(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, key{}, tenant)
+}